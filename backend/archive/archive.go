@@ -0,0 +1,204 @@
+// Package archive 负责发射数据的导出和导入，HTTP 接口和命令行共用同一套格式。
+//
+// JSON 导出是完整的备份格式，可以在其他服务器上导入，结构如下：
+//
+//	{
+//	  "format": "launchcounter-export",   // 固定值，用于识别文件
+//	  "version": 1,                       // 格式版本，不兼容的修改才会增加
+//	  "exported_at": "2024-03-05T12:00:00Z",
+//	  "user": {"username": "alice", "timezone": "Asia/Shanghai"},
+//	  "total": 2,                         // 事件数量，用于校验文件完整性
+//	  "events": [                         // 按发射时间排序
+//	    {"event_id": "e1", "device": "phone", "launched_at": "2024-03-05T09:30:00+08:00"},
+//	    {"event_id": "e2", "device": "", "launched_at": "2024-03-05T21:00:00Z", "timezone": "Europe/Berlin"}
+//	  ]
+//	}
+//
+// user.timezone 是导出时用户统计数据所用的时区；事件的 timezone 只在事件记录了自己的时区时出现，
+// 含义与 models.LaunchEvent 相同。CSV 同样可以导入，iCalendar 只用于查看。
+package archive
+
+import (
+	"backend/models"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FormatName 是 JSON 导出文件中 format 字段的固定取值。
+const FormatName = "launchcounter-export"
+
+// Version 是当前的 JSON 导出格式版本。
+const Version = 1
+
+// 支持的导出格式。
+const (
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+	FormatICS  = "ics"
+)
+
+// CSV 和 iCalendar 导出的行粒度：每个事件一行，或每天一行。
+const (
+	RowsEvent = "event"
+	RowsDay   = "day"
+)
+
+// Export 是一个用户的完整导出数据，也是 JSON 导出文件的结构。
+type Export struct {
+	Format     string    `json:"format"`
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exported_at"`
+	User       User      `json:"user"`
+	Total      int       `json:"total"`
+	Events     []Event   `json:"events"`
+}
+
+// User 是导出文件中的用户信息，不包含密码哈希等凭据。
+type User struct {
+	Username string `json:"username"`
+	Timezone string `json:"timezone"`
+}
+
+// Event 是导出文件中的一个发射事件。
+type Event struct {
+	EventID    string    `json:"event_id"`
+	Device     string    `json:"device"`
+	LaunchedAt time.Time `json:"launched_at"`
+	Timezone   string    `json:"timezone,omitempty"`
+}
+
+// NewExport 根据用户和其全部发射事件创建导出数据。
+func NewExport(user models.User, events []models.LaunchEvent, now time.Time) *Export {
+	loc := models.UserLocation(user.Timezone)
+	x := &Export{
+		Format:     FormatName,
+		Version:    Version,
+		ExportedAt: now.UTC().Truncate(time.Second),
+		User:       User{Username: user.Username, Timezone: loc.String()},
+		Total:      len(events),
+		Events:     make([]Event, 0, len(events)),
+	}
+	for _, e := range events {
+		x.Events = append(x.Events, Event{
+			EventID: e.EventID,
+			Device:  e.Device,
+			// 以事件统计所用时区的本地时间表示，便于阅读，时刻本身不变
+			LaunchedAt: e.LaunchedAt.In(e.Location(loc)),
+			Timezone:   e.Timezone,
+		})
+	}
+	sort.SliceStable(x.Events, func(i, j int) bool { return x.Events[i].LaunchedAt.Before(x.Events[j].LaunchedAt) })
+	return x
+}
+
+// ValidFormat 判断 format 是否是支持的导出格式。
+func ValidFormat(format string) bool {
+	return format == FormatCSV || format == FormatJSON || format == FormatICS
+}
+
+// FormatFromPath 根据文件扩展名（.csv、.json、.ics，不区分大小写）确定导出格式。
+func FormatFromPath(path string) (string, error) {
+	format := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	if !ValidFormat(format) {
+		return "", fmt.Errorf("无法根据扩展名确定格式，请使用 .csv、.json 或 .ics: %s", path)
+	}
+	return format, nil
+}
+
+// ContentType 返回导出格式对应的 MIME 类型。
+func ContentType(format string) string {
+	switch format {
+	case FormatCSV:
+		return "text/csv; charset=utf-8"
+	case FormatICS:
+		return "text/calendar; charset=utf-8"
+	default:
+		return "application/json; charset=utf-8"
+	}
+}
+
+// Write 以指定格式写出导出数据。
+// 参数 rows 决定 CSV 和 iCalendar 的粒度（RowsEvent 或 RowsDay），为空时 CSV 每个事件一行、iCalendar 每天一个日程，
+// 对 JSON 没有影响。
+func (x *Export) Write(w io.Writer, format, rows string) error {
+	if rows != "" && rows != RowsEvent && rows != RowsDay {
+		return fmt.Errorf("无效的行粒度: %s", rows)
+	}
+	switch format {
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(x)
+	case FormatCSV:
+		if rows == RowsDay {
+			return x.writeDayCSV(w)
+		}
+		return x.writeEventCSV(w)
+	case FormatICS:
+		return x.writeICS(w, rows == RowsEvent)
+	default:
+		return fmt.Errorf("不支持的导出格式: %s", format)
+	}
+}
+
+// writeEventCSV 每个事件写一行，日期和时间是事件统计所用时区下的本地时间。
+func (x *Export) writeEventCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"event_id", "launched_at", "date", "time", "timezone", "device"})
+	for _, e := range x.Events {
+		cw.Write([]string{
+			e.EventID,
+			e.LaunchedAt.Format(time.RFC3339),
+			e.LaunchedAt.Format("2006-01-02"),
+			e.LaunchedAt.Format("15:04:05"),
+			e.LaunchedAt.Location().String(),
+			e.Device,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeDayCSV 每个有发射的日期写一行，日期补零以便表格软件识别和排序。
+func (x *Export) writeDayCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"date", "count"})
+	for _, day := range x.days() {
+		cw.Write([]string{day.date.Format("2006-01-02"), strconv.Itoa(day.count)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// dayCount 是某一天的发射次数。
+type dayCount struct {
+	date  time.Time
+	count int
+}
+
+// days 按日期顺序返回每个有发射的日期及次数，日期的划分与 day_data 一致。
+func (x *Export) days() []dayCount {
+	counts := make(map[string]int)
+	for _, e := range x.Events {
+		counts[e.LaunchedAt.Format("2006-01-02")]++
+	}
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	days := make([]dayCount, 0, len(keys))
+	for _, key := range keys {
+		date, _ := time.Parse("2006-01-02", key)
+		days = append(days, dayCount{date: date, count: counts[key]})
+	}
+	return days
+}
@@ -0,0 +1,78 @@
+package archive
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// icsTimestamp 是 iCalendar 中 UTC 时间的格式。
+const icsTimestamp = "20060102T150405Z"
+
+// writeICS 按 RFC 5545 写出 iCalendar 日历。
+// 默认每个有发射的日期生成一个全天日程；perEvent 为 true 时每个事件生成一个没有时长的日程。
+func (x *Export) writeICS(w io.Writer, perEvent bool) error {
+	bw := bufio.NewWriter(w)
+	line := func(format string, args ...interface{}) {
+		writeICSLine(bw, fmt.Sprintf(format, args...))
+	}
+	stamp := x.ExportedAt.UTC().Format(icsTimestamp)
+	// UID 在同一服务器的同一用户下唯一，重复导入同一文件时日历软件会更新而不是重复添加
+	uidSuffix := "@" + FormatName
+
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//LaunchCounter//Export %d//ZH", Version)
+	line("CALSCALE:GREGORIAN")
+	line("X-WR-CALNAME:%s", escapeICSText("LaunchCounter - "+x.User.Username))
+	line("X-WR-TIMEZONE:%s", x.User.Timezone)
+	if perEvent {
+		for _, e := range x.Events {
+			line("BEGIN:VEVENT")
+			line("UID:%s", escapeICSText(x.User.Username+"."+e.EventID+uidSuffix))
+			line("DTSTAMP:%s", stamp)
+			line("DTSTART:%s", e.LaunchedAt.UTC().Format(icsTimestamp))
+			line("SUMMARY:%s", escapeICSText("发射"))
+			if e.Device != "" {
+				line("DESCRIPTION:%s", escapeICSText("设备: "+e.Device))
+			}
+			line("END:VEVENT")
+		}
+	} else {
+		for _, day := range x.days() {
+			line("BEGIN:VEVENT")
+			line("UID:%s", escapeICSText(x.User.Username+"."+day.date.Format("20060102")+uidSuffix))
+			line("DTSTAMP:%s", stamp)
+			line("DTSTART;VALUE=DATE:%s", day.date.Format("20060102"))
+			line("DTEND;VALUE=DATE:%s", day.date.AddDate(0, 0, 1).Format("20060102"))
+			line("SUMMARY:%s", escapeICSText(fmt.Sprintf("发射 %d 次", day.count)))
+			line("TRANSP:TRANSPARENT")
+			line("END:VEVENT")
+		}
+	}
+	line("END:VCALENDAR")
+	return bw.Flush()
+}
+
+// writeICSLine 写出一行内容，超过 75 个字节时按 RFC 5545 折行，折行不会拆开多字节字符。
+func writeICSLine(w *bufio.Writer, s string) {
+	const limit = 75
+	width := 0
+	for _, r := range s {
+		size := len(string(r))
+		if width+size > limit {
+			// 续行以一个空格开头，空格计入续行的长度
+			w.WriteString("\r\n ")
+			width = 1
+		}
+		w.WriteRune(r)
+		width += size
+	}
+	w.WriteString("\r\n")
+}
+
+// escapeICSText 转义 TEXT 类型取值中的反斜杠、分号、逗号和换行。
+func escapeICSText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
@@ -0,0 +1,347 @@
+package archive
+
+import (
+	"backend/models"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 导入时与已有数据的合并方式，均以天为单位，天的划分与 day_data 一致。
+const (
+	// ModeAdd 追加文件中的全部事件，当天的次数为两者之和
+	ModeAdd = "add"
+	// ModeReplace 文件中出现的日期以文件为准，删除这些日期上已有的其他事件，其余日期保持不变
+	ModeReplace = "replace"
+	// ModeMax 每天取已有次数和文件中次数的较大者，只为不足的部分追加事件
+	ModeMax = "max"
+)
+
+// MaxImportEvents 是单个导入文件最多包含的事件数量。
+const MaxImportEvents = 100000
+
+// importDevice 是按天导入时生成的事件使用的设备标识。
+const importDevice = "import"
+
+// ValidMode 判断 mode 是否是支持的合并方式。
+func ValidMode(mode string) bool {
+	return mode == ModeAdd || mode == ModeReplace || mode == ModeMax
+}
+
+// Parse 读取 JSON 或 CSV 格式的导出文件，返回其中的发射事件，iCalendar 文件不能导入。
+// CSV 可以是每个事件一行（至少包含 launched_at 列）或每天一行（date 和 count 两列），
+// 按天导入时每次发射记为当天 12:00，事件 ID 由日期和序号生成，重复导入同一文件不会重复计数。
+// 参数 loc 是导入目标用户的时区：按天导入的日期按它解析；
+// 文件中的事件使用的时区与它不同时，事件会记录原来的时区，统计结果与导出时一致。
+func Parse(r io.Reader, format string, loc *time.Location) ([]models.LaunchEvent, error) {
+	switch format {
+	case FormatJSON:
+		return parseJSON(r, loc)
+	case FormatCSV:
+		return parseCSV(r, loc)
+	case FormatICS:
+		return nil, errors.New("iCalendar 格式只用于查看，不能导入")
+	default:
+		return nil, fmt.Errorf("不支持的导入格式: %s", format)
+	}
+}
+
+// parseJSON 读取 JSON 导出文件，文件必须是本服务导出的格式且版本不高于当前版本。
+func parseJSON(r io.Reader, loc *time.Location) ([]models.LaunchEvent, error) {
+	var x Export
+	decoder := json.NewDecoder(r)
+	if err := decoder.Decode(&x); err != nil {
+		return nil, fmt.Errorf("解析 JSON 失败: %v", err)
+	}
+	if x.Format != FormatName {
+		return nil, fmt.Errorf("不是 LaunchCounter 导出文件（format 应为 %s）", FormatName)
+	}
+	if x.Version < 1 || x.Version > Version {
+		return nil, fmt.Errorf("不支持的导出格式版本: %d", x.Version)
+	}
+	if x.Total != len(x.Events) {
+		return nil, fmt.Errorf("文件不完整: total 为 %d，实际包含 %d 个事件", x.Total, len(x.Events))
+	}
+	if len(x.Events) > MaxImportEvents {
+		return nil, fmt.Errorf("单次最多导入 %d 个事件", MaxImportEvents)
+	}
+	// 导出时跟随用户时区的事件按导出用户的时区统计
+	if x.User.Timezone != "" && models.ValidateTimezone(x.User.Timezone) != nil {
+		return nil, fmt.Errorf("无效的时区: %s", x.User.Timezone)
+	}
+
+	events := make([]models.LaunchEvent, 0, len(x.Events))
+	for _, e := range x.Events {
+		timezone := e.Timezone
+		if timezone == "" {
+			timezone = x.User.Timezone
+		}
+		events = append(events, models.LaunchEvent{
+			EventID:    e.EventID,
+			Device:     e.Device,
+			LaunchedAt: e.LaunchedAt,
+			Timezone:   eventTimezone(timezone, loc),
+		})
+	}
+	return events, nil
+}
+
+// parseCSV 根据表头判断 CSV 是每个事件一行还是每天一行，列的顺序不限，未知的列被忽略。
+func parseCSV(r io.Reader, loc *time.Location) ([]models.LaunchEvent, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("CSV 文件为空")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("解析 CSV 失败: %v", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		// 表格软件保存的文件可能以 BOM 开头
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		columns[name] = i
+	}
+
+	_, hasLaunchedAt := columns["launched_at"]
+	_, hasDate := columns["date"]
+	_, hasCount := columns["count"]
+	switch {
+	case hasLaunchedAt:
+		return parseEventCSV(cr, columns, loc)
+	case hasDate && hasCount:
+		return parseDayCSV(cr, columns, loc)
+	default:
+		return nil, errors.New("无法识别 CSV 表头，需要包含 launched_at 列，或 date 和 count 两列")
+	}
+}
+
+// parseEventCSV 读取每个事件一行的 CSV，launched_at 必须是带时区偏移的 RFC 3339 时间。
+// 没有 event_id 列或该列为空时，根据发射时间生成事件 ID。
+func parseEventCSV(cr *csv.Reader, columns map[string]int, loc *time.Location) ([]models.LaunchEvent, error) {
+	var events []models.LaunchEvent
+	// 同一时刻的多次发射依次编号，保证生成的事件 ID 不重复
+	generated := make(map[string]int)
+	for line := 2; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("解析 CSV 失败: %v", err)
+		}
+		if len(events) == MaxImportEvents {
+			return nil, fmt.Errorf("单次最多导入 %d 个事件", MaxImportEvents)
+		}
+
+		launchedAt, err := time.Parse(time.RFC3339, csvField(record, columns, "launched_at"))
+		if err != nil {
+			return nil, fmt.Errorf("第 %d 行: 无效的发射时间，应为 RFC 3339 格式，如 2024-03-05T09:30:00+08:00", line)
+		}
+		timezone := csvField(record, columns, "timezone")
+		if timezone != "" && models.ValidateTimezone(timezone) != nil {
+			return nil, fmt.Errorf("第 %d 行: 无效的时区 %s", line, timezone)
+		}
+		id := csvField(record, columns, "event_id")
+		if id == "" {
+			key := strconv.FormatInt(launchedAt.UnixNano(), 10)
+			id = fmt.Sprintf("import-%s-%d", key, generated[key])
+			generated[key]++
+		}
+		events = append(events, models.LaunchEvent{
+			EventID:    id,
+			Device:     csvField(record, columns, "device"),
+			LaunchedAt: launchedAt,
+			Timezone:   eventTimezone(timezone, loc),
+		})
+	}
+}
+
+// parseDayCSV 读取每天一行的 CSV，日期格式为 2024-03-05 或 2024-3-5，同一日期出现多次时次数相加。
+func parseDayCSV(cr *csv.Reader, columns map[string]int, loc *time.Location) ([]models.LaunchEvent, error) {
+	counts := make(map[string]int)
+	total := 0
+	for line := 2; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("解析 CSV 失败: %v", err)
+		}
+		day, err := models.ParseDayKey(csvField(record, columns, "date"), loc)
+		if err != nil {
+			return nil, fmt.Errorf("第 %d 行: 无效的日期，应为 2024-03-05 格式", line)
+		}
+		count, err := strconv.Atoi(csvField(record, columns, "count"))
+		if err != nil || count < 0 {
+			return nil, fmt.Errorf("第 %d 行: 次数必须是非负整数", line)
+		}
+		if total += count; total > MaxImportEvents {
+			return nil, fmt.Errorf("单次最多导入 %d 个事件", MaxImportEvents)
+		}
+		counts[models.DayKey(day)] += count
+	}
+
+	days := make([]string, 0, len(counts))
+	for day := range counts {
+		days = append(days, day)
+	}
+	sort.Strings(days)
+
+	events := make([]models.LaunchEvent, 0, total)
+	for _, day := range days {
+		start, _ := models.ParseDayKey(day, loc)
+		for i := 0; i < counts[day]; i++ {
+			events = append(events, models.LaunchEvent{
+				EventID:    fmt.Sprintf("import-%s-%d", day, i),
+				Device:     importDevice,
+				LaunchedAt: start.Add(12 * time.Hour),
+			})
+		}
+	}
+	return events, nil
+}
+
+// csvField 返回指定列的取值，该列不存在或该行较短时返回空字符串。
+func csvField(record []string, columns map[string]int, name string) string {
+	i, ok := columns[name]
+	if !ok || i >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[i])
+}
+
+// eventTimezone 返回导入事件应记录的时区：与目标用户的时区相同时为空，使事件跟随用户的时区。
+func eventTimezone(timezone string, loc *time.Location) string {
+	if timezone == loc.String() {
+		return ""
+	}
+	return timezone
+}
+
+// Result 描述一次导入对数据的改动，dry-run 时是将会产生的改动。
+type Result struct {
+	Mode   string `json:"mode"`
+	DryRun bool   `json:"dry_run"`
+	// Read 是文件中的事件数量，Skipped 是已存在、在文件中重复或按合并方式不需要写入的事件数量
+	Read    int `json:"read"`
+	Added   int `json:"added"`
+	Removed int `json:"removed"`
+	Skipped int `json:"skipped"`
+	// TotalBefore 和 TotalAfter 是导入前后的发射总数
+	TotalBefore int `json:"total_before"`
+	TotalAfter  int `json:"total_after"`
+	// Days 按日期顺序列出次数发生变化的日期
+	Days []DayChange `json:"days"`
+}
+
+// DayChange 是某一天导入前后的发射次数，Day 使用 DayKey 格式。
+type DayChange struct {
+	Day    string `json:"day"`
+	Before int    `json:"before"`
+	After  int    `json:"after"`
+}
+
+// Merge 计算按 mode 将 imported 合并到用户已有事件 current 时需要追加的事件和需要删除的事件 ID。
+// 事件 ID 已存在的事件不会重复写入，因此重复导入同一文件是安全的。
+// 参数 loc 是用户的时区，与 day_data 一样，记录了时区的事件按自己的时区划分日期。
+func Merge(current, imported []models.LaunchEvent, mode string, loc *time.Location) ([]models.LaunchEvent, []string, Result) {
+	result := Result{Mode: mode, Read: len(imported), TotalBefore: len(current), Days: []DayChange{}}
+	dayOf := func(e models.LaunchEvent) string {
+		return e.LaunchedAt.In(e.Location(loc)).Format("2006-01-02")
+	}
+
+	existing := make(map[string]bool, len(current))
+	currentDays := make(map[string][]models.LaunchEvent)
+	for _, e := range current {
+		existing[e.EventID] = true
+		currentDays[dayOf(e)] = append(currentDays[dayOf(e)], e)
+	}
+	// 文件中重复的事件 ID 只保留第一个
+	seen := make(map[string]bool, len(imported))
+	importedDays := make(map[string][]models.LaunchEvent)
+	for _, e := range imported {
+		if seen[e.EventID] {
+			continue
+		}
+		seen[e.EventID] = true
+		importedDays[dayOf(e)] = append(importedDays[dayOf(e)], e)
+	}
+	days := make([]string, 0, len(importedDays))
+	for day := range importedDays {
+		days = append(days, day)
+	}
+	sort.Strings(days)
+
+	var add []models.LaunchEvent
+	var remove []string
+	for _, day := range days {
+		dayEvents := importedDays[day]
+		sort.SliceStable(dayEvents, func(i, j int) bool { return dayEvents[i].LaunchedAt.Before(dayEvents[j].LaunchedAt) })
+		// limited 为 true 时当天最多再追加 need 个事件，只有 max 方式有这个限制
+		limited, need := false, 0
+		switch mode {
+		case ModeMax:
+			// 服务端当天的次数已经不少于文件时不追加任何事件
+			limited, need = true, len(dayEvents)-len(currentDays[day])
+			if need < 0 {
+				need = 0
+			}
+		case ModeReplace:
+			// 文件中没有的已有事件被删除；文件中的事件若已存在则原样保留
+			for _, e := range currentDays[day] {
+				if !seen[e.EventID] {
+					remove = append(remove, e.EventID)
+				}
+			}
+		}
+		for _, e := range dayEvents {
+			if existing[e.EventID] || (limited && need == 0) {
+				continue
+			}
+			add = append(add, e)
+			need--
+		}
+	}
+
+	// 统计每天的变化
+	changes := make(map[string]int)
+	removed := make(map[string]bool, len(remove))
+	for _, id := range remove {
+		removed[id] = true
+	}
+	for _, e := range current {
+		if removed[e.EventID] {
+			changes[dayOf(e)]--
+		}
+	}
+	for _, e := range add {
+		changes[dayOf(e)]++
+	}
+	changed := make([]string, 0, len(changes))
+	for day, n := range changes {
+		if n != 0 {
+			changed = append(changed, day)
+		}
+	}
+	sort.Strings(changed)
+	for _, day := range changed {
+		before := len(currentDays[day])
+		t, _ := time.Parse("2006-01-02", day)
+		result.Days = append(result.Days, DayChange{Day: models.DayKey(t), Before: before, After: before + changes[day]})
+	}
+
+	result.Added = len(add)
+	result.Removed = len(remove)
+	result.Skipped = len(imported) - len(add)
+	result.TotalAfter = len(current) - len(remove) + len(add)
+	return add, remove, result
+}
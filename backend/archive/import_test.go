@@ -0,0 +1,121 @@
+package archive
+
+import (
+	"backend/models"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestMerge(t *testing.T) {
+	loc := time.FixedZone("UTC+8", 8*3600)
+	// ev 创建 3 月 day 日 hour 时的事件
+	ev := func(id string, day, hour int) models.LaunchEvent {
+		return models.LaunchEvent{EventID: id, LaunchedAt: time.Date(2024, 3, day, hour, 0, 0, 0, loc)}
+	}
+
+	tests := []struct {
+		name     string
+		mode     string
+		current  []models.LaunchEvent
+		imported []models.LaunchEvent
+		add      []string
+		remove   []string
+		days     []DayChange
+	}{
+		{
+			name:     "add 不重叠",
+			mode:     ModeAdd,
+			current:  []models.LaunchEvent{ev("a", 1, 9)},
+			imported: []models.LaunchEvent{ev("x", 1, 10), ev("y", 2, 9)},
+			add:      []string{"x", "y"},
+			days:     []DayChange{{"2024-3-1", 1, 2}, {"2024-3-2", 0, 1}},
+		},
+		{
+			name:     "add 跳过已存在和文件中重复的 ID",
+			mode:     ModeAdd,
+			current:  []models.LaunchEvent{ev("a", 1, 9)},
+			imported: []models.LaunchEvent{ev("a", 1, 9), ev("x", 1, 10), ev("x", 1, 10)},
+			add:      []string{"x"},
+			days:     []DayChange{{"2024-3-1", 1, 2}},
+		},
+		{
+			name:     "replace 删除文件中没有的事件并保留重叠的事件",
+			mode:     ModeReplace,
+			current:  []models.LaunchEvent{ev("a", 1, 9), ev("b", 1, 10), ev("c", 2, 9)},
+			imported: []models.LaunchEvent{ev("a", 1, 9), ev("x", 1, 11)},
+			add:      []string{"x"},
+			remove:   []string{"b"},
+			days:     []DayChange{},
+		},
+		{
+			name:     "replace 文件当天次数较少",
+			mode:     ModeReplace,
+			current:  []models.LaunchEvent{ev("a", 1, 9), ev("b", 1, 10), ev("c", 1, 11)},
+			imported: []models.LaunchEvent{ev("x", 1, 12)},
+			add:      []string{"x"},
+			remove:   []string{"a", "b", "c"},
+			days:     []DayChange{{"2024-3-1", 3, 1}},
+		},
+		{
+			name:     "max 文件当天次数较多",
+			mode:     ModeMax,
+			current:  []models.LaunchEvent{ev("a", 1, 9)},
+			imported: []models.LaunchEvent{ev("x", 1, 10), ev("y", 1, 11), ev("z", 1, 12)},
+			add:      []string{"x", "y"},
+			days:     []DayChange{{"2024-3-1", 1, 3}},
+		},
+		{
+			name:     "max 重叠的 ID 计入两边",
+			mode:     ModeMax,
+			current:  []models.LaunchEvent{ev("a", 1, 9), ev("b", 1, 10)},
+			imported: []models.LaunchEvent{ev("a", 1, 9), ev("x", 1, 11), ev("y", 1, 12)},
+			add:      []string{"x"},
+			days:     []DayChange{{"2024-3-1", 2, 3}},
+		},
+		{
+			name: "max 服务端当天次数较多",
+			mode: ModeMax,
+			current: []models.LaunchEvent{
+				ev("a", 1, 9), ev("b", 1, 10), ev("c", 1, 11), ev("d", 1, 12), ev("e", 1, 13),
+			},
+			imported: []models.LaunchEvent{ev("x", 1, 14), ev("y", 1, 15), ev("z", 1, 16), ev("w", 2, 9)},
+			add:      []string{"w"},
+			days:     []DayChange{{"2024-3-2", 0, 1}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			add, remove, result := Merge(tt.current, tt.imported, tt.mode, loc)
+
+			addIDs := make([]string, 0, len(add))
+			for _, e := range add {
+				addIDs = append(addIDs, e.EventID)
+			}
+			if got, want := sorted(addIDs), sorted(tt.add); !reflect.DeepEqual(got, want) {
+				t.Errorf("追加 %v，期望 %v", got, want)
+			}
+			if got, want := sorted(remove), sorted(tt.remove); !reflect.DeepEqual(got, want) {
+				t.Errorf("删除 %v，期望 %v", got, want)
+			}
+			if !reflect.DeepEqual(result.Days, tt.days) {
+				t.Errorf("每天的变化 %v，期望 %v", result.Days, tt.days)
+			}
+			if result.Added != len(tt.add) || result.Removed != len(tt.remove) {
+				t.Errorf("新增 %d 删除 %d，期望 %d 和 %d", result.Added, result.Removed, len(tt.add), len(tt.remove))
+			}
+			if after := len(tt.current) - len(tt.remove) + len(tt.add); result.TotalAfter != after {
+				t.Errorf("导入后总数 %d，期望 %d", result.TotalAfter, after)
+			}
+		})
+	}
+}
+
+// sorted 返回排好序的副本，nil 与空切片视为相同。
+func sorted(ids []string) []string {
+	cp := append([]string{}, ids...)
+	sort.Strings(cp)
+	return cp
+}
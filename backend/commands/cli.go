@@ -2,23 +2,28 @@ package commands
 
 import (
 	"bufio"
-	"database/sql"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
+	"backend/archive"
 	"backend/models"
+	"backend/store"
+	"backend/tokens"
 	"golang.org/x/crypto/bcrypt"
 )
 
 // 启动命令行界面
 // StartCLI 启动后端管理控制台的命令行界面，允许管理员执行用户管理等操作。
-// 参数 db 是数据库连接，用于执行与用户相关的数据库操作。
-// 参数 clients 是指向在线客户端映射的指针，键为用户 ID，值为客户端实例切片。
-// 参数 lock 是读写锁，用于保证对在线客户端映射的并发安全访问。
-func StartCLI(db *sql.DB, clients *map[int][]*models.Client, lock *sync.RWMutex) {
+// 参数 st 是存储后端，用于执行与用户相关的操作。
+// 参数 hub 是在线客户端注册表，用于查看在线用户和客户端。
+// 参数 guard 是登录保护器，用于查看和解除因登录失败被锁定的账号。
+// 参数 ring 是访问令牌的密钥环，用于生成和轮换签名密钥。
+// 参数 config 包含应用的配置信息，导入数据时使用与 HTTP 接口相同的校验规则。
+// 输入 exit 命令时返回 true，调用方应随之停止服务；标准输入关闭（如以后台服务运行）时返回 false。
+func StartCLI(st store.Store, hub *models.ClientHub, guard *models.LoginGuard, ring *tokens.Keyring, config *models.Config) bool {
 	// 创建一个新的扫描器，用于从标准输入读取用户输入
 	scanner := bufio.NewScanner(os.Stdin)
 	// 打印启动信息，提示用户输入 'help' 查看可用命令
@@ -28,9 +33,9 @@ func StartCLI(db *sql.DB, clients *map[int][]*models.Client, lock *sync.RWMutex)
 	for {
 		// 打印命令提示符
 		fmt.Print("> ")
-		// 尝试从标准输入读取一行内容，如果读取失败则退出循环
+		// 尝试从标准输入读取一行内容，如果读取失败则结束命令行界面，但不停止服务
 		if !scanner.Scan() {
-			break
+			return false
 		}
 
 		// 去除输入内容两端的空白字符
@@ -48,15 +53,15 @@ func StartCLI(db *sql.DB, clients *map[int][]*models.Client, lock *sync.RWMutex)
 		// 根据不同的命令执行相应的操作
 		switch command {
 		case "exit", "quit":
-			// 打印退出信息并返回，结束命令行界面
-			fmt.Println("退出管理控制台")
-			return
+			// 打印退出信息并返回，结束命令行界面并停止服务
+			fmt.Println("退出管理控制台，正在停止服务")
+			return true
 		case "help":
 			// 调用 printHelp 函数显示帮助信息
 			printHelp()
 		case "list":
 			// 调用 listUsers 函数列出所有用户
-			listUsers(db)
+			listUsers(st)
 		case "create":
 			// 检查输入参数是否足够
 			if len(parts) < 3 {
@@ -64,7 +69,7 @@ func StartCLI(db *sql.DB, clients *map[int][]*models.Client, lock *sync.RWMutex)
 				fmt.Println("用法: create <用户名> <密码>")
 			} else {
 				// 调用 createUser 函数创建新用户
-				createUser(db, parts[1], parts[2])
+				createUser(st, parts[1], parts[2])
 			}
 		case "delete":
 			// 检查输入参数是否足够
@@ -73,7 +78,7 @@ func StartCLI(db *sql.DB, clients *map[int][]*models.Client, lock *sync.RWMutex)
 				fmt.Println("用法: delete <用户名>")
 			} else {
 				// 调用 deleteUser 函数删除指定用户
-				deleteUser(db, parts[1])
+				deleteUser(st, parts[1])
 			}
 		case "passwd":
 			// 检查输入参数是否足够
@@ -82,11 +87,11 @@ func StartCLI(db *sql.DB, clients *map[int][]*models.Client, lock *sync.RWMutex)
 				fmt.Println("用法: passwd <用户名> <新密码>")
 			} else {
 				// 调用 changePassword 函数更改指定用户的密码
-				changePassword(db, parts[1], parts[2])
+				changePassword(st, parts[1], parts[2])
 			}
 		case "online":
 			// 调用 showOnlineUsers 函数显示当前在线用户
-			showOnlineUsers(clients, lock)
+			showOnlineUsers(hub)
 		case "clients":
 			// 检查输入参数是否足够
 			if len(parts) < 2 {
@@ -94,8 +99,53 @@ func StartCLI(db *sql.DB, clients *map[int][]*models.Client, lock *sync.RWMutex)
 				fmt.Println("用法: clients <用户名>")
 			} else {
 				// 调用 showUserClients 函数显示指定用户的在线客户端
-				showUserClients(db, parts[1], clients, lock)
+				showUserClients(st, parts[1], hub)
+			}
+		case "notify":
+			// 检查输入参数是否足够
+			if len(parts) < 3 {
+				// 若参数不足，打印使用说明
+				fmt.Println("用法: notify <用户名> <消息>")
+			} else {
+				// 调用 notifyUser 函数向用户的在线客户端推送通知，消息可以包含空格
+				notifyUser(st, parts[1], strings.Join(parts[2:], " "))
+			}
+		case "lockouts":
+			// 调用 showLockouts 函数显示被锁定的账号
+			showLockouts(guard)
+		case "unlock":
+			// 检查输入参数是否足够
+			if len(parts) < 2 {
+				// 若参数不足，打印使用说明
+				fmt.Println("用法: unlock <用户名>")
+			} else {
+				// 调用 unlockUser 函数解除账号锁定
+				unlockUser(guard, parts[1])
 			}
+		case "export":
+			// 检查输入参数是否足够
+			if len(parts) < 3 {
+				// 若参数不足，打印使用说明
+				fmt.Println("用法: export <用户名> <文件>（格式由扩展名 .json、.csv、.ics 决定）")
+			} else {
+				// 调用 exportUser 函数将用户的发射记录导出到文件
+				exportUser(st, parts[1], parts[2])
+			}
+		case "import":
+			// 检查输入参数是否足够
+			if len(parts) < 3 {
+				// 若参数不足，打印使用说明
+				fmt.Println("用法: import <用户名> <文件> [add|replace|max] [--dry-run]")
+			} else {
+				// 调用 importUser 函数将文件中的发射记录合并到用户的数据中
+				importUser(st, config, parts[1], parts[2], parts[3:])
+			}
+		case "migrate":
+			// 调用 runMigrate 函数查看或执行数据库迁移
+			runMigrate(st, parts[1:])
+		case "keys":
+			// 调用 runKeys 函数查看、生成或轮换令牌签名密钥
+			runKeys(ring, parts[1:])
 		default:
 			// 若输入的命令未知，提示用户输入 'help' 查看可用命令
 			fmt.Println("未知命令，输入 'help' 查看可用命令")
@@ -112,61 +162,50 @@ func printHelp() {
 	fmt.Println("  passwd <user> <pw> - 更改用户密码")
 	fmt.Println("  online             - 显示在线用户")
 	fmt.Println("  clients <user>     - 显示用户在线客户端")
-	fmt.Println("  exit               - 退出管理控制台")
+	fmt.Println("  notify <user> <msg> - 向用户的在线客户端推送通知")
+	fmt.Println("  lockouts           - 显示因登录失败被锁定的账号")
+	fmt.Println("  unlock <user>      - 解除账号锁定")
+	fmt.Println("  export <user> <file> - 导出用户的发射记录，格式由扩展名决定（.json、.csv、.ics）")
+	fmt.Println("  import <user> <file> [add|replace|max] [--dry-run] - 导入 .json 或 .csv 文件，默认追加，--dry-run 只显示改动")
+	fmt.Println("  migrate status     - 显示数据库迁移状态")
+	fmt.Println("  migrate up [ver]   - 执行迁移（默认到最新版本）")
+	fmt.Println("  migrate down [n]   - 回滚最近 n 个迁移（默认 1 个）")
+	fmt.Println("  keys list          - 显示令牌签名密钥")
+	fmt.Println("  keys generate [alg] - 生成待启用的密钥（HS256、EdDSA、RS256）")
+	fmt.Println("  keys rotate [kid|alg] - 启用待启用的密钥或新生成的密钥，旧密钥在令牌过期前仍可校验")
+	fmt.Println("  exit               - 退出管理控制台并停止服务")
 }
 
-// listUsers 函数用于从数据库中查询所有用户信息，并将其打印输出。
-// 参数 db 是数据库连接，用于执行 SQL 查询语句。
-func listUsers(db *sql.DB) {
-	// 执行 SQL 查询语句，从 users 表中选取用户的 ID 和用户名
-	rows, err := db.Query("SELECT id, username FROM users")
+// listUsers 函数用于从存储后端查询所有用户信息，并将其打印输出。
+// 参数 st 是存储后端。
+func listUsers(st store.Store) {
+	// 查询全部用户的 ID 和用户名
+	users, err := st.ListUsers()
 	// 检查查询是否出错
 	if err != nil {
 		// 若出错，记录错误日志并返回，终止函数执行
 		log.Println("查询用户失败:", err)
 		return
 	}
-	// 确保在函数结束时关闭查询结果集，释放资源
-	defer rows.Close()
 
 	// 打印用户列表标题
 	fmt.Println("用户列表:")
 	// 打印表头，包含 ID 和用户名两列
 	fmt.Println("ID\t用户名")
-	// 遍历查询结果集的每一行
-	for rows.Next() {
-		// 定义变量用于存储当前行的用户 ID 和用户名
-		var id int
-		var username string
-		// 将当前行的数据扫描到定义的变量中
-		if err := rows.Scan(&id, &username); err != nil {
-			// 若扫描出错，记录错误日志并跳过当前行，继续处理下一行
-			log.Println("读取用户失败:", err)
-			continue
-		}
-		// 打印当前行的用户 ID 和用户名
-		fmt.Printf("%d\t%s\n", id, username)
+	// 遍历查询结果，打印每个用户的 ID 和用户名
+	for _, user := range users {
+		fmt.Printf("%d\t%s\n", user.ID, user.Username)
 	}
 }
 
-// createUser 函数用于在数据库中创建新用户。
-// 参数 db 是数据库连接，用于执行 SQL 语句。
+// createUser 函数用于创建新用户。
+// 参数 st 是存储后端。
 // 参数 username 是要创建的用户的用户名。
 // 参数 password 是要创建的用户的密码。
-func createUser(db *sql.DB, username, password string) {
-	// 检查用户名是否已存在
-	// 使用 EXISTS 子查询判断 users 表中是否已存在该用户名
-	var exists bool
-	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE username = ?)", username).Scan(&exists)
-	if err != nil {
-		// 若查询出错，打印错误信息并返回，终止用户创建流程
-		fmt.Println("检查用户失败:", err)
-		return
-	}
-
-	if exists {
-		// 若用户名已存在，打印错误信息并返回，终止用户创建流程
-		fmt.Println("错误: 用户名已存在")
+func createUser(st store.Store, username, password string) {
+	// 管理员创建的账号同样需要满足用户名和密码策略
+	if errs := models.ValidateCredentials(username, password); errs != nil {
+		printValidationErrors(errs)
 		return
 	}
 
@@ -179,90 +218,85 @@ func createUser(db *sql.DB, username, password string) {
 		return
 	}
 
-	// 创建用户
-	// 将用户名和哈希后的密码插入到 users 表中
-	result, err := db.Exec("INSERT INTO users (username, password_hash) VALUES (?, ?)", username, hashedPassword)
-	if err != nil {
-		// 若插入用户信息失败，打印错误信息并返回，终止用户创建流程
-		fmt.Println("创建用户失败:", err)
-		return
-	}
-
-	// 获取新创建用户的 ID
-	userID, err := result.LastInsertId()
-	if err != nil {
-		// 若获取用户 ID 失败，打印错误信息并返回，终止用户创建流程
-		fmt.Println("获取用户ID失败:", err)
+	// 创建用户，存储后端会同时为其创建初始发射数据
+	user, err := st.CreateUser(username, string(hashedPassword))
+	if err == store.ErrUserExists {
+		// 若用户名已存在，打印错误信息并返回，终止用户创建流程
+		fmt.Println("错误: 用户名已存在")
 		return
 	}
-
-	// 创建初始发射数据
-	// 为新用户在 launch_data 表中创建一条初始记录，设置总发射次数为 0，各时间段发射数据为空 JSON 对象，最后发射时间为 NULL
-	_, err = db.Exec(`
-		INSERT INTO launch_data (user_id, total, year_data, month_data, day_data, last_launch)
-		VALUES (?, 0, '{}', '{}', '{}', NULL)
-	`, userID)
 	if err != nil {
-		// 若创建发射数据失败，打印错误信息并返回，终止用户创建流程
-		fmt.Println("创建发射数据失败:", err)
+		// 若创建用户失败，打印错误信息并返回，终止用户创建流程
+		fmt.Println("创建用户失败:", err)
 		return
 	}
 
 	// 打印用户创建成功信息，包含用户名和用户 ID
-	fmt.Printf("用户 %s 创建成功, ID: %d\n", username, userID)
+	fmt.Printf("用户 %s 创建成功, ID: %d\n", username, user.ID)
 }
 
-// deleteUser 函数用于从数据库中删除指定用户名的用户。
-// 参数 db 是数据库连接，用于执行 SQL 语句。
-// 参数 username 是要删除的用户的用户名。
-func deleteUser(db *sql.DB, username string) {
-	// 获取用户ID
-	// 执行 SQL 查询，根据用户名从 users 表中获取对应的用户 ID
-	var userID int
-	err := db.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&userID)
+// lookupUser 根据用户名查询用户，查询失败时打印错误信息并返回 false。
+func lookupUser(st store.Store, username string) (models.User, bool) {
+	user, err := st.GetUserByUsername(username)
 	if err != nil {
 		// 检查是否因为用户不存在而导致查询失败
-		if err == sql.ErrNoRows {
-			// 若用户不存在，打印错误信息并返回，终止删除流程
+		if err == store.ErrNotFound {
 			fmt.Println("错误: 用户不存在")
-			return
+			return models.User{}, false
 		}
-		// 若出现其他查询错误，打印错误信息并返回，终止删除流程
 		fmt.Println("查询用户失败:", err)
+		return models.User{}, false
+	}
+	return user, true
+}
+
+// printValidationErrors 逐条打印用户名或密码不符合策略的原因。
+func printValidationErrors(errs models.ValidationErrors) {
+	fmt.Println("错误: 用户名或密码不符合要求")
+	for _, e := range errs {
+		fmt.Printf("  %s: %s\n", e.Field, e.Message)
+	}
+}
+
+// deleteUser 函数用于删除指定用户名的用户。
+// 参数 st 是存储后端。
+// 参数 username 是要删除的用户的用户名。
+func deleteUser(st store.Store, username string) {
+	// 获取用户ID
+	user, ok := lookupUser(st, username)
+	if !ok {
 		return
 	}
 
-	// 删除用户
-	// 执行 SQL 删除语句，根据用户 ID 从 users 表中删除对应的用户记录
-	_, err = db.Exec("DELETE FROM users WHERE id = ?", userID)
-	if err != nil {
+	// 删除用户，其发射数据和事件会一并删除
+	if err := st.DeleteUser(user.ID); err != nil {
 		// 若删除操作失败，打印错误信息并返回，终止删除流程
 		fmt.Println("删除用户失败:", err)
 		return
 	}
 
+	// 用户的会话已随用户一并删除，断开其仍在线的 WebSocket 连接
+	models.DisconnectClients(user.ID, "", "账号已被删除")
+	// 丢弃该用户的推送记录，避免之后复用同一 ID 的用户收到旧数据的增量
+	models.Updates.Forget(user.ID)
+
 	// 打印用户删除成功信息，包含用户名和用户 ID
-	fmt.Printf("用户 %s (ID: %d) 已删除\n", username, userID)
+	fmt.Printf("用户 %s (ID: %d) 已删除\n", username, user.ID)
 }
 
 // changePassword 函数用于更改指定用户的密码。
-// 参数 db 是数据库连接，用于执行 SQL 语句。
+// 参数 st 是存储后端。
 // 参数 username 是要更改密码的用户的用户名。
 // 参数 newPassword 是用户的新密码。
-func changePassword(db *sql.DB, username, newPassword string) {
+func changePassword(st store.Store, username, newPassword string) {
 	// 获取用户ID
-	// 执行 SQL 查询，根据用户名从 users 表中获取对应的用户 ID
-	var userID int
-	err := db.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&userID)
-	if err != nil {
-		// 检查是否因为用户不存在而导致查询失败
-		if err == sql.ErrNoRows {
-			// 若用户不存在，打印错误信息并返回，终止密码更改流程
-			fmt.Println("错误: 用户不存在")
-			return
-		}
-		// 若出现其他查询错误，打印错误信息并返回，终止密码更改流程
-		fmt.Println("查询用户失败:", err)
+	user, ok := lookupUser(st, username)
+	if !ok {
+		return
+	}
+	// 新密码需要满足密码策略
+	if errs := models.ValidatePassword(username, newPassword); errs != nil {
+		printValidationErrors(errs)
 		return
 	}
 
@@ -275,30 +309,172 @@ func changePassword(db *sql.DB, username, newPassword string) {
 		return
 	}
 
-	// 更新密码
-	// 执行 SQL 更新语句，将指定用户的密码哈希值更新为新生成的哈希值
-	_, err = db.Exec("UPDATE users SET password_hash = ? WHERE id = ?", hashedPassword, userID)
-	if err != nil {
+	// 更新密码，存储后端会同时吊销该用户的全部会话
+	if err := st.UpdatePassword(user.ID, string(hashedPassword)); err != nil {
 		// 若更新操作失败，打印错误信息并返回，终止密码更改流程
 		fmt.Println("更新密码失败:", err)
 		return
 	}
+	// 断开使用旧会话建立的 WebSocket 连接
+	models.DisconnectClients(user.ID, "", "密码已修改，请重新登录")
 
 	// 打印密码更新成功信息，包含用户名和用户 ID
-	fmt.Printf("用户 %s (ID: %d) 密码已更新\n", username, userID)
+	fmt.Printf("用户 %s (ID: %d) 密码已更新，已有登录会话全部失效\n", username, user.ID)
+}
+
+// exportUser 函数用于将指定用户的全部发射记录导出到文件，格式由文件扩展名决定。
+// 参数 st 是存储后端。
+// 参数 username 是要导出的用户的用户名。
+// 参数 path 是导出文件的路径，已存在的文件会被覆盖。
+func exportUser(st store.Store, username, path string) {
+	format, err := archive.FormatFromPath(path)
+	if err != nil {
+		fmt.Println("错误:", err)
+		return
+	}
+	user, ok := lookupUser(st, username)
+	if !ok {
+		return
+	}
+	events, err := st.ListLaunchEvents(user.ID)
+	if err != nil {
+		fmt.Println("查询发射记录失败:", err)
+		return
+	}
+
+	// 先写入临时文件再重命名，避免导出失败时留下不完整的文件
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		fmt.Println("创建文件失败:", err)
+		return
+	}
+	err = archive.NewExport(user, events, time.Now()).Write(file, format, "")
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		fmt.Println("导出失败:", err)
+		return
+	}
+
+	fmt.Printf("用户 %s 的 %d 条发射记录已导出到 %s\n", username, len(events), path)
+}
+
+// importUser 函数用于将 JSON 或 CSV 导出文件合并到指定用户的发射记录中，格式由文件扩展名决定。
+// 参数 st 是存储后端，config 包含应用的配置信息。
+// 参数 username 是导入目标用户的用户名。
+// 参数 path 是导入文件的路径。
+// 参数 args 是可选的合并方式（add、replace、max，默认 add）和 --dry-run 选项。
+func importUser(st store.Store, config *models.Config, username, path string, args []string) {
+	mode, dryRun := archive.ModeAdd, false
+	for _, arg := range args {
+		switch {
+		case arg == "--dry-run" || arg == "-n":
+			dryRun = true
+		case archive.ValidMode(arg):
+			mode = arg
+		default:
+			fmt.Println("错误: 无效的参数", arg, "（合并方式可选 add、replace、max）")
+			return
+		}
+	}
+	format, err := archive.FormatFromPath(path)
+	if err != nil {
+		fmt.Println("错误:", err)
+		return
+	}
+	user, ok := lookupUser(st, username)
+	if !ok {
+		return
+	}
+	loc := models.UserLocation(user.Timezone)
+
+	file, err := os.Open(path)
+	if err != nil {
+		fmt.Println("打开文件失败:", err)
+		return
+	}
+	imported, err := archive.Parse(file, format, loc)
+	file.Close()
+	if err != nil {
+		fmt.Println("错误:", err)
+		return
+	}
+	if errs := models.ValidateLaunchEvents(imported, time.Now(), config.MaxClockSkew()); errs != nil {
+		fmt.Println("错误:", errs)
+		return
+	}
+
+	var result archive.Result
+	if dryRun {
+		current, err := st.ListLaunchEvents(user.ID)
+		if err != nil {
+			fmt.Println("查询发射记录失败:", err)
+			return
+		}
+		_, _, result = archive.Merge(current, imported, mode, loc)
+		result.DryRun = true
+	} else {
+		before, after, err := st.MergeLaunchEvents(user.ID, func(current []models.LaunchEvent) ([]models.LaunchEvent, []string, error) {
+			add, remove, r := archive.Merge(current, imported, mode, loc)
+			result = r
+			return add, remove, nil
+		})
+		if err != nil {
+			fmt.Println("导入失败:", err)
+			return
+		}
+		pushLaunchData(user.ID, before, after)
+	}
+
+	// 打印每天的变化和汇总
+	if len(result.Days) > 0 {
+		fmt.Println("日期\t导入前\t导入后")
+		for _, day := range result.Days {
+			fmt.Printf("%s\t%d\t%d\n", day.Day, day.Before, day.After)
+		}
+	}
+	prefix := "已导入"
+	if dryRun {
+		prefix = "[dry-run] 将导入"
+	}
+	fmt.Printf("%s用户 %s 的数据 (%s): 文件中 %d 个事件，新增 %d 个，删除 %d 个，跳过 %d 个，总数 %d -> %d\n",
+		prefix, username, mode, result.Read, result.Added, result.Removed, result.Skipped, result.TotalBefore, result.TotalAfter)
+}
+
+// pushLaunchData 函数用于将控制台修改后的发射数据推送给用户的在线客户端，与同步接口的推送方式一致。
+// 参数 before 和 after 是修改前后的发射数据，修订号相同时说明数据没有变化，不会推送。
+func pushLaunchData(userID int, before, after models.LaunchData) {
+	if before.Revision == after.Revision {
+		return
+	}
+	models.Updates.Publish(userID, before, after, func(delta, snapshot models.Envelope) {
+		models.Hub.ForUser(userID, func(client *models.Client) {
+			env := snapshot
+			if client.Protocol == models.ProtocolV2 {
+				env = delta
+			}
+			// 队列已满时关闭连接，客户端重连后会补发错过的更新
+			if !client.Enqueue(env) {
+				client.Close()
+			}
+		})
+	})
 }
 
 // showOnlineUsers 函数用于显示当前在线用户及其对应的客户端数量。
-// 参数 clients 是指向在线客户端映射的指针，键为用户 ID，值为客户端实例切片。
-// 参数 lock 是读写锁，用于保证对在线客户端映射的并发安全访问。
-func showOnlineUsers(clients *map[int][]*models.Client, lock *sync.RWMutex) {
-	// 加读锁，防止在读取在线客户端信息时，其他协程对客户端映射进行写操作
-	lock.RLock()
-	// 函数返回时自动释放读锁，确保资源正确释放
-	defer lock.RUnlock()
-
-	// 检查在线客户端映射是否为空
-	if len(*clients) == 0 {
+// 参数 hub 是在线客户端注册表。
+func showOnlineUsers(hub *models.ClientHub) {
+	// 获取每个在线用户的客户端数量，注册表内部负责并发安全
+	counts := hub.Counts()
+
+	// 检查是否有在线用户
+	if len(counts) == 0 {
 		// 若为空，打印提示信息并返回
 		fmt.Println("当前没有在线用户")
 		return
@@ -309,43 +485,28 @@ func showOnlineUsers(clients *map[int][]*models.Client, lock *sync.RWMutex) {
 	// 打印表头，包含用户 ID 和客户端数量两列
 	fmt.Println("用户ID\t客户端数量")
 	// 遍历在线客户端映射
-	for userID, clientList := range *clients {
+	for userID, count := range counts {
 		// 打印每个用户的 ID 及其对应的客户端数量
-		fmt.Printf("%d\t%d\n", userID, len(clientList))
+		fmt.Printf("%d\t%d\n", userID, count)
 	}
 }
 
 // showUserClients 函数用于显示指定用户的在线客户端信息。
-// 参数 db 是数据库连接，用于查询用户信息。
+// 参数 st 是存储后端，用于查询用户信息。
 // 参数 username 是要查询的用户的用户名。
-// 参数 clients 是指向在线客户端映射的指针，键为用户 ID，值为客户端实例切片。
-// 参数 lock 是读写锁，用于保证对在线客户端映射的并发安全访问。
-func showUserClients(db *sql.DB, username string, clients *map[int][]*models.Client, lock *sync.RWMutex) {
+// 参数 hub 是在线客户端注册表。
+func showUserClients(st store.Store, username string, hub *models.ClientHub) {
 	// 获取用户ID
-	// 执行 SQL 查询，根据用户名从 users 表中获取对应的用户 ID
-	var userID int
-	err := db.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&userID)
-	if err != nil {
-		// 检查是否因为用户不存在而导致查询失败
-		if err == sql.ErrNoRows {
-			// 若用户不存在，打印错误信息并返回，终止查询流程
-			fmt.Println("错误: 用户不存在")
-			return
-		}
-		// 若出现其他查询错误，打印错误信息并返回，终止查询流程
-		fmt.Println("查询用户失败:", err)
+	user, ok := lookupUser(st, username)
+	if !ok {
 		return
 	}
+	userID := user.ID
 
-	// 加读锁，防止在读取在线客户端信息时，其他协程对客户端映射进行写操作
-	lock.RLock()
-	// 函数返回时自动释放读锁，确保资源正确释放
-	defer lock.RUnlock()
-
-	// 从在线客户端映射中获取指定用户 ID 对应的客户端列表
-	clientList, exists := (*clients)[userID]
+	// 从注册表中获取指定用户 ID 对应的客户端列表副本
+	clientList := hub.UserClients(userID)
 	// 检查该用户是否有在线客户端
-	if !exists || len(clientList) == 0 {
+	if len(clientList) == 0 {
 		// 若没有在线客户端，打印提示信息并返回
 		fmt.Printf("用户 %s 没有在线客户端\n", username)
 		return
@@ -365,4 +526,148 @@ func showUserClients(db *sql.DB, username string, clients *map[int][]*models.Cli
 			client.ConnectAt.Format("2006-01-02 15:04:05"),
 			duration)
 	}
-}
\ No newline at end of file
+}
+
+// notifyUser 函数用于向指定用户的在线客户端推送一条通知，只有支持消息信封协议的客户端会收到。
+// 参数 st 是存储后端。
+// 参数 username 是接收通知的用户名。
+// 参数 message 是通知内容。
+func notifyUser(st store.Store, username, message string) {
+	user, ok := lookupUser(st, username)
+	if !ok {
+		return
+	}
+	count := models.NotifyUser(user.ID, "info", message)
+	fmt.Printf("已向用户 %s 的 %d 个客户端推送通知\n", username, count)
+}
+
+// showLockouts 函数用于显示因连续登录失败而被临时锁定的账号及剩余锁定时间。
+// 参数 guard 是登录保护器。
+func showLockouts(guard *models.LoginGuard) {
+	lockouts := guard.Lockouts()
+	if len(lockouts) == 0 {
+		fmt.Println("当前没有被锁定的账号")
+		return
+	}
+	fmt.Println("用户名\t失败次数\t解锁时间\t剩余时间")
+	for _, l := range lockouts {
+		fmt.Printf("%s\t%d\t%s\t%s\n", l.Username, l.Failures,
+			l.LockedUntil.Format("2006-01-02 15:04:05"), time.Until(l.LockedUntil).Round(time.Second))
+	}
+}
+
+// unlockUser 函数用于解除账号锁定并清空其登录失败次数。
+// 参数 guard 是登录保护器。
+// 参数 username 是要解锁的用户名。
+func unlockUser(guard *models.LoginGuard, username string) {
+	if !guard.Unlock(username) {
+		fmt.Printf("用户 %s 没有登录失败记录\n", username)
+		return
+	}
+	fmt.Printf("用户 %s 已解锁\n", username)
+}
+
+// runMigrate 函数用于处理 migrate 子命令：查看迁移状态、升级或回滚数据库结构。
+// 参数 st 是存储后端。
+// 参数 args 是 migrate 之后的参数，第一个为子命令 status、up 或 down。
+func runMigrate(st store.Store, args []string) {
+	if len(args) == 0 {
+		fmt.Println("用法: migrate status | migrate up [版本号] | migrate down [步数]")
+		return
+	}
+
+	// 解析可选的数字参数，up 默认迁移到最新版本（0），down 默认回滚 1 步
+	n := 0
+	if args[0] == "down" {
+		n = 1
+	}
+	if len(args) > 1 {
+		v, err := strconv.Atoi(args[1])
+		if err != nil || v < 0 {
+			fmt.Println("参数必须是非负整数:", args[1])
+			return
+		}
+		n = v
+	}
+
+	switch args[0] {
+	case "status":
+		status, err := st.MigrationStatus()
+		if err != nil {
+			log.Println("查询迁移状态失败:", err)
+			return
+		}
+		if len(status) == 0 {
+			fmt.Println("当前存储后端不使用数据库迁移")
+			return
+		}
+		fmt.Println("数据库迁移状态:")
+		for _, m := range status {
+			if m.Applied {
+				fmt.Printf("  %3d  %-32s 已执行 (%s)\n", m.Version, m.Name, m.AppliedAt.Local().Format("2006-01-02 15:04:05"))
+			} else {
+				fmt.Printf("  %3d  %-32s 未执行\n", m.Version, m.Name)
+			}
+		}
+	case "up":
+		count, err := st.MigrateUp(n)
+		if err != nil {
+			log.Println("执行迁移失败:", err)
+		}
+		fmt.Printf("已执行 %d 个迁移\n", count)
+	case "down":
+		count, err := st.MigrateDown(n)
+		if err != nil {
+			log.Println("回滚迁移失败:", err)
+		}
+		fmt.Printf("已回滚 %d 个迁移\n", count)
+	default:
+		fmt.Println("用法: migrate status | migrate up [版本号] | migrate down [步数]")
+	}
+}
+
+// runKeys 函数用于查看、生成和轮换访问令牌的签名密钥。
+// 参数 ring 是密钥环，修改会立即生效并写回密钥环文件。
+// 参数 args 是 keys 之后的参数：list、generate [算法]、rotate [密钥ID|算法]。
+// 未指定算法时沿用当前密钥的算法。
+func runKeys(ring *tokens.Keyring, args []string) {
+	if len(args) == 0 {
+		fmt.Println("用法: keys list | keys generate [算法] | keys rotate [密钥ID|算法]")
+		return
+	}
+	arg := ""
+	if len(args) > 1 {
+		arg = args[1]
+	} else if current := ring.Current(); current != nil {
+		arg = current.Algorithm
+	}
+
+	switch args[0] {
+	case "list":
+		fmt.Println("密钥ID\t\t\t算法\t状态\t创建时间\t\t失效时间")
+		for _, k := range ring.Keys() {
+			expires := "-"
+			if !k.ExpiresAt.IsZero() {
+				expires = k.ExpiresAt.Local().Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%-16s\t%s\t%s\t%s\t%s\n", k.ID, k.Algorithm, k.Status,
+				k.CreatedAt.Local().Format("2006-01-02 15:04:05"), expires)
+		}
+	case "generate":
+		kid, err := ring.Generate(arg)
+		if err != nil {
+			fmt.Println("生成密钥失败:", err)
+			return
+		}
+		fmt.Printf("已生成待启用的密钥 %s，使用 keys rotate %s 启用\n", kid, kid)
+	case "rotate":
+		kid, err := ring.Rotate(arg)
+		if err != nil {
+			fmt.Println("轮换密钥失败:", err)
+			return
+		}
+		fmt.Printf("已启用密钥 %s，旧密钥签发的令牌在自然过期前仍然有效\n", kid)
+	default:
+		fmt.Println("用法: keys list | keys generate [算法] | keys rotate [密钥ID|算法]")
+	}
+}
@@ -2,320 +2,187 @@ package handlers
 
 import (
 	"backend/models"
-	"database/sql"
+	"backend/store"
+	"backend/tokens"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
-	"time"
-	"encoding/base64"
-	"crypto/sha256"
-	"strings"
-	"github.com/golang-jwt/jwt/v4"
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
 )
 
-// AuthHandler 统一处理用户的注册和登录请求。
-// 参数 db 是数据库连接，用于执行数据库操作。
-// 参数 config 包含应用的配置信息，如 JWT 密钥。
+// dummyPasswordHash 是用户不存在时用于比较的密码哈希，与真实哈希使用相同的计算成本，
+// 使不存在的用户名和密码错误的响应时间相同，无法通过耗时探测用户名是否存在。
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("launchcounter-dummy-password"), bcrypt.DefaultCost)
+
+// credentialsRequest 是注册和登录请求共用的请求体。
+type credentialsRequest struct {
+	Username string `json:"username" binding:"required"` // 用户名，必填字段
+	Password string `json:"password" binding:"required"` // 密码，必填字段
+}
+
+// RegisterHandler 处理用户注册请求，注册成功后直接登录并返回令牌。
+// 配置中 disable_registration 为 true 时拒绝注册，此时只能通过命令行 create 命令创建账号。
+// 参数 st 是存储后端，用于创建用户。
+// 参数 config 包含应用的配置信息，如令牌有效期和注册开关。
+// 参数 tm 是令牌管理器，用于签发访问令牌。
 // 返回一个 Gin 处理函数，用于处理 HTTP 请求。
-func AuthHandler(db *sql.DB, config *models.Config) gin.HandlerFunc {
+func RegisterHandler(st store.Store, config *models.Config, tm *tokens.Manager) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// 定义请求结构体，用于接收客户端发送的 JSON 数据
-		var req struct {
-			Username string `json:"username" binding:"required"` // 用户名，必填字段
-			Password string `json:"password" binding:"required"` // 密码，必填字段
+		// 管理员关闭了开放注册
+		if config.DisableRegistration {
+			c.JSON(http.StatusForbidden, gin.H{"error": "服务器已关闭注册，请联系管理员创建账号"})
+			return
 		}
 
 		// 尝试将请求体中的 JSON 数据绑定到 req 结构体
+		var req credentialsRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
 			// 若绑定失败，返回 400 状态码和错误信息
 			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据"})
 			return
 		}
 
-		// 尝试从数据库中获取现有用户信息
-		var user models.User
-		err := db.QueryRow("SELECT id, password_hash FROM users WHERE username = ?", req.Username).
-			Scan(&user.ID, &user.Password)
+		// 校验用户名和密码是否符合策略，返回每个字段的具体问题
+		if errs := models.ValidateCredentials(req.Username, req.Password); errs != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "用户名或密码不符合要求", "fields": errs})
+			return
+		}
 
-		if err == sql.ErrNoRows {
-			// 若查询结果为空，说明用户不存在，执行自动注册流程
-			// 对用户输入的密码进行哈希处理
-			hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
-			if err != nil {
-				// 若密码哈希失败，返回 500 状态码和错误信息
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "密码加密失败"})
-				return
-			}
+		// 对用户输入的密码进行哈希处理
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			// 若密码哈希失败，返回 500 状态码和错误信息
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "密码加密失败"})
+			return
+		}
 
-			// 将新用户信息插入到 users 表中
-			result, err := db.Exec("INSERT INTO users (username, password_hash) VALUES (?, ?)", 
-				req.Username, string(hashedPassword))
-			if err != nil {
-				// 若插入用户信息失败，返回 500 状态码和错误信息
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "创建用户失败"})
-				return
-			}
+		// 创建新用户，存储后端会同时为其初始化空的发射数据
+		user, err := st.CreateUser(req.Username, string(hashedPassword))
+		if err == store.ErrUserExists {
+			c.JSON(http.StatusConflict, gin.H{"error": "用户名已存在"})
+			return
+		}
+		if err != nil {
+			// 若创建用户失败，返回 500 状态码和错误信息
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "创建用户失败"})
+			return
+		}
 
-			// 获取新用户的 ID
-			userID, _ := result.LastInsertId()
-			// 为新用户在 launch_data 表中创建初始记录
-			db.Exec(`INSERT INTO launch_data (user_id, total, year_data, month_data, day_data, last_launch)
-				VALUES (?, 0, '{}', '{}', '{}', NULL)`, userID)
+		// 为新用户创建会话并签发令牌
+		resp, err := issueTokens(c, st, tm, user.ID, config)
+		if err != nil {
+			// 若生成令牌失败，返回 500 状态码和错误信息
+			log.Printf("创建会话失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "生成令牌失败"})
+			return
+		}
+		// 注册成功，返回 201 状态码和生成的令牌
+		c.JSON(http.StatusCreated, resp)
+	}
+}
+
+// LoginHandler 处理用户登录请求，用户名不存在时返回错误而不会自动注册。
+// 参数 st 是存储后端，用于查询用户。
+// 参数 config 包含应用的配置信息，如令牌有效期。
+// 参数 guard 按用户名限速，并在连续登录失败后临时锁定账号。
+// 参数 tm 是令牌管理器，用于签发访问令牌。
+// 返回一个 Gin 处理函数，用于处理 HTTP 请求。
+func LoginHandler(st store.Store, config *models.Config, guard *models.LoginGuard, tm *tokens.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// 尝试将请求体中的 JSON 数据绑定到 req 结构体
+		var req credentialsRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			// 若绑定失败，返回 400 状态码和错误信息
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据"})
+			return
+		}
 
-			// 为新用户生成 JWT 令牌
-			token, err := generateJWTToken(int(userID), config)
-			if err != nil {
-				// 若生成令牌失败，返回 500 状态码和错误信息
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "生成令牌失败"})
-				return
+		// 在比较密码之前检查账号是否被锁定或该用户名的失败尝试是否过于频繁，曾在该 IP 上成功登录的账号不受影响
+		if ok, wait, locked := guard.Check(req.Username, c.ClientIP()); !ok {
+			if locked {
+				tooManyRequests(c, wait, fmt.Sprintf("登录失败次数过多，账号已临时锁定，请 %d 分钟后再试", int(math.Ceil(wait.Minutes()))))
+			} else {
+				tooManyRequests(c, wait, "登录尝试过于频繁，请稍后再试")
 			}
-			// 注册成功，返回 200 状态码和生成的 JWT 令牌
-			c.JSON(http.StatusOK, gin.H{"token": token})
 			return
-		} else if err != nil {
+		}
+
+		// 尝试从存储后端获取现有用户信息
+		user, err := st.GetUserByUsername(req.Username)
+		if err != nil && err != store.ErrNotFound {
 			// 若查询数据库过程中出现其他错误，返回 500 状态码和错误信息
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
 			return
 		}
 
-		// 用户存在，执行登录验证流程
 		// 比较用户输入的密码和数据库中存储的密码哈希值
-		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
-			// 若密码不匹配，返回 401 状态码和错误信息
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "密码错误！如果此账号并非您注册，请您更换一个账号注册；如果此账号为您注册，请输入正确密码",
-			})
+		// 用户不存在时与占位哈希比较，响应内容和耗时都与密码错误相同，避免通过登录接口探测用户名是否存在
+		hash := []byte(user.Password)
+		if err == store.ErrNotFound {
+			hash = dummyPasswordHash
+		}
+		if bcrypt.CompareHashAndPassword(hash, []byte(req.Password)) != nil || err == store.ErrNotFound {
+			// 不存在的用户名同样计入失败次数，使锁定行为不会暴露用户名是否存在
+			if guard.RecordFailure(req.Username, c.ClientIP()) {
+				log.Printf("用户名 %s 连续登录失败次数过多，已临时锁定，来源 IP: %s", req.Username, c.ClientIP())
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "用户名或密码错误"})
 			return
 		}
+		guard.RecordSuccess(req.Username, c.ClientIP())
 
-		// 密码验证通过，为用户生成 JWT 令牌
-		token, err := generateJWTToken(user.ID, config)
+		// 密码验证通过，为用户创建会话并签发令牌
+		resp, err := issueTokens(c, st, tm, user.ID, config)
 		if err != nil {
 			// 若生成令牌失败，返回 500 状态码和错误信息
+			log.Printf("创建会话失败: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "生成令牌失败"})
 			return
 		}
-		// 登录成功，返回 200 状态码和生成的 JWT 令牌
-		c.JSON(http.StatusOK, gin.H{"token": token})
+		// 登录成功，返回 200 状态码和生成的令牌
+		c.JSON(http.StatusOK, resp)
 	}
 }
 
-
-
 // AuthMiddleware 是一个中间件生成函数，用于验证请求中的 JWT 令牌。
-// 参数 config 包含应用的配置信息，其中 JWTSecretKey 用于验证令牌，Env 用于控制日志输出。
+// 参数 st 是存储后端，用于检查令牌所属的会话是否已被吊销。
+// 参数 config 包含应用的配置信息，其中 Env 用于控制日志输出。
+// 参数 tm 是令牌管理器，与 WebSocket 连接使用同一套校验规则。
 // 返回一个 Gin 处理函数，该函数会在每个请求进入受保护路由时执行。
-func AuthMiddleware(config *models.Config) gin.HandlerFunc {
+func AuthMiddleware(st store.Store, config *models.Config, tm *tokens.Manager) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// 从请求头中获取 Authorization 字段的值，即 JWT 令牌
-		// 通常 JWT 令牌会以 "Bearer <token>" 的格式出现在 Authorization 头中
-		tokenString := c.GetHeader("Authorization")
-		// 检查令牌是否为空
-		if tokenString == "" {
-			// 若为空，记录日志，表明请求缺少必要的 Authorization 头
-			log.Println("请求缺少Authorization头")
-			// 返回 401 状态码和错误信息，提示客户端未提供认证令牌
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "未提供认证令牌"})
-			// 终止当前请求的后续处理，不再执行后续的中间件和路由处理函数
-			c.Abort()
+		// 从 Authorization 头中取出访问令牌，标准格式为 "Bearer <token>"，
+		// 旧版客户端直接发送令牌本身，同样可以识别
+		tokenString, err := bearerToken(c.GetHeader("Authorization"))
+		if err != nil {
+			// 若缺少令牌或格式错误，记录日志
+			log.Printf("请求的Authorization头无效: %v", err)
+			// 返回 401（格式错误时为 400）和 WWW-Authenticate 头，并终止后续处理
+			abortUnauthorized(c, err)
 			return
 		}
-		
-		// 使用统一的 JWT 解析函数解析并验证令牌
-		// 传入获取到的令牌字符串、配置中的 JWT 密钥以及配置信息
-		claims, err := ParseJWTToken(tokenString, config.JWTSecretKey, config)
-		// 检查解析过程中是否出错
+
+		// 解析令牌并检查其所属会话是否仍然有效
+		userID, sessionID, err := authenticateToken(st, tm, tokenString, config)
 		if err != nil {
-			// 若出错，记录日志，包含具体的错误信息
+			// 若验证失败，记录日志，包含具体的错误信息
 			log.Printf("JWT验证失败: %v", err)
-			// 返回 401 状态码和错误信息，提示客户端提供的认证令牌无效
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "无效的认证令牌"})
-			// 终止当前请求的后续处理，不再执行后续的中间件和路由处理函数
-			c.Abort()
-			return
-		}
-		
-		// 从解析后的声明中提取用户 ID，并尝试将其转换为 float64 类型
-		// 在 JWT 令牌的声明中，user_id 通常以 float64 类型存储
-		userID, ok := claims["user_id"].(float64)
-		// 检查类型转换是否成功
-		if !ok {
-			// 若失败，记录日志，包含实际获取到的 user_id 的类型
-			log.Printf("用户ID类型错误: %T", claims["user_id"])
-			// 返回 401 状态码和错误信息，提示客户端提供的用户 ID 无效
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "无效的用户ID"})
-			// 终止当前请求的后续处理，不再执行后续的中间件和路由处理函数
-			c.Abort()
+			// 返回 401 状态码、WWW-Authenticate 头和错误信息，并终止后续处理
+			abortUnauthorized(c, err)
 			return
 		}
-		
+
 		// 记录用户认证成功信息，仅在开发环境下输出日志
 		if config.Env == "dev" {
-			log.Printf("用户 %d 认证成功", int(userID))
+			log.Printf("用户 %d 认证成功", userID)
 		}
-		// 将用户 ID 存储到 Gin 上下文，供后续处理函数使用
+		// 将用户 ID 和会话 ID 存储到 Gin 上下文，供后续处理函数使用
 		// 后续的处理函数可以通过 c.Get("user_id") 来获取该用户 ID
-		c.Set("user_id", int(userID))
+		c.Set("user_id", userID)
+		c.Set("session_id", sessionID)
 		// 继续处理后续的中间件和路由处理函数
 		c.Next()
 	}
 }
-
-// generateJWTToken 用于为指定用户生成 JWT 令牌。
-// 参数 userID 是用户的唯一标识，用于在后续请求中识别用户身份。
-// 参数 config 包含应用的配置信息，其中 JWTSecretKey 用于对令牌进行签名。
-// 返回生成的 JWT 令牌字符串和可能出现的错误。若生成过程正常，错误为 nil。
-func generateJWTToken(userID int, config *models.Config) (string, error) {
-    // 创建一个新的 JWT 令牌实例，使用 HS256 签名方法，并设置令牌的声明信息
-    // HS256 是一种对称加密算法，使用相同的密钥进行签名和验证
-    token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-        "user_id": userID, // 用户的唯一标识，后续请求可通过该字段识别用户
-        "exp":     time.Now().Add(time.Hour * 24 * 7).Unix(), // 令牌的过期时间，设置为当前时间 7 天后，以 Unix 时间戳表示
-        "iat":     time.Now().Unix(), // 令牌的签发时间，记录令牌生成的时刻，以 Unix 时间戳表示
-    })
-
-    // 使用配置中的 JWT 密钥对令牌进行签名，生成最终的 JWT 令牌字符串
-    // SignedString 方法会将令牌的头部、声明和签名组合成标准的 JWT 格式字符串
-    return token.SignedString([]byte(config.JWTSecretKey))
-}
-// 用户表字段说明:
-	// id: 用户唯一标识
-	// username: 用户名，唯一
-	// password_hash: 用户密码的哈希值
-
-// 添加统一的 JWT 解析函数
-// ParseJWTToken 用于解析并验证 JWT 令牌。
-// 参数 tokenString 是待解析的 JWT 令牌字符串。
-// 参数 secretKey 是用于验证令牌签名的密钥。
-// 参数 config 包含应用的配置信息，用于控制开发环境的调试日志输出。
-// 返回解析后的 JWT 声明信息和可能出现的错误。若解析验证成功，错误为 nil。
-func ParseJWTToken(tokenString, secretKey string, config *models.Config) (jwt.MapClaims, error) {
-	// 开发环境输出调试信息
-	// 若当前环境为开发环境，记录原始令牌和密钥长度，方便调试
-	if config.Env == "dev" {
-		log.Printf("原始令牌: %s", tokenString)
-		log.Printf("密钥长度: %d", len(secretKey))
-	}
-	
-	// 开发环境记录密钥哈希
-	// 若当前环境为开发环境，计算并记录密钥的 SHA-256 哈希值
-	if config.Env == "dev" {
-		h := sha256.New()
-		h.Write([]byte(secretKey))
-		log.Printf("密钥哈希: %x", h.Sum(nil))
-	}
-	
-	// 打印令牌的头部和声明部分
-	// 将 JWT 令牌按 '.' 分割成三部分，标准的 JWT 由头部、声明和签名三部分组成，以 '.' 分隔
-	parts := strings.Split(tokenString, ".")
-	// 检查分割后的部分数量是否为 3，若不是则令牌格式无效
-	if len(parts) != 3 {
-		return nil, fmt.Errorf("令牌格式无效")
-	}
-	
-	// 解码头部
-	// 使用 Base64 原始 URL 编码对令牌头部进行解码，JWT 的头部和声明部分通常使用 Base64 编码
-	headerBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
-	if err != nil {
-		// 若解码失败，返回错误信息
-		return nil, fmt.Errorf("解码头部失败: %v", err)
-	}
-	// 开发环境输出声明详情
-	// 若当前环境为开发环境，记录解码后的令牌头部信息
-	if config.Env == "dev" {
-		log.Printf("令牌头部: %s", string(headerBytes))
-	}
-	
-	// 解码声明部分
-	claimsBytes, err := base64.RawURLEncoding.DecodeString(parts[1])
-	if err != nil {
-		// 若解码失败，返回错误信息
-		return nil, fmt.Errorf("解码声明失败: %v", err)
-	}
-	// 开发环境输出声明详情
-	// 若当前环境为开发环境，记录解码后的令牌声明信息
-	if config.Env == "dev" {
-		log.Printf("令牌声明: %s", string(claimsBytes))
-	}
-	
-	// 解析并验证令牌
-	// 使用 jwt.Parse 函数解析 JWT 令牌，并传入密钥验证函数
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		// 检查令牌的签名方法是否为 HMAC
-		// JWT 支持多种签名方法，这里只接受 HMAC 方法
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			// 若不是 HMAC 方法，返回错误信息
-			return nil, fmt.Errorf("非预期的签名方法: %v", token.Header["alg"])
-		}
-		// 返回用于验证签名的密钥
-		return []byte(secretKey), nil
-	})
-	
-	if err != nil {
-		// 若解析过程中出现错误，返回错误信息
-		return nil, fmt.Errorf("令牌解析失败: %v", err)
-	}
-	
-	// 检查令牌的声明是否为 jwt.MapClaims 类型，并且令牌是否有效
-	// jwt.MapClaims 是一个 map[string]interface{} 类型，用于存储令牌的声明信息
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		// 若验证通过，返回解析后的声明信息
-		return claims, nil
-	}
-	
-	// 若令牌无效，返回错误信息
-	return nil, fmt.Errorf("令牌无效")
-}
-
-// CreateTables 函数用于在数据库中创建必要的表。
-// 若表已存在，则不会重复创建；若创建过程中出现错误，会打印错误信息并终止程序。
-// 参数 db 是数据库连接，用于执行 SQL 语句。
-func CreateTables(db *sql.DB) {
-	// 创建用户表
-	// 使用 db.Exec 方法执行 SQL 语句，若表不存在则创建 users 表
-	_, err := db.Exec(`
-		CREATE TABLE IF NOT EXISTS users (
-			-- 用户唯一标识，自增整数类型，作为主键，用于唯一标识每个用户
-			id INT AUTO_INCREMENT PRIMARY KEY,
-			-- 用户名，最大长度 50 个字符，唯一且不能为空，用于用户登录和识别
-			username VARCHAR(50) UNIQUE NOT NULL,
-			-- 用户密码的哈希值，最大长度 255 个字符，不能为空，用于安全存储用户密码
-			password_hash VARCHAR(255) NOT NULL
-		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
-		-- 使用 InnoDB 存储引擎，支持事务和外键约束
-		-- 默认字符集为 utf8mb4，支持存储多语言字符
-	`)
-	if err != nil {
-		// 若创建用户表失败，打印错误信息并终止程序
-		log.Fatalf("创建用户表失败: %v", err)
-	}
-
-	// 创建发射数据表
-	// 使用 db.Exec 方法执行 SQL 语句，若表不存在则创建 launch_data 表
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS launch_data (
-			-- 用户 ID，作为主键，关联 users 表中的用户
-			user_id INT PRIMARY KEY,
-			-- 总发射次数，整数类型，不能为空，默认值为 0，记录用户的总发射次数
-			total INT NOT NULL DEFAULT 0,
-			-- 年度发射数据，JSON 类型，存储用户每年的发射次数信息
-			year_data JSON,
-			-- 月度发射数据，JSON 类型，存储用户每月的发射次数信息
-			month_data JSON,
-			-- 每日发射数据，JSON 类型，存储用户每天的发射次数信息
-			day_data JSON,
-			-- 最后一次发射时间，时间戳类型，可为空，记录用户最后一次发射的时间
-			last_launch TIMESTAMP NULL,
-			-- 外键约束，关联 users 表的 id 字段，当用户记录删除时，级联删除此表中的相关记录
-			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
-		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
-		-- 使用 InnoDB 存储引擎，支持事务和外键约束
-		-- 默认字符集为 utf8mb4，支持存储多语言字符
-	`)
-	if err != nil {
-		// 若创建发射数据表失败，打印错误信息并终止程序
-		log.Fatalf("创建发射数据表失败: %v", err)
-	}
-}
\ No newline at end of file
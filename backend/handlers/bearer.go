@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"backend/tokens"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// authRealm 是 WWW-Authenticate 响应头中的保护域名称。
+const authRealm = "launchcounter"
+
+// RFC 6750 第 3.1 节定义的错误码。
+const (
+	bearerInvalidRequest = "invalid_request"
+	bearerInvalidToken   = "invalid_token"
+)
+
+var (
+	// errNoCredentials 表示请求没有携带 Bearer 凭据，包括使用了其他认证方案的情况。
+	errNoCredentials = errors.New("未提供认证令牌")
+	// errMalformedAuthorization 表示 Authorization 头声明了 Bearer 方案但格式不正确。
+	errMalformedAuthorization = errors.New("Authorization 头格式错误")
+)
+
+// bearerToken 按 RFC 6750 第 2.1 节从 Authorization 头中取出访问令牌。
+// 方案名不区分大小写，与令牌之间允许有多个空格。
+// 为兼容旧版客户端，不带方案名、直接放置令牌的头也会被接受。
+func bearerToken(header string) (string, error) {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return "", errNoCredentials
+	}
+
+	scheme, rest, found := strings.Cut(header, " ")
+	if !found {
+		// 旧版客户端直接发送令牌本身；JWT 由 "." 连接的三段组成，不会与方案名混淆
+		if strings.Count(header, ".") == 2 && isToken68(header) {
+			return header, nil
+		}
+		if strings.EqualFold(header, "Bearer") {
+			return "", errMalformedAuthorization
+		}
+		return "", errNoCredentials
+	}
+	if !strings.EqualFold(scheme, "Bearer") {
+		// 其他认证方案（如 Basic）视为没有提供 Bearer 凭据
+		return "", errNoCredentials
+	}
+
+	token := strings.TrimLeft(rest, " ")
+	if token == "" || !isToken68(token) {
+		return "", errMalformedAuthorization
+	}
+	return token, nil
+}
+
+// isToken68 判断字符串是否符合 RFC 6750 的 b64token 语法：
+// 字母、数字和 "-._~+/"，末尾可以有若干 "="。
+func isToken68(s string) bool {
+	body := strings.TrimRight(s, "=")
+	if body == "" {
+		return false
+	}
+	for _, r := range body {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case strings.ContainsRune("-._~+/", r):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// abortUnauthorized 以 401（格式错误时为 400）中止请求，并按 RFC 6750 设置 WWW-Authenticate 响应头。
+// 参数 err 是认证失败的原因，决定响应头中的错误码和响应体中的错误信息。
+func abortUnauthorized(c *gin.Context, err error) {
+	status := http.StatusUnauthorized
+	challenge := fmt.Sprintf("Bearer realm=%q", authRealm)
+	message := authErrorMessage(err)
+
+	switch {
+	case errors.Is(err, errNoCredentials):
+		// 没有提供凭据时不携带错误码，只提示客户端应使用的认证方案
+		message = errNoCredentials.Error()
+	case errors.Is(err, errMalformedAuthorization):
+		status = http.StatusBadRequest
+		message = errMalformedAuthorization.Error()
+		challenge += fmt.Sprintf(", error=%q, error_description=%q", bearerInvalidRequest, "malformed Authorization header")
+	default:
+		// error_description 只允许 ASCII 字符，中文说明放在响应体中
+		challenge += fmt.Sprintf(", error=%q, error_description=%q", bearerInvalidToken, bearerErrorDescription(err))
+	}
+
+	c.Header("WWW-Authenticate", challenge)
+	c.AbortWithStatusJSON(status, gin.H{"error": message})
+}
+
+// bearerErrorDescription 返回 WWW-Authenticate 响应头中 error_description 的取值。
+func bearerErrorDescription(err error) string {
+	switch {
+	case errors.Is(err, tokens.ErrExpired):
+		return "the access token expired"
+	case errors.Is(err, errSessionRevoked):
+		return "the session has been revoked"
+	default:
+		return "the access token is invalid"
+	}
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"backend/models"
+	"backend/store"
+	"backend/tokens"
+	"crypto/subtle"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterDebugRoutes 注册 /debug 下的诊断接口，仅在配置 debug_enabled 为 true 时调用。
+// 诊断接口只接受来自本机的直连请求，或携带正确 X-Admin-Token 头的请求，
+// 且任何响应都不包含密钥或完整令牌。
+// 参数 router 是要注册路由的 Gin 引擎。
+// 参数 st 是存储后端，用于查询令牌所属会话的状态。
+// 参数 config 包含应用的配置信息，如管理员令牌。
+// 参数 tm 是令牌管理器，诊断接口与正式接口使用同一套校验规则。
+func RegisterDebugRoutes(router *gin.Engine, st store.Store, config *models.Config, tm *tokens.Manager) {
+	debug := router.Group("/debug")
+	debug.Use(debugGuard(config))
+
+	// 校验令牌并返回其声明和会话状态
+	debug.POST("/validate-token", func(c *gin.Context) {
+		var req struct {
+			Token string `json:"token" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效请求"})
+			return
+		}
+
+		resp := gin.H{"token": models.RedactToken(req.Token)}
+		claims, err := tm.Verify(req.Token)
+		if err != nil {
+			resp["valid"] = false
+			resp["error"] = err.Error()
+			c.JSON(http.StatusOK, resp)
+			return
+		}
+		resp["claims"] = claims
+
+		// 签名有效的令牌还需要检查会话是否仍然有效
+		err = checkSession(st, claims.UserID, claims.SessionID)
+		resp["valid"] = err == nil
+		if err != nil {
+			resp["error"] = err.Error()
+		}
+		if session, err := st.GetSession(claims.SessionID); err == nil {
+			resp["session"] = gin.H{
+				"id":         session.ID,
+				"user_id":    session.UserID,
+				"active":     session.Active(time.Now()),
+				"created_at": session.CreatedAt,
+				"expires_at": session.ExpiresAt,
+				"revoked":    !session.RevokedAt.IsZero(),
+			}
+		}
+		c.JSON(http.StatusOK, resp)
+	})
+
+	// 查看当前在线的 WebSocket 客户端
+	debug.GET("/clients", func(c *gin.Context) {
+		c.JSON(http.StatusOK, models.GetOnlineClients())
+	})
+}
+
+// debugGuard 返回保护诊断接口的中间件。
+// 本机直连（来源为回环地址且没有经过反向代理）的请求直接放行；
+// 其他请求必须在 X-Admin-Token 头中携带配置的 debug_admin_token，未配置时一律拒绝。
+func debugGuard(config *models.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if isDirectLoopback(c.Request) {
+			c.Next()
+			return
+		}
+		token := c.GetHeader("X-Admin-Token")
+		// 使用常量时间比较，避免通过响应时间逐字节猜测管理员令牌
+		if config.DebugAdminToken != "" && token != "" &&
+			subtle.ConstantTimeCompare([]byte(token), []byte(config.DebugAdminToken)) == 1 {
+			c.Next()
+			return
+		}
+		log.Printf("拒绝来自 %s 的诊断接口访问: %s", c.ClientIP(), c.Request.URL.Path)
+		// 返回 404 而不是 403，不向外部暴露诊断接口的存在
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "未找到"})
+	}
+}
+
+// isDirectLoopback 判断请求是否由本机直接发出。
+// 经本机反向代理转发的请求同样来自回环地址，因此带有转发头的请求不视为本机请求。
+func isDirectLoopback(r *http.Request) bool {
+	if r.Header.Get("X-Forwarded-For") != "" || r.Header.Get("X-Real-IP") != "" || r.Header.Get("Forwarded") != "" {
+		return false
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
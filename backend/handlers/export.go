@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"backend/archive"
+	"backend/models"
+	"backend/store"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ExportHandler 返回一个 Gin 处理函数，用于以附件形式导出当前用户的全部发射记录。
+// 查询参数 format 可选 json（默认，可用于备份和导入）、csv 和 ics；
+// rows 可选 event 或 day，决定 CSV 和 iCalendar 是每个事件一行还是每天一行，默认 CSV 按事件、iCalendar 按天。
+// 参数 st 是存储后端，config 包含应用的配置信息。
+func ExportHandler(st store.Store, config *models.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("user_id")
+
+		format := c.DefaultQuery("format", archive.FormatJSON)
+		if !archive.ValidFormat(format) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的导出格式，可选 csv、json、ics"})
+			return
+		}
+		rows := c.Query("rows")
+		if rows != "" && rows != archive.RowsEvent && rows != archive.RowsDay {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的行粒度，可选 event、day"})
+			return
+		}
+
+		user, err := st.GetUserByID(userID)
+		if err != nil {
+			log.Printf("数据库查询失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+			return
+		}
+		events, err := st.ListLaunchEvents(userID)
+		if err != nil {
+			log.Printf("数据库查询失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+			return
+		}
+
+		now := time.Now()
+		export := archive.NewExport(user, events, now)
+		filename := fmt.Sprintf("launchcounter-%s-%s.%s", user.Username, now.In(models.UserLocation(user.Timezone)).Format("20060102"), format)
+		c.Header("Content-Type", archive.ContentType(format))
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		c.Status(http.StatusOK)
+		// 响应头已经发出，写入失败通常是客户端断开，只记录日志
+		if err := export.Write(c.Writer, format, rows); err != nil {
+			log.Printf("导出用户 %d 的数据失败: %v", userID, err)
+			return
+		}
+		if config.Env == "dev" {
+			log.Printf("用户 %d 导出了 %d 个事件 (format=%s)", userID, len(events), format)
+		}
+	}
+}
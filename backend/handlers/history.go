@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"backend/models"
+	"backend/store"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// historyPadding 是按时间范围读取事件时在两端额外读取的时长。
+// 事件按各自时区的日期归入时间段，与用户时区最多相差一天多，两端各多读两天即可覆盖。
+const historyPadding = 48 * time.Hour
+
+// HistoryHandler 返回一个 Gin 处理函数，用于按时间范围和粒度查询当前用户的发射历史。
+// 查询参数：
+//   - granularity: hour、day（默认）、week、month 或 year；
+//   - from、to: RFC3339 时间或 "2024-03-05" 形式的日期，按用户的时区解析。
+//     日期形式的 to 表示当天结束；to 默认为当前时间，from 默认按粒度回溯（见 models.DefaultHistoryFrom）。
+//
+// 返回的时间序列覆盖 from 和 to 所在的时间段及其间的全部时间段，没有发射的时间段计为 0。
+// 参数 st 是存储后端，config 包含应用的配置信息。
+func HistoryHandler(st store.Store, config *models.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("user_id")
+
+		granularity := c.DefaultQuery("granularity", models.GranularityDay)
+		if !models.ValidGranularity(granularity) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的时间粒度，可选 hour、day、week、month、year"})
+			return
+		}
+
+		user, err := st.GetUserByID(userID)
+		if err != nil {
+			log.Printf("数据库查询失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+			return
+		}
+		loc := models.UserLocation(user.Timezone)
+
+		to := time.Now().In(loc)
+		if raw := c.Query("to"); raw != "" {
+			if to, err = parseHistoryTime(raw, loc, true); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "无效的结束时间"})
+				return
+			}
+		}
+		from := models.DefaultHistoryFrom(to, granularity)
+		if raw := c.Query("from"); raw != "" {
+			if from, err = parseHistoryTime(raw, loc, false); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "无效的开始时间"})
+				return
+			}
+		}
+
+		history, err := models.NewHistory(from, to, granularity, loc)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		// 只读取时间序列范围内的事件，按事件时区归入时间段的误差由两端的余量覆盖
+		events, err := st.ListLaunchEventsBetween(userID, history.From.Add(-historyPadding), history.To.Add(historyPadding))
+		if err != nil {
+			log.Printf("数据库查询失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+			return
+		}
+		history.Add(events, loc)
+
+		if config.Env == "dev" {
+			log.Printf("用户 %d 查询历史: %s 粒度 %d 个时间点", userID, granularity, len(history.Points))
+		}
+		c.JSON(http.StatusOK, history)
+	}
+}
+
+// parseHistoryTime 解析 RFC3339 时间或按 loc 解析 "2024-03-05" 形式的日期。
+// 参数 endOfDay 为 true 时日期表示当天的最后一刻，否则表示当天零点。
+func parseHistoryTime(raw string, loc *time.Location, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t.In(loc), nil
+	}
+	day, err := models.ParseDayKey(raw, loc)
+	if err != nil {
+		return time.Time{}, errors.New("无效的时间")
+	}
+	if endOfDay {
+		return day.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+	}
+	return day, nil
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseHistoryTime(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("加载时区失败: %v", err)
+	}
+
+	tests := []struct {
+		raw      string
+		endOfDay bool
+		want     time.Time
+	}{
+		{"2024-03-10T12:00:00Z", false, time.Date(2024, 3, 10, 8, 0, 0, 0, ny)},
+		{"2024-03-10T12:00:00Z", true, time.Date(2024, 3, 10, 8, 0, 0, 0, ny)},
+		{"2024-03-10", false, time.Date(2024, 3, 10, 0, 0, 0, 0, ny)},
+		{"2024-3-10", false, time.Date(2024, 3, 10, 0, 0, 0, 0, ny)},
+		// 夏令时开始的这一天只有 23 个小时，最后一刻仍然是当天的 23:59:59.999999999
+		{"2024-03-10", true, time.Date(2024, 3, 10, 23, 59, 59, 999999999, ny)},
+		{"2024-11-03", true, time.Date(2024, 11, 3, 23, 59, 59, 999999999, ny)},
+	}
+	for _, tt := range tests {
+		got, err := parseHistoryTime(tt.raw, ny, tt.endOfDay)
+		if err != nil {
+			t.Errorf("解析 %q 失败: %v", tt.raw, err)
+			continue
+		}
+		if !got.Equal(tt.want) || got.Location() != ny {
+			t.Errorf("解析 %q (endOfDay=%v) 得到 %v，期望 %v", tt.raw, tt.endOfDay, got, tt.want)
+		}
+	}
+
+	for _, raw := range []string{"", "2024-02-30", "2024-03", "yesterday"} {
+		if _, err := parseHistoryTime(raw, ny, false); err == nil {
+			t.Errorf("解析 %q 应当失败", raw)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"backend/archive"
+	"backend/models"
+	"backend/store"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxImportBytes 是导入请求体的大小上限。
+const maxImportBytes = 32 << 20
+
+// maxImportEvents 是单次 POST /import 最多包含的事件数量。
+// 导入在持有用户写锁的事务中逐条写入，数量过多会长时间阻塞该用户的同步；更大的文件可以拆分后导入，或由管理员在控制台导入。
+const maxImportEvents = 10000
+
+// ImportHandler 返回一个 Gin 处理函数，用于将 JSON 或 CSV 导出文件合并到当前用户的发射记录中。
+// 请求体是文件内容本身；查询参数 format 指定格式（json 或 csv），省略时按 Content-Type 判断，默认为 json。
+// 查询参数 mode 指定合并方式：add（默认）追加文件中的事件，replace 以文件为准替换其中出现的日期，max 每天取较大的次数。
+// 查询参数 dry_run 为 true 时只返回将会产生的改动，不修改数据。
+// 参数 st 是存储后端，config 包含应用的配置信息。
+func ImportHandler(st store.Store, config *models.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("user_id")
+
+		mode := c.DefaultQuery("mode", archive.ModeAdd)
+		if !archive.ValidMode(mode) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的合并方式，可选 add、replace、max"})
+			return
+		}
+		dryRun := false
+		if v := c.Query("dry_run"); v != "" {
+			var err error
+			if dryRun, err = strconv.ParseBool(v); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "dry_run 必须是 true 或 false"})
+				return
+			}
+		}
+		format := c.Query("format")
+		if format == "" {
+			format = archive.FormatJSON
+			if strings.HasPrefix(c.ContentType(), "text/csv") {
+				format = archive.FormatCSV
+			}
+		}
+
+		user, err := st.GetUserByID(userID)
+		if err != nil {
+			log.Printf("数据库查询失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+			return
+		}
+		loc := models.UserLocation(user.Timezone)
+
+		body := http.MaxBytesReader(c.Writer, c.Request.Body, maxImportBytes)
+		imported, err := archive.Parse(body, format, loc)
+		if err != nil {
+			log.Printf("用户 %d 的导入文件解析失败: %v", userID, err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if len(imported) > maxImportEvents {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("单次最多导入 %d 个事件，请拆分文件后分批导入", maxImportEvents)})
+			return
+		}
+		if errs := models.ValidateLaunchEvents(imported, time.Now(), config.MaxClockSkew()); errs != nil {
+			log.Printf("用户 %d 导入的事件校验失败: %v", userID, errs)
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "数据校验失败", "fields": errs})
+			return
+		}
+
+		// dry-run 不需要加锁，按当前数据计算即可
+		if dryRun {
+			current, err := st.ListLaunchEvents(userID)
+			if err != nil {
+				log.Printf("数据库查询失败: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+				return
+			}
+			_, _, result := archive.Merge(current, imported, mode, loc)
+			result.DryRun = true
+			c.JSON(http.StatusOK, gin.H{"result": result})
+			return
+		}
+
+		var result archive.Result
+		before, after, err := st.MergeLaunchEvents(userID, func(current []models.LaunchEvent) ([]models.LaunchEvent, []string, error) {
+			add, remove, r := archive.Merge(current, imported, mode, loc)
+			result = r
+			return add, remove, nil
+		})
+		if err != nil {
+			log.Printf("导入发射事件失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "导入数据失败"})
+			return
+		}
+
+		if config.Env == "dev" {
+			log.Printf("用户 %d 导入数据 (mode=%s): 新增 %d 个事件, 删除 %d 个, 跳过 %d 个", userID, mode, result.Added, result.Removed, result.Skipped)
+		}
+		broadcastToUser(userID, before, after, config)
+		c.JSON(http.StatusOK, gin.H{
+			"result": result,
+			"data":   after,
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"backend/models"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LogFormatter 是 Gin 访问日志的格式化函数，格式与 Gin 默认日志一致，
+// 但会隐藏路径中 token 等敏感查询参数的取值，避免 WebSocket 令牌写入访问日志。
+func LogFormatter(param gin.LogFormatterParams) string {
+	if param.Latency > time.Minute {
+		param.Latency = param.Latency.Truncate(time.Second)
+	}
+	return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		param.StatusCode,
+		param.Latency,
+		param.ClientIP,
+		param.Method,
+		models.RedactQuery(param.Path),
+		param.ErrorMessage,
+	)
+}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"backend/models"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimitMiddleware 返回按客户端 IP 限速的中间件，超出限速时返回 429 和 Retry-After 头。
+// 参数 limiter 为 nil 时不做任何限制。
+func RateLimitMiddleware(limiter *models.RateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if ok, wait := limiter.Allow(c.ClientIP()); !ok {
+			log.Printf("IP %s 请求过于频繁: %s %s", c.ClientIP(), c.Request.Method, c.Request.URL.Path)
+			tooManyRequests(c, wait, "请求过于频繁，请稍后再试")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// tooManyRequests 返回 429 响应，Retry-After 以秒为单位向上取整。
+func tooManyRequests(c *gin.Context, wait time.Duration, message string) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": message, "retry_after": seconds})
+}
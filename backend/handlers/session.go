@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"backend/models"
+	"backend/store"
+	"backend/tokens"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errSessionRevoked 表示令牌本身有效，但其所属会话已被吊销、已过期或用户已被删除。
+var errSessionRevoked = errors.New("会话已失效")
+
+// issueTokens 为用户创建新的登录会话，并签发访问令牌和刷新令牌。
+// 参数 c 是当前请求的上下文，用于记录会话的客户端信息。
+// 返回可直接作为响应体的令牌信息，其中 token 字段与旧版客户端保持兼容。
+// 参数 tm 是令牌管理器，用于签发访问令牌。
+func issueTokens(c *gin.Context, st store.Store, tm *tokens.Manager, userID int, config *models.Config) (gin.H, error) {
+	now := time.Now()
+	sessionID := models.NewSessionID()
+	refreshToken, refreshHash := models.NewRefreshToken(sessionID)
+
+	err := st.CreateSession(models.Session{
+		ID:          sessionID,
+		UserID:      userID,
+		RefreshHash: refreshHash,
+		UserAgent:   c.Request.UserAgent(),
+		IP:          c.ClientIP(),
+		CreatedAt:   now,
+		LastUsedAt:  now,
+		ExpiresAt:   now.Add(config.RefreshTokenTTL()),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tokenResponse(tm, userID, sessionID, refreshToken)
+}
+
+// tokenResponse 为指定会话签发访问令牌并组装响应体。
+func tokenResponse(tm *tokens.Manager, userID int, sessionID, refreshToken string) (gin.H, error) {
+	accessToken, err := tm.Issue(userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return gin.H{
+		"token":         accessToken, // 旧版客户端只读取该字段
+		"access_token":  accessToken,
+		"token_type":    "Bearer",
+		"expires_in":    int(tm.TTL().Seconds()),
+		"refresh_token": refreshToken,
+	}, nil
+}
+
+// authenticateToken 解析访问令牌，并确认其所属会话仍然有效。
+// HTTP 中间件和 WebSocket 连接共用该函数，保证两者的校验规则一致。
+// 令牌本身的签名、签发者、受众和有效期由 tm 校验，这里只补充会话状态的检查。
+// 返回令牌中的用户 ID 和会话 ID。
+func authenticateToken(st store.Store, tm *tokens.Manager, tokenString string, config *models.Config) (int, string, error) {
+	// 令牌属于凭据，日志中只记录令牌的长度和指纹
+	if config.Env == "dev" {
+		log.Printf("解析令牌: %s", models.RedactToken(tokenString))
+	}
+	claims, err := tm.Verify(tokenString)
+	if err != nil {
+		return 0, "", err
+	}
+	if err := checkSession(st, claims.UserID, claims.SessionID); err != nil {
+		return 0, "", err
+	}
+	return claims.UserID, claims.SessionID, nil
+}
+
+// checkSession 确认会话属于指定用户且仍然有效。
+func checkSession(st store.Store, userID int, sessionID string) error {
+	session, err := st.GetSession(sessionID)
+	if err == store.ErrNotFound {
+		return fmt.Errorf("%w: 会话 %s 不存在", errSessionRevoked, sessionID)
+	}
+	if err != nil {
+		return fmt.Errorf("查询会话失败: %v", err)
+	}
+	if session.UserID != userID || !session.Active(time.Now()) {
+		return fmt.Errorf("%w: 会话 %s 已吊销或已过期", errSessionRevoked, sessionID)
+	}
+	return nil
+}
+
+// authErrorMessage 返回认证失败时展示给客户端的错误信息。
+func authErrorMessage(err error) string {
+	if errors.Is(err, errSessionRevoked) {
+		return "会话已失效，请重新登录"
+	}
+	if errors.Is(err, tokens.ErrExpired) {
+		return "认证令牌已过期"
+	}
+	return "无效的认证令牌"
+}
+
+// RefreshHandler 使用刷新令牌换取新的访问令牌。
+// 每次刷新都会轮换刷新令牌，旧令牌随即失效；已被使用过的刷新令牌再次出现时，
+// 说明令牌可能已泄露，此时吊销整个会话。
+// 参数 st 是存储后端，用于查询和更新会话。
+// 参数 config 包含应用的配置信息，如令牌有效期。
+// 参数 tm 是令牌管理器，用于签发新的访问令牌。
+func RefreshHandler(st store.Store, config *models.Config, tm *tokens.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			RefreshToken string `json:"refresh_token" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据"})
+			return
+		}
+
+		sessionID, oldHash, err := models.ParseRefreshToken(req.RefreshToken)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "无效的刷新令牌"})
+			return
+		}
+		session, err := st.GetSession(sessionID)
+		if err == store.ErrNotFound {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "无效的刷新令牌"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询会话失败"})
+			return
+		}
+		if !session.Active(time.Now()) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "会话已失效，请重新登录"})
+			return
+		}
+
+		newToken, newHash := models.NewRefreshToken(sessionID)
+		err = st.RotateSession(sessionID, oldHash, newHash, time.Now().Add(config.RefreshTokenTTL()))
+		if err == store.ErrNotFound {
+			// 会话有效但哈希不匹配，说明提交的是已经轮换掉的旧令牌
+			log.Printf("会话 %s 的刷新令牌被重复使用，已吊销该会话", sessionID)
+			st.RevokeSession(sessionID)
+			models.DisconnectClients(session.UserID, sessionID, "刷新令牌被重复使用，会话已吊销")
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "会话已失效，请重新登录"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "更新会话失败"})
+			return
+		}
+
+		resp, err := tokenResponse(tm, session.UserID, sessionID, newToken)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "生成令牌失败"})
+			return
+		}
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+// LogoutHandler 吊销当前会话，需要在 AuthMiddleware 之后使用。
+// 请求体中 all 为 true 时吊销该用户的全部会话，用于在所有设备上退出登录。
+// 被吊销会话的 WebSocket 连接会被立即断开。
+func LogoutHandler(st store.Store, config *models.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("user_id")
+		sessionID := c.GetString("session_id")
+
+		var req struct {
+			All bool `json:"all"`
+		}
+		// 请求体是可选的，没有请求体时只退出当前会话
+		if c.Request.ContentLength > 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据"})
+				return
+			}
+		}
+
+		if req.All {
+			count, err := st.RevokeUserSessions(userID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "退出登录失败"})
+				return
+			}
+			models.DisconnectClients(userID, "", "已退出全部会话")
+			c.JSON(http.StatusOK, gin.H{"message": "已退出全部会话", "revoked": count})
+			return
+		}
+
+		if err := st.RevokeSession(sessionID); err != nil && err != store.ErrNotFound {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "退出登录失败"})
+			return
+		}
+		models.DisconnectClients(userID, sessionID, "已退出登录")
+		if config.Env == "dev" {
+			log.Printf("用户 %d 已退出会话 %s", userID, sessionID)
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "已退出登录", "revoked": 1})
+	}
+}
+
+// JWKSHandler 以 JWK Set 格式返回用于校验访问令牌的公钥。
+// 只包含 EdDSA 和 RS256 密钥，HS256 共享密钥不会公开；已退役但未过期的密钥也会列出。
+// 参数 tm 是令牌管理器，公钥取自其密钥环。
+func JWKSHandler(tm *tokens.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, tm.Keyring().JWKS())
+	}
+}
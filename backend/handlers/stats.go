@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"backend/models"
+	"backend/store"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StatsHandler 返回一个 Gin 处理函数，用于返回当前用户由服务端计算的统计数据，
+// 包括连续发射天数、平均值、星期和小时分布、最长间隔以及与上一周期相比的趋势。
+// 查询参数 period 指定趋势周期，可选 week、month（默认）和 year。
+// 参数 st 是存储后端，config 包含应用的配置信息。
+func StatsHandler(st store.Store, config *models.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("user_id")
+
+		period := c.DefaultQuery("period", models.DefaultStatsPeriod)
+		if _, ok := models.StatsPeriods[period]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的统计周期，可选 week、month、year"})
+			return
+		}
+
+		user, err := st.GetUserByID(userID)
+		if err != nil {
+			log.Printf("数据库查询失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+			return
+		}
+		events, err := st.ListLaunchEvents(userID)
+		if err != nil {
+			log.Printf("数据库查询失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+			return
+		}
+
+		stats := models.ComputeStats(events, models.UserLocation(user.Timezone), time.Now(), period)
+		if config.Env == "dev" {
+			log.Printf("为用户 %d 计算统计数据: %d 个事件", userID, len(events))
+		}
+		c.JSON(http.StatusOK, stats)
+	}
+}
@@ -2,8 +2,8 @@ package handlers
 
 import (
 	"backend/models"
-	"database/sql"
-	"encoding/json"
+	"backend/store"
+	"fmt"
 	"net/http"
 	"github.com/gin-gonic/gin"
 	"log"
@@ -11,131 +11,35 @@ import (
 )
 
 // GetSyncDataHandler 返回一个 Gin 处理函数，用于处理获取用户同步数据的请求。
-// 参数 db 是数据库连接，用于执行数据库查询操作。
+// 参数 st 是存储后端，用于查询用户的发射数据。
 // 参数 config 包含应用的配置信息，如环境模式等，用于控制日志输出。
-func GetSyncDataHandler(db *sql.DB, config *models.Config) gin.HandlerFunc {
+func GetSyncDataHandler(st store.Store, config *models.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// 从 Gin 上下文获取用户 ID，该 ID 通常由中间件注入
 		userID := c.GetInt("user_id")
-		// 若当前环境为开发环境，记录成功获取指定用户同步数据的日志
-		if config.Env == "dev" {
-			log.Printf("成功获取用户 %d 的同步数据", userID)
-		}
-
-		// 初始化 LaunchData 结构体，用于存储从数据库获取的用户发射数据
-		var data models.LaunchData
-		// 定义字节切片，用于存储从数据库获取的 JSON 格式的年度、月度和日度发射数据
-		var yearData, monthData, dayData []byte
-		// 定义 sql.NullTime 类型变量，用于存储从数据库获取的最后一次发射时间，支持 NULL 值
-		var lastLaunch sql.NullTime
 
-		// 添加更健壮的查询逻辑，从 launch_data 表中查询指定用户的发射数据
-		// 执行 SQL 查询语句，使用 db.QueryRow 方法获取单行查询结果
-		err := db.QueryRow(`
-			SELECT total, year_data, month_data, day_data, last_launch
-			FROM launch_data
-			WHERE user_id = ?
-		`, userID).Scan(
-			// 将查询结果扫描到对应的变量中
-			&data.Total,
-			&yearData,
-			&monthData,
-			&dayData,
-			&lastLaunch,
-		)
-
-		// 检查查询过程中是否出现错误
+		// 从存储后端读取用户的发射数据，尚无记录的用户会得到修订号为 0 的空数据
+		data, err := st.GetLaunchData(userID)
 		if err != nil {
-			// 若错误类型为 sql.ErrNoRows，说明数据库中没有该用户的发射数据记录
-			if err == sql.ErrNoRows {
-				// 记录用户未找到发射数据的日志
-				log.Printf("用户 %d 未找到发射数据", userID)
-				// 为该用户创建初始发射数据记录
-				_, createErr := db.Exec(`
-					INSERT INTO launch_data 
-					(user_id, total, year_data, month_data, day_data, last_launch)
-					VALUES (?, 0, '{}', '{}', '{}', NULL)
-				`, userID)
-				
-				// 检查创建初始数据是否失败
-				if createErr != nil {
-					// 若失败，记录错误日志并返回 500 状态码和错误信息
-					log.Printf("创建初始数据失败: %v", createErr)
-					c.JSON(http.StatusInternalServerError, gin.H{"error": "创建数据失败"})
-					return
-				}
-				
-				// 初始化空的发射数据结构体
-				data = models.LaunchData{
-					Total:      0,
-					YearData:   make(map[string]int),
-					MonthData:  make(map[string]int),
-					DayData:    make(map[string]int),
-					LastLaunch: time.Time{},
-				}
-				// 返回 200 状态码和初始化后的发射数据
-				c.JSON(http.StatusOK, gin.H{
-					"user_id":     data.UserID,
-					"total":       data.Total,
-					"year_data":   data.YearData,
-					"month_data":  data.MonthData,
-					"day_data":    data.DayData,
-					"last_launch": data.LastLaunch,
-				})
-				return
-			}
-			
-			// 若查询过程中出现其他错误，记录错误日志并返回 500 状态码和错误信息
+			// 若查询过程中出现错误，记录错误日志并返回 500 状态码和错误信息
 			log.Printf("数据库查询失败: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
 			return
 		}
 
-		// 处理时间字段，判断从数据库获取的最后一次发射时间是否有效
-		if lastLaunch.Valid {
-			// 若有效，将其赋值给 data.LastLaunch
-			data.LastLaunch = lastLaunch.Time
-		} else {
-			// 若无效，将 data.LastLaunch 初始化为零值时间
-			data.LastLaunch = time.Time{}
-		}
-
-		// 解析从数据库获取的 JSON 格式的发射数据
-		// 解析年度发射数据，将 JSON 字节切片转换为 map[string]int 类型
-		if err := json.Unmarshal(yearData, &data.YearData); err != nil {
-			// 若解析失败，记录错误日志并将 data.YearData 初始化为空映射
-			log.Printf("解析年度数据失败: %v", err)
-			data.YearData = make(map[string]int)
-		}
-		// 解析月度发射数据，将 JSON 字节切片转换为 map[string]int 类型
-		if err := json.Unmarshal(monthData, &data.MonthData); err != nil {
-			// 若解析失败，记录错误日志并将 data.MonthData 初始化为空映射
-			log.Printf("解析月度数据失败: %v", err)
-			data.MonthData = make(map[string]int)
-		}
-		// 解析日度发射数据，将 JSON 字节切片转换为 map[string]int 类型
-		if err := json.Unmarshal(dayData, &data.DayData); err != nil {
-			// 若解析失败，记录错误日志并将 data.DayData 初始化为空映射
-			log.Printf("解析日数据失败: %v", err)
-			data.DayData = make(map[string]int)
+		// 若当前环境为开发环境，记录成功获取指定用户同步数据的日志
+		if config.Env == "dev" {
+			log.Printf("成功获取用户 %d 的同步数据", userID)
 		}
-
 		// 返回 200 状态码和获取到的用户发射数据
-		c.JSON(http.StatusOK, gin.H{
-			"user_id":     data.UserID,
-			"total":       data.Total,
-			"year_data":   data.YearData,
-			"month_data":  data.MonthData,
-			"day_data":    data.DayData,
-			"last_launch": data.LastLaunch,
-		})
+		c.JSON(http.StatusOK, data)
 	}
 }
 
 // PostSyncDataHandler 返回一个 Gin 处理函数，用于处理用户提交同步数据的请求。
-// 参数 db 是数据库连接，用于执行数据库更新操作。
+// 参数 st 是存储后端，用于写入发射事件。
 // 参数 config 包含应用的配置信息，如环境模式等，用于控制日志输出。
-func PostSyncDataHandler(db *sql.DB, config *models.Config) gin.HandlerFunc {
+func PostSyncDataHandler(st store.Store, config *models.Config) gin.HandlerFunc {
     return func(c *gin.Context) {
         // 从 Gin 上下文获取用户 ID，该 ID 通常由中间件注入
         userID := c.GetInt("user_id")
@@ -153,6 +57,8 @@ func PostSyncDataHandler(db *sql.DB, config *models.Config) gin.HandlerFunc {
             MonthData  map[string]int  `json:"month_data"` // 月度发射数据
             DayData    map[string]int  `json:"day_data"` // 日度发射数据
             LastLaunch string          `json:"last_launch"` // 最后一次发射时间，字符串格式
+            Device     string          `json:"device"` // 提交快照的设备标识，可选
+            Revision   *int64          `json:"revision"` // 快照所基于的服务端修订号，开启 require_sync_revision 时必填
         }
 
         // 尝试将请求体中的 JSON 数据绑定到 req 结构体
@@ -163,6 +69,15 @@ func PostSyncDataHandler(db *sql.DB, config *models.Config) gin.HandlerFunc {
             return
         }
 
+        // 没有修订号时无法判断快照是否基于过期数据，两台设备基于同一份旧数据各自增加的发射会丢失一次
+        if req.Revision == nil {
+            if config.RequireSyncRevision {
+                c.JSON(http.StatusPreconditionRequired, gin.H{"error": "缺少 revision，请先通过 GET /sync 获取最新数据后再提交"})
+                return
+            }
+            log.Printf("用户 %d 提交了不带 revision 的快照，该方式已废弃，之后的版本将默认拒绝", userID)
+        }
+
         // 手动解析时间
         // 将客户端发送的最后一次发射时间字符串按照 RFC3339 格式解析为 time.Time 类型
         lastLaunch, err := time.Parse(time.RFC3339, req.LastLaunch)
@@ -184,26 +99,36 @@ func PostSyncDataHandler(db *sql.DB, config *models.Config) gin.HandlerFunc {
             LastLaunch: lastLaunch,
         }
 
-        // 准备JSON数据
-        // 将年度、月度和日度发射数据转换为 JSON 字节切片，以便存储到数据库
-        yearData, _ := json.Marshal(data.YearData)
-        monthData, _ := json.Marshal(data.MonthData)
-        dayData, _ := json.Marshal(data.DayData)
+        // 快照中的年/月/日由客户端按用户的时区统计，校验时使用同一时区
+        user, err := st.GetUserByID(userID)
+        if err != nil {
+            log.Printf("数据库查询失败: %v", err)
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库查询失败"})
+            return
+        }
 
-        // 更新数据库
-        // 执行 SQL 更新语句，将用户提交的同步数据更新到 launch_data 表中
-        _, err = db.Exec(`
-            UPDATE launch_data
-            SET total = ?, 
-                year_data = ?, 
-                month_data = ?, 
-                day_data = ?, 
-                last_launch = ?
-            WHERE user_id = ?
-        `, data.Total, yearData, monthData, dayData, data.LastLaunch, userID)
+        // 校验并规范化快照，防止有问题的客户端写入不一致的数据
+        if errs := models.ValidateLaunchData(&data, time.Now(), config.MaxClockSkew(), models.UserLocation(user.Timezone)); errs != nil {
+            log.Printf("用户 %d 提交的数据校验失败: %v", userID, errs)
+            c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "数据校验失败", "fields": errs})
+            return
+        }
 
+        // 将快照转换为发射事件并写入数据库
+        // 快照本身不再直接覆盖 launch_data，而是与服务端当前数据比较，
+        // 只把新增的发射次数追加到事件日志中，再由事件重新推导聚合数据
+        before, merged, err := applySnapshot(st, userID, data, req.Device, req.Revision)
+        if err == store.ErrRevisionConflict {
+            // 客户端基于过期数据提交，返回 409 状态码和服务端当前数据，由客户端变基后重试
+            c.JSON(http.StatusConflict, gin.H{
+                "error":    "数据已在其他设备上更新，请基于最新数据重新提交",
+                "revision": merged.Revision,
+                "data":     merged,
+            })
+            return
+        }
         if err != nil {
-            // 若更新失败，记录错误日志并返回 500 状态码和错误信息
+            // 若写入失败，记录错误日志并返回 500 状态码和错误信息
             log.Printf("更新数据失败: %v", err)
             c.JSON(http.StatusInternalServerError, gin.H{"error": "更新数据失败"})
             return
@@ -213,49 +138,149 @@ func PostSyncDataHandler(db *sql.DB, config *models.Config) gin.HandlerFunc {
         if config.Env == "dev" {
             log.Printf("用户 %d 数据同步成功", userID)
         }
-        // 向该用户的所有客户端广播更新后的数据
-        broadcastToUser(userID, data, config)
+        // 向该用户的所有客户端广播数据变化
+        broadcastToUser(userID, before, merged, config)
         // 返回 200 状态码和成功信息
-        c.JSON(http.StatusOK, gin.H{"message": "数据同步成功"})
+        c.JSON(http.StatusOK, gin.H{"message": "数据同步成功", "revision": merged.Revision})
     }
 }
 
-// broadcastToUser 函数用于向指定用户的所有客户端广播发射数据。
+// maxEventBatch 是单次增量同步请求允许携带的最大事件数量。
+const maxEventBatch = 1000
+
+// PostSyncEventsHandler 返回一个 Gin 处理函数，用于处理增量同步请求。
+// 客户端提交一批带有自生成 ID 的发射事件，服务端将其合并到事件日志中，
+// 已存在的事件 ID 会被视为重试而忽略，因此多台设备离线计数后同步也不会互相覆盖。
+// 参数 st 是存储后端，config 包含应用的配置信息。
+func PostSyncEventsHandler(st store.Store, config *models.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// 从 Gin 上下文获取用户 ID，该 ID 由认证中间件注入
+		userID := c.GetInt("user_id")
+
+		// 定义请求结构体，device 和 timezone 为批次中未单独指定的事件提供默认值。
+		// timezone 是发射时设备所在的时区，为空表示按用户的时区统计
+		var req struct {
+			Device   string `json:"device"`
+			Timezone string `json:"timezone"`
+			Events   []struct {
+				EventID    string    `json:"event_id"`
+				Device     string    `json:"device"`
+				LaunchedAt time.Time `json:"launched_at"`
+				Timezone   string    `json:"timezone"`
+			} `json:"events" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			log.Printf("解析请求体失败: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据"})
+			return
+		}
+		if len(req.Events) > maxEventBatch {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("单次最多提交 %d 个事件", maxEventBatch)})
+			return
+		}
+
+		// 转换并校验事件
+		events := make([]models.LaunchEvent, 0, len(req.Events))
+		for _, e := range req.Events {
+			device := e.Device
+			if device == "" {
+				device = req.Device
+			}
+			timezone := e.Timezone
+			if timezone == "" {
+				timezone = req.Timezone
+			}
+			events = append(events, models.LaunchEvent{
+				UserID:     userID,
+				EventID:    e.EventID,
+				Device:     device,
+				LaunchedAt: e.LaunchedAt,
+				Timezone:   timezone,
+			})
+		}
+		if errs := models.ValidateLaunchEvents(events, time.Now(), config.MaxClockSkew()); errs != nil {
+			log.Printf("用户 %d 提交的事件校验失败: %v", userID, errs)
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "数据校验失败", "fields": errs})
+			return
+		}
+
+		var before models.LaunchData
+		merged, inserted, err := st.AppendLaunchEvents(userID, func(current models.LaunchData) ([]models.LaunchEvent, error) {
+			before = current
+			return events, nil
+		})
+		if err != nil {
+			log.Printf("写入发射事件失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "更新数据失败"})
+			return
+		}
+
+		if config.Env == "dev" {
+			log.Printf("用户 %d 增量同步: 新增 %d 个事件, 重复 %d 个", userID, inserted, len(events)-inserted)
+		}
+		// 只有确实新增了事件时才需要通知其他设备
+		if inserted > 0 {
+			broadcastToUser(userID, before, merged, config)
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"accepted":   inserted,
+			"duplicates": len(events) - inserted,
+			"data":       merged,
+		})
+	}
+}
+
+// applySnapshot 将客户端快照合并到用户的发射事件日志中。
+// 参数 st 是存储后端，userID 是当前用户，snapshot 是客户端提交的完整数据，device 是设备标识。
+// 参数 baseRevision 是客户端快照所基于的修订号，为 nil 时不做并发检查（兼容旧客户端）。
+// 返回合并前的数据以及合并后由事件重新计算得到的发射数据；
+// 修订号不匹配时返回服务端当前数据和 store.ErrRevisionConflict。
+func applySnapshot(st store.Store, userID int, snapshot models.LaunchData, device string, baseRevision *int64) (models.LaunchData, models.LaunchData, error) {
+	var before models.LaunchData
+	merged, _, err := st.AppendLaunchEvents(userID, func(current models.LaunchData) ([]models.LaunchEvent, error) {
+		before = current
+		if baseRevision != nil && *baseRevision != current.Revision {
+			return nil, store.ErrRevisionConflict
+		}
+		// 快照中的日期按用户当前的时区解析，生成的事件跟随用户的时区
+		return models.EventsFromSnapshot(current, snapshot, device, models.UserLocation(current.Timezone)), nil
+	})
+	return before, merged, err
+}
+
+// broadcastToUser 函数用于向指定用户的所有客户端广播数据变化。
+// 新协议客户端收到从 before 到 after 的增量消息，旧版客户端收到 after 的完整快照。
 // 参数 userID 是目标用户的 ID，用于从客户端映射中筛选出该用户的客户端。
-// 参数 data 是需要广播的发射数据，将被发送到每个客户端。
+// 参数 before 和 after 是变化前后的发射数据，修订号相同时说明数据没有变化，不会广播。
 // 参数 config 包含应用的配置信息，如环境模式等，用于控制日志输出。
-func broadcastToUser(userID int, data models.LaunchData, config *models.Config) {
-	// 对客户端列表加读锁，防止在遍历过程中客户端列表被修改。
-	// 读锁允许其他协程同时读取客户端列表，但阻止写操作，保证并发安全。
-	models.ClientsLock.RLock()
-	// 函数结束时自动释放读锁，确保资源正确释放。
-	defer models.ClientsLock.RUnlock()
-
-	// 从客户端映射中获取指定用户的所有客户端。
-	// models.Clients 是一个映射，键为用户 ID，值为客户端实例切片。
-	userClients, ok := models.Clients[userID]
-	// 若该用户没有客户端连接，即映射中不存在该用户的键，则直接返回，不进行后续操作。
-	if !ok {
+func broadcastToUser(userID int, before, after models.LaunchData, config *models.Config) {
+	if before.Revision == after.Revision {
 		return
 	}
 
-	// 遍历该用户的所有客户端，依次尝试向每个客户端发送数据。
-	for _, client := range userClients {
-		// 使用 select 语句尝试将数据发送到客户端的 Send 通道。
-		// select 语句会尝试执行每个 case 分支，若有多个分支可执行，会随机选择一个执行。
-		select {
-		// 若客户端的 Send 通道有空闲缓冲区，将数据发送到该通道。
-		case client.Send <- data:
-			// 若当前环境为开发环境，记录成功向用户推送数据的日志。
-			if config.Env == "dev" {
-				log.Printf("成功向用户 %d 推送数据", userID)
+	// 更新日志为本次推送分配序号并记录增量，供断线重连的客户端补发；
+	// 推送在更新日志的锁内完成，保证各连接收到的消息按序号排列
+	models.Updates.Publish(userID, before, after, func(delta, snapshot models.Envelope) {
+		// 遍历该用户的所有客户端，依次尝试向每个客户端发送数据。
+		// 遍历期间持有注册表的读锁，客户端的注册和注销会等待本次推送完成。
+		models.Hub.ForUser(userID, func(client *models.Client) {
+			env := snapshot
+			if client.Protocol == models.ProtocolV2 {
+				env = delta
 			}
-		default:
-			// 若客户端的 Send 通道已满，无法发送数据，记录通道已满的日志。
-			log.Printf("用户 %d 的通道已满，准备关闭连接", userID)
-			// 启动一个 goroutine 来注销该客户端连接，避免阻塞当前协程。
-			// unregisterClient 函数负责处理客户端断开连接的逻辑。
-			go unregisterClient(client, config)
-		}
-	}
-}
\ No newline at end of file
+			// 尝试将消息放入客户端的发送队列，队列已满时不会阻塞
+			if client.Enqueue(env) {
+				// 若当前环境为开发环境，记录成功向用户推送数据的日志。
+				if config.Env == "dev" {
+					log.Printf("成功向用户 %d 推送数据 (seq=%d)", userID, env.Seq)
+				}
+			} else {
+				// 若客户端的 Send 通道已满或客户端已关闭，无法发送数据，关闭该客户端。
+				// 关闭是幂等的，连接断开后由连接处理函数完成注销；
+				// 客户端重连时可以携带 since 参数补发本次错过的更新。
+				log.Printf("用户 %d 的通道已满，准备关闭连接", userID)
+				client.Close()
+			}
+		})
+	})
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"backend/models"
+	"backend/store"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UpdateTimezoneHandler 返回一个 Gin 处理函数，用于修改当前用户统计发射数据所用的时区。
+// 请求体形如 {"timezone": "Europe/Berlin", "rebucket": false}，timezone 为空表示恢复使用服务器的默认时区。
+// rebucket 为 true 时全部历史发射按新时区重新划分年/月/日；为 false 时已有的统计保持不变，只有之后的发射使用新时区。
+// 修改成功后返回新的发射数据，并向该用户的所有连接推送更新。
+// 参数 st 是存储后端，config 包含应用的配置信息。
+func UpdateTimezoneHandler(st store.Store, config *models.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("user_id")
+
+		var req struct {
+			Timezone string `json:"timezone"`
+			Rebucket bool   `json:"rebucket"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据"})
+			return
+		}
+		if req.Timezone != "" {
+			if err := models.ValidateTimezone(req.Timezone); err != nil {
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "无效的时区，请使用 IANA 时区名，如 Asia/Shanghai"})
+				return
+			}
+		}
+
+		before, after, err := st.SetUserTimezone(userID, req.Timezone, req.Rebucket)
+		if err == store.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+			return
+		}
+		if err != nil {
+			log.Printf("修改用户 %d 的时区失败: %v", userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "修改时区失败"})
+			return
+		}
+
+		if config.Env == "dev" {
+			log.Printf("用户 %d 的时区由 %s 改为 %s (rebucket=%v)", userID, before.Timezone, after.Timezone, req.Rebucket)
+		}
+		// 时区和统计结果都可能变化，通知该用户的其他设备
+		broadcastToUser(userID, before, after, config)
+		c.JSON(http.StatusOK, gin.H{
+			"message":  "时区已更新",
+			"timezone": after.Timezone,
+			"data":     after,
+		})
+	}
+}
@@ -2,12 +2,18 @@ package handlers
 
 import (
 	"backend/models"
-	"database/sql"
+	"backend/store"
+	"backend/tokens"
+	"errors"
+	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
-	"github.com/golang-jwt/jwt/v4"
-	"fmt"
+	"encoding/json"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 )
@@ -15,76 +21,53 @@ import (
 
 
 // WebSocketHandler 返回一个 Gin 处理函数，用于处理 WebSocket 连接请求。
-// 参数 db 是数据库连接，用于查询用户信息。
-// 参数 config 包含应用的配置信息，如 JWT 密钥和环境模式等。
-func WebSocketHandler(db *sql.DB, config *models.Config) gin.HandlerFunc {
+// 客户端可以通过三种方式认证，优先级依次为：
+//   - ticket 查询参数：先调用 POST /ws/ticket 换取的一次性票据；
+//   - Sec-WebSocket-Protocol 头：请求子协议 "launchcounter.token.<访问令牌>"，同时请求 launchcounter.v2；
+//   - token 查询参数：旧版客户端使用的方式，已废弃，可以通过 ws_disable_query_token 关闭。
+// 新协议客户端可以通过 since 查询参数提供已处理的最后一个推送序号，服务端据此补发错过的更新。
+// 参数 st 是存储后端，用于查询用户信息和校验会话。
+// 参数 config 包含应用的配置信息，如 JWT 密钥、允许的来源和环境模式等。
+// 参数 tickets 是连接票据存储，用于兑换 ticket 参数。
+// 参数 tm 是令牌管理器，与 HTTP 接口使用同一套校验规则。
+func WebSocketHandler(st store.Store, config *models.Config, tickets *models.TicketStore, tm *tokens.Manager) gin.HandlerFunc {
+    originAllowed := checkOrigin(config)
     return func(c *gin.Context) {
-        // 从查询参数获取 token
-        tokenString := c.Query("token")
-        if tokenString == "" {
-            // 若未提供 token，记录日志并返回 401 未授权响应
-            log.Println("WebSocket连接缺少token参数")
-            c.JSON(http.StatusUnauthorized, gin.H{"error": "未提供认证令牌"})
+        // 先检查来源，避免其他网站的页面消耗掉用户的票据
+        if !originAllowed(c.Request) {
+            log.Printf("拒绝来自 %q 的WebSocket连接", c.GetHeader("Origin"))
+            c.JSON(http.StatusForbidden, gin.H{"error": "不允许的来源"})
             return
         }
 
-        // 若当前环境不是生产环境，记录收到的 WebSocket 连接请求及 token 信息
-        if config.Env != "release" {
-            log.Printf("收到WebSocket连接请求，token: %s", tokenString)
-        }
-
-        // 验证 token
-        token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-            // 检查 token 的签名方法是否为 HMAC
-            if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-                // 若签名方法不符，返回错误信息
-                return nil, fmt.Errorf("非预期的签名方法: %v", token.Header["alg"])
-            }
-            // 返回 JWT 签名密钥
-            return []byte(config.JWTSecretKey), nil
-        })
-
+        // 根据票据或访问令牌确认用户身份，并确认其所属会话未被吊销
+        userIDInt, sessionID, err := authenticateWebSocket(c, st, config, tickets, tm)
         if err != nil {
-            // 若 JWT 验证失败，在开发环境记录错误日志，并返回 401 未授权响应
+            // 若验证失败，在开发环境记录错误日志，并返回 401 未授权响应
             if config.Env == "dev" {
-                log.Printf("JWT验证失败: %v", err)
+                log.Printf("WebSocket认证失败: %v", err)
             }
-            c.JSON(http.StatusUnauthorized, gin.H{"error": "无效的认证令牌"})
+            abortUnauthorized(c, err)
             return
         }
 
-        // 初始化 WebSocket 升级器，设置允许来自任何来源的连接
-        upgrader := websocket.Upgrader{
-            CheckOrigin: func(r *http.Request) bool {
-                // 允许来自任何来源的 WebSocket 连接
-                return true
-            },
-        }
-
-        // 从 token 获取用户 ID
-        claims, ok := token.Claims.(jwt.MapClaims)
-        if !ok {
-            // 若无法解析 JWT 声明，记录日志并返回 401 未授权响应
-            log.Println("无法解析JWT声明")
-            c.JSON(http.StatusUnauthorized, gin.H{"error": "无效的令牌声明"})
-            return
-        }
-
-        userID, ok := claims["user_id"]
-        if !ok {
-            // 若令牌缺少 user_id 声明，记录日志并返回 401 未授权响应
-            log.Println("令牌缺少user_id声明")
-            c.JSON(http.StatusUnauthorized, gin.H{"error": "无效的用户ID"})
-            return
+        // since 是客户端已处理的最后一个推送序号，用于断线重连后补发错过的更新
+        since := int64(-1)
+        if v := c.Query("since"); v != "" {
+            n, err := strconv.ParseInt(v, 10, 64)
+            if err != nil || n < 0 {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "无效的 since 参数"})
+                return
+            }
+            since = n
         }
 
-        // 转换用户ID为整数
-        userIDInt, ok := userID.(float64)
-        if !ok {
-            // 若用户 ID 类型错误，记录日志并返回 401 未授权响应
-            log.Printf("用户ID类型错误: %T", userID)
-            c.JSON(http.StatusUnauthorized, gin.H{"error": "无效的用户ID格式"})
-            return
+        // 初始化 WebSocket 升级器，来源检查与上面相同
+        upgrader := websocket.Upgrader{
+            // 支持消息信封协议的客户端通过 Sec-WebSocket-Protocol 协商该子协议；
+            // 携带令牌的子协议不在列表中，因此不会被回显到响应头里
+            Subprotocols: []string{models.ProtocolV2},
+            CheckOrigin:  originAllowed,
         }
 
         // 升级 HTTP 连接为 WebSocket 连接
@@ -96,8 +79,7 @@ func WebSocketHandler(db *sql.DB, config *models.Config) gin.HandlerFunc {
         }
 
         // 获取用户名
-        var username string
-        err = db.QueryRow("SELECT username FROM users WHERE id = ?", int(userIDInt)).Scan(&username)
+        user, err := st.GetUserByID(userIDInt)
         if err != nil {
             // 若获取用户信息失败，记录日志并关闭 WebSocket 连接
             log.Printf("获取用户信息失败: %v", err)
@@ -106,44 +88,129 @@ func WebSocketHandler(db *sql.DB, config *models.Config) gin.HandlerFunc {
         }
 
         // 创建客户端实例
-        client := &models.Client{
-            Conn:      conn,       // WebSocket 连接
-            UserID:    int(userIDInt), // 用户 ID
-            Username:  username,   // 用户名
-            IP:        c.ClientIP(), // 客户端 IP 地址
-            ConnectAt: time.Now(), // 连接时间
-            Send:      make(chan models.LaunchData, 256), // 用于发送数据的通道
-        }
+        // 心跳、超时和消息大小限制取自配置，协商的子协议取自连接，旧版客户端为空
+        client := models.NewClient(conn, config.WebSocket())
+        client.UserID = userIDInt // 用户 ID
+        client.Username = user.Username // 用户名
+        client.IP = c.ClientIP() // 客户端 IP 地址
+        client.SessionID = sessionID // 会话 ID，会话吊销时据此断开连接
 
-        // 注册客户端
-        registerClient(client, config)
+        if client.Protocol == models.ProtocolV2 {
+            // 新协议客户端连接后先补发 since 之后错过的更新，无法补齐时收到一份完整快照，之后只接收增量
+            data, err := st.GetLaunchData(userIDInt)
+            if err != nil {
+                log.Printf("获取用户 %d 的初始快照失败: %v", userIDInt, err)
+                conn.Close()
+                return
+            }
+            models.Updates.Resume(userIDInt, since, data, func(replay []models.Envelope, resumed bool) {
+                // 在更新日志的锁内注册，补发消息与之后的实时推送之间不会遗漏或乱序
+                registerClient(client, config)
+                for _, env := range replay {
+                    client.Enqueue(env)
+                }
+                if config.Env == "dev" && since >= 0 {
+                    log.Printf("用户 %d 从 seq=%d 恢复连接，补发 %d 条消息 (增量: %v)", userIDInt, since, len(replay), resumed)
+                }
+            })
+        } else {
+            // 注册客户端
+            registerClient(client, config)
+        }
         // 确保在函数结束时注销客户端
         defer unregisterClient(client, config)
 
         // 启动写协程，负责向客户端发送数据
         go client.WritePump()
-        // 启动读协程，负责从客户端接收数据
-        client.ReadPump()
+        // 启动读协程，负责从客户端接收并处理消息
+        client.ReadPump(handleClientMessage(st, config))
 
         // 在开发环境记录 WebSocket 连接建立信息
         if config.Env == "dev" {
-            log.Printf("用户 %s (%d) WebSocket连接已建立", username, int(userIDInt))
+            log.Printf("用户 %s (%d) WebSocket连接已建立", user.Username, userIDInt)
         }
     }
 }
 
-// registerClient 函数用于将新的客户端实例注册到全局的客户端映射中。
+// authenticateWebSocket 从 WebSocket 连接请求中取出票据或访问令牌并完成认证，
+// 返回用户 ID 和会话 ID。票据兑换后立即失效，但仍会检查其所属会话是否已被吊销。
+func authenticateWebSocket(c *gin.Context, st store.Store, config *models.Config, tickets *models.TicketStore, tm *tokens.Manager) (int, string, error) {
+	if ticket := c.Query("ticket"); ticket != "" {
+		t, ok := tickets.Redeem(ticket)
+		if !ok {
+			return 0, "", errors.New("票据无效、已使用或已过期")
+		}
+		if err := checkSession(st, t.UserID, t.SessionID); err != nil {
+			return 0, "", err
+		}
+		return t.UserID, t.SessionID, nil
+	}
+
+	tokenString := ""
+	for _, protocol := range websocket.Subprotocols(c.Request) {
+		if strings.HasPrefix(protocol, models.ProtocolTokenPrefix) {
+			tokenString = strings.TrimPrefix(protocol, models.ProtocolTokenPrefix)
+			break
+		}
+	}
+	if tokenString == "" && !config.WSDisableQueryToken {
+		// 旧版客户端通过查询参数传递令牌，本服务的访问日志会隐藏其取值，但代理等中间环节仍可能记录完整的 URL
+		if tokenString = c.Query("token"); tokenString != "" {
+			log.Printf("来自 %s 的WebSocket连接通过 URL 查询参数传递访问令牌，该方式已废弃，请改用连接票据", c.ClientIP())
+		}
+	}
+	if tokenString == "" {
+		return 0, "", errNoCredentials
+	}
+
+	// 若当前环境不是生产环境，记录收到的 WebSocket 连接请求，令牌只记录指纹
+	if config.Env != "release" {
+		log.Printf("收到WebSocket连接请求，token: %s", models.RedactToken(tokenString))
+	}
+	return authenticateToken(st, tm, tokenString, config)
+}
+
+// checkOrigin 返回检查 WebSocket 连接来源的函数。
+// 原生客户端不发送 Origin 头，总是允许；浏览器发起的连接只允许同源页面和 ws_allowed_origins 中列出的来源。
+func checkOrigin(config *models.Config) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		for _, allowed := range config.WSAllowedOrigins {
+			if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+				return true
+			}
+		}
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+}
+
+// WSTicketHandler 为当前会话签发一张一次性的 WebSocket 连接票据，需要在 AuthMiddleware 之后使用。
+// 客户端随后以 /ws?ticket=<票据> 建立连接，票据在有效期内只能使用一次。
+// 参数 tickets 是连接票据存储。
+// 参数 config 包含应用的配置信息，如环境模式等。
+func WSTicketHandler(tickets *models.TicketStore, config *models.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("user_id")
+		ticket := tickets.Issue(userID, c.GetString("session_id"))
+		if config.Env == "dev" {
+			log.Printf("为用户 %d 签发WebSocket票据: %s", userID, models.RedactToken(ticket))
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"ticket":     ticket,
+			"expires_in": int(tickets.TTL().Seconds()),
+		})
+	}
+}
+
+// registerClient 函数用于将新的客户端实例登记到全局的客户端注册表中。
 // 参数 client 是需要注册的客户端实例，包含客户端的连接信息、用户信息等。
 // 参数 config 包含应用的配置信息，如环境模式等，用于控制日志输出。
 func registerClient(client *models.Client, config *models.Config) {
-	// 对全局的客户端映射加写锁，防止在注册过程中其他协程对客户端映射进行读写操作，保证并发安全。
-	models.ClientsLock.Lock()
-	// 函数结束时自动释放写锁，确保资源正确释放。
-	defer models.ClientsLock.Unlock()
-
-	// 将新的客户端实例添加到全局客户端映射中对应用户 ID 的客户端列表里。
-	// 若该用户 ID 对应的列表不存在，则创建一个新的列表。
-	models.Clients[client.UserID] = append(models.Clients[client.UserID], client)
+	models.Hub.Register(client)
 
 	// 若当前环境为开发环境，记录新客户端连接的日志，包含用户名、用户 ID 和客户端 IP 地址。
 	if config.Env == "dev" {
@@ -151,36 +218,88 @@ func registerClient(client *models.Client, config *models.Config) {
 	}
 }
 
-// unregisterClient 函数用于将指定客户端实例从全局的客户端映射中注销，
-// 并关闭客户端的连接和发送通道。
+// unregisterClient 函数用于将指定客户端实例从全局的客户端注册表中注销并关闭客户端。
+// 只应由连接处理函数在读协程返回后调用；其他地方需要断开连接时调用 client.Close 即可。
 // 参数 client 是需要注销的客户端实例，包含客户端的连接信息、用户信息等。
 // 参数 config 包含应用的配置信息，如环境模式等，用于控制日志输出。
 func unregisterClient(client *models.Client, config *models.Config) {
-	// 对全局的客户端映射加写锁，防止在注销过程中其他协程对客户端映射进行读写操作，保证并发安全。
-	models.ClientsLock.Lock()
-	// 函数结束时自动释放写锁，确保资源正确释放。
-	defer models.ClientsLock.Unlock()
-
-	// 从全局客户端映射中获取该用户 ID 对应的客户端列表
-	userClients := models.Clients[client.UserID]
-	// 遍历该用户的客户端列表
-	for i, c := range userClients {
-		// 找到需要注销的客户端实例
-		if c == client {
-			// 从列表中移除该客户端实例
-			models.Clients[client.UserID] = append(userClients[:i], userClients[i+1:]...)
-			// 找到后跳出循环
-			break
+	// 重复注销是安全的，只有第一次会从注册表中移除
+	removed := models.Hub.Unregister(client)
+
+	// 若当前环境为开发环境，记录客户端断开连接的日志，包含用户名和用户 ID
+	if removed && config.Env == "dev" {
+		log.Printf("用户 %s (%d) 已断开连接", client.Username, client.UserID)
+	}
+}
+
+// handleClientMessage 返回处理新协议客户端上行消息的函数，在该连接的读协程中依次调用。
+// 每个连接调用一次，launch 消息按连接限速，超出时回复 error 消息而不写入数据库。
+// 参数 st 是存储后端，用于记录通过 launch 消息提交的发射。
+// 参数 config 包含应用的配置信息，如允许的时钟偏差、launch 消息的限速和环境模式。
+func handleClientMessage(st store.Store, config *models.Config) func(*models.Client, models.Envelope) {
+	// 每条 launch 消息都会执行一次加锁的事务并重新统计该用户的全部事件，
+	// HTTP 限速只覆盖 /ws 的握手，连接建立后的消息需要单独限速
+	launchLimiter := models.NewRateLimiter(config.WSLaunchRateLimit())
+	return func(client *models.Client, env models.Envelope) {
+		switch env.Type {
+		case models.MessagePing:
+			client.Enqueue(models.NewEnvelope(models.MessagePong, env.ID, nil))
+		case models.MessageAck:
+			// 客户端确认已处理某条推送，目前仅用于调试
+			if config.Env == "dev" {
+				log.Printf("用户 %d 确认消息 %s", client.UserID, env.ID)
+			}
+		case models.MessageLaunch:
+			if ok, wait := launchLimiter.Allow(models.MessageLaunch); !ok {
+				client.Enqueue(models.NewEnvelope(models.MessageError, env.ID,
+					models.ErrorPayload{Message: fmt.Sprintf("发送过于频繁，请 %d 秒后再试", int(math.Ceil(wait.Seconds())))}))
+				return
+			}
+			handleLaunchMessage(st, config, client, env)
+		default:
+			client.Enqueue(models.NewEnvelope(models.MessageError, env.ID,
+				models.ErrorPayload{Message: "未知的消息类型: " + env.Type}))
 		}
 	}
+}
 
-	// 关闭客户端的发送通道，防止继续向已断开的客户端发送数据
-	close(client.Send)
-	// 关闭客户端的 WebSocket 连接
-	client.Conn.Close()
+// handleLaunchMessage 处理客户端通过 WebSocket 记录的一次发射，
+// 效果与向 POST /sync/events 提交单个事件相同，成功后以 ack 回复并向该用户的所有连接推送更新。
+func handleLaunchMessage(st store.Store, config *models.Config, client *models.Client, env models.Envelope) {
+	var payload models.LaunchPayload
+	if err := json.Unmarshal(env.Payload, &payload); err != nil {
+		client.Enqueue(models.NewEnvelope(models.MessageError, env.ID, models.ErrorPayload{Message: "无效的 launch 消息"}))
+		return
+	}
+	event := models.LaunchEvent{
+		UserID:     client.UserID,
+		EventID:    payload.EventID,
+		Device:     payload.Device,
+		LaunchedAt: payload.LaunchedAt,
+		Timezone:   payload.Timezone,
+	}
+	if errs := models.ValidateLaunchEvents([]models.LaunchEvent{event}, time.Now(), config.MaxClockSkew()); errs != nil {
+		client.Enqueue(models.NewEnvelope(models.MessageError, env.ID, models.ErrorPayload{Message: "数据校验失败", Fields: errs}))
+		return
+	}
 
-	// 若当前环境为开发环境，记录客户端断开连接的日志，包含用户名和用户 ID
-	if config.Env == "dev" {
-		log.Printf("用户 %s (%d) 已断开连接", client.Username, client.UserID)
+	var before models.LaunchData
+	merged, inserted, err := st.AppendLaunchEvents(client.UserID, func(current models.LaunchData) ([]models.LaunchEvent, error) {
+		before = current
+		return []models.LaunchEvent{event}, nil
+	})
+	if err != nil {
+		log.Printf("写入发射事件失败: %v", err)
+		client.Enqueue(models.NewEnvelope(models.MessageError, env.ID, models.ErrorPayload{Message: "更新数据失败"}))
+		return
 	}
-}
\ No newline at end of file
+
+	client.Enqueue(models.NewEnvelope(models.MessageAck, env.ID, models.AckPayload{
+		Accepted:  inserted > 0,
+		Duplicate: inserted == 0,
+		Revision:  merged.Revision,
+	}))
+	if inserted > 0 {
+		broadcastToUser(client.UserID, before, merged, config)
+	}
+}
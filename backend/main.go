@@ -1,38 +1,49 @@
 package main
 
 import (
-	"database/sql"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
+	// 内嵌 IANA 时区数据库，精简的容器镜像中没有系统时区数据时用户时区仍然可用
+	_ "time/tzdata"
 	"backend/commands"
 	"backend/handlers"
 	"backend/models"
-	"strings"
-	"crypto/sha256"
+	"backend/store"
+	"backend/tokens"
 	"net/http"
 	"github.com/gin-gonic/gin"
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/gorilla/websocket"
 )
 
 var (
-	db     *sql.DB
+	st     store.Store
 	config models.Config
+	// loginGuard 记录登录失败次数和被锁定的账号，由登录接口和命令行共享
+	loginGuard *models.LoginGuard
 )
 
 // main 是程序的入口函数，负责初始化各项配置、启动数据库、命令行界面和 HTTP 服务器。
 func main() {
-	// 设置时区
-	// 尝试加载亚洲/上海时区，如果成功则将本地时区设置为该时区，失败则记录错误信息。
-    loc, err := time.LoadLocation("Asia/Shanghai")
-    if err == nil {
-        time.Local = loc
-    } else {
-        log.Printf("设置时区失败: %v", err)
-    }
 	// 加载配置
-	// 从 config/config.json 文件中加载配置信息到全局变量 config 中。
-	models.LoadConfig("config/config.json", &config)
+	// 依次合并默认值、配置文件、LC_ 开头的环境变量和命令行参数，存入全局变量 config 中；配置无效时立即退出。
+	var err error
+	config, err = models.LoadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("加载配置失败: %v", err)
+	}
+
+	// 没有设置时区的用户按配置中的默认时区统计发射数据，服务器自身的本地时区不受影响
+	models.DefaultLocation = config.Timezone()
 
 	// 设置Gin运行模式
 	// 根据配置文件中的环境变量，设置 Gin 框架的运行模式，开发环境使用调试模式，其他使用生产模式。
@@ -41,32 +52,48 @@ func main() {
 	} else {
 		gin.SetMode(gin.ReleaseMode)
 	}
-	// 去除 JWT 密钥两端的空白字符
-	// 防止因配置文件中可能存在的空白字符影响 JWT 验证
-	config.JWTSecretKey = strings.TrimSpace(config.JWTSecretKey)
     // 开发环境输出密钥信息
-	// 在开发环境下，打印 JWT 密钥及其哈希值，方便调试。
+	// 只记录密钥长度，密钥本身及其哈希都不会写入日志。
 	if config.Env == "dev" {
-		log.Printf("JWT密钥: '%s' (长度: %d)", config.JWTSecretKey, len(config.JWTSecretKey))
-		// 计算并打印密钥哈希
-		h := sha256.New()
-		h.Write([]byte(config.JWTSecretKey))
-		log.Printf("JWT密钥哈希: %x", h.Sum(nil))
+		log.Printf("JWT密钥已加载 (长度: %d)", len(config.JWTSecretKey))
 	}
 
 	// 初始化数据库
-	// 调用 initDB 函数连接数据库并创建必要的表。
+	// 调用 initDB 函数根据配置打开存储后端并执行数据库迁移。
 	initDB()
 
+	// 创建登录保护器，用于登录限速和账号锁定
+	loginGuard = models.NewLoginGuard(&config)
+	// 加载访问令牌的密钥环，首次启动时由 jwt_secret_key 导入，已签发的令牌继续有效
+	keyring, err := tokens.LoadKeyring(config.KeyringPath(), config.JWTSecretKey, config.JWTAlgorithm, config.KeyRetention())
+	if err != nil {
+		log.Fatalf("加载密钥环失败: %v", err)
+	}
+	// 创建令牌管理器，HTTP 接口、WebSocket 和诊断接口共用同一套令牌校验规则
+	tokenManager := tokens.NewManager(&config, keyring)
+	// 创建 WebSocket 连接票据存储，票据只保存在内存中
+	wsTickets := models.NewTicketStore(config.WSTicketTTL())
+	// 按配置的缓冲区大小创建推送更新日志，用于 WebSocket 断线重连后补发错过的更新
+	models.Updates = models.NewUpdateLog(config.ReplayBufferSize())
+
 	// 启动命令行界面
-	// 在一个新的 goroutine 中启动命令行界面，传入数据库连接、客户端列表和客户端锁。
-	go commands.StartCLI(db, &models.Clients, &models.ClientsLock)
+	// 在一个新的 goroutine 中启动命令行界面，传入存储后端、在线客户端注册表、登录保护器、密钥环和配置。
+	// 在命令行输入 exit 时关闭 cliExit，与收到停止信号一样停止服务。
+	cliExit := make(chan struct{})
+	go func() {
+		if commands.StartCLI(st, models.Hub, loginGuard, keyring, &config) {
+			close(cliExit)
+		}
+	}()
 
     // 设置Gin路由
-    // 创建一个默认的 Gin 引擎，包含日志和恢复中间件。
-    router := gin.Default()
+    // 创建 Gin 引擎，使用会隐藏敏感查询参数的访问日志和恢复中间件。
+    router := gin.New()
+    router.Use(gin.LoggerWithFormatter(handlers.LogFormatter), gin.Recovery())
 	// 设置信任的代理，仅信任 127.0.0.1 作为代理，直接获取客户端真实 IP
 	router.SetTrustedProxies([]string{"127.0.0.1"})
+	// 全局按 IP 限速，覆盖 /sync、/ws 在内的全部接口
+	router.Use(handlers.RateLimitMiddleware(models.NewRateLimiter(config.RateLimit())))
 
 	// 添加健康检查端点
     // 注册一个 GET 请求的健康检查端点，返回服务器状态和当前时间，用于检查服务器是否正常运行。
@@ -79,90 +106,127 @@ func main() {
 
     // 用户认证相关路由
     // 注册用户注册和登录的 POST 请求路由，调用对应的处理函数处理认证请求。
-    router.POST("/auth", handlers.AuthHandler(db, &config))
+    // 登录和注册需要执行 bcrypt 计算，额外使用更严格的按 IP 限速
+    authLimit := handlers.RateLimitMiddleware(models.NewRateLimiter(config.LoginRateLimit()))
+    router.POST("/auth/register", authLimit, handlers.RegisterHandler(st, &config, tokenManager))
+    router.POST("/auth/login", authLimit, handlers.LoginHandler(st, &config, loginGuard, tokenManager))
+    // 旧版客户端使用的统一认证地址，现在只用于登录，不再自动注册
+    router.POST("/auth", authLimit, handlers.LoginHandler(st, &config, loginGuard, tokenManager))
+    // 使用刷新令牌换取新的访问令牌，刷新令牌同时轮换
+    router.POST("/auth/refresh", handlers.RefreshHandler(st, &config, tokenManager))
+    // 公开非对称签名密钥的公钥，其他服务可以据此校验访问令牌
+    router.GET("/.well-known/jwks.json", handlers.JWKSHandler(tokenManager))
     
     // 需要认证的路由组
     // 创建一个路由组，应用 JWT 认证中间件，只有通过认证的请求才能访问该组内的路由。
     authGroup := router.Group("/")
-    authGroup.Use(handlers.AuthMiddleware(&config)) // 应用JWT认证中间件
+    authGroup.Use(handlers.AuthMiddleware(st, &config, tokenManager)) // 应用JWT认证中间件
     {
+        // 退出登录，吊销当前会话（或全部会话）
+        authGroup.POST("/auth/logout", handlers.LogoutHandler(st, &config))
         // 注册同步数据的 GET 和 POST 请求路由，分别调用对应的处理函数，用于获取和提交同步数据。
-        authGroup.GET("/sync", handlers.GetSyncDataHandler(db, &config))
-        authGroup.POST("/sync", handlers.PostSyncDataHandler(db, &config))
+        authGroup.GET("/sync", handlers.GetSyncDataHandler(st, &config))
+        authGroup.POST("/sync", handlers.PostSyncDataHandler(st, &config))
+        // 注册增量同步路由，客户端以幂等事件批次提交发射记录
+        authGroup.POST("/sync/events", handlers.PostSyncEventsHandler(st, &config))
+        // 由服务端计算的统计数据，各端展示的数字保持一致
+        authGroup.GET("/stats", handlers.StatsHandler(st, &config))
+        // 按时间范围和粒度查询补零后的发射历史，供图表分页加载
+        authGroup.GET("/history", handlers.HistoryHandler(st, &config))
+        // 以 JSON、CSV 或 iCalendar 格式导出全部发射记录，用于备份、表格分析和迁移到其他服务器
+        authGroup.GET("/export", handlers.ExportHandler(st, &config))
+        // 导入 JSON 或 CSV 导出文件，按 add、replace 或 max 方式与已有数据合并，支持 dry-run 预览
+        authGroup.POST("/import", handlers.ImportHandler(st, &config))
+        // 修改统计发射数据所用的时区，可选择是否按新时区重新统计历史数据
+        authGroup.PUT("/user/timezone", handlers.UpdateTimezoneHandler(st, &config))
+        // 签发一次性的 WebSocket 连接票据，避免访问令牌出现在 /ws 的 URL 中
+        authGroup.POST("/ws/ticket", handlers.WSTicketHandler(wsTickets, &config))
     }
     
     // WebSocket 单独处理，不使用认证中间件
     // 注册 WebSocket 连接的 GET 请求路由，调用对应的处理函数处理 WebSocket 连接请求。
-    router.GET("/ws", handlers.WebSocketHandler(db, &config))
-
-	// 添加调试路由
-	// 注册一个调试用的 POST 请求路由，用于验证 JWT 令牌的有效性。
-	router.POST("/debug/validate-token", func(c *gin.Context) {
-		// 定义请求结构体，用于解析 JSON 请求体中的 Token 字段。
-		var req struct {
-			Token string `json:"token"`
-		}
-		
-		// 尝试将请求体绑定到 req 结构体，如果失败则返回错误响应。
-		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "无效请求"})
-			return
-		}
+    router.GET("/ws", handlers.WebSocketHandler(st, &config, wsTickets, tokenManager))
 
-		// 使用 handlers 包中的 ParseJWTToken 函数解析 JWT 令牌
-		claims, err := handlers.ParseJWTToken(req.Token, config.JWTSecretKey, &config)
-		if err != nil {
-			// 解析失败，返回验证结果为 false 及错误信息
-			c.JSON(http.StatusOK, gin.H{
-				"valid": false,
-				"error": err.Error(),
-				"secret_key": config.JWTSecretKey,
-				"secret_key_hash": fmt.Sprintf("%x", sha256.Sum256([]byte(config.JWTSecretKey))),
-			})
-			return
-		}
-		
-		// 解析成功，返回验证结果为 true 及令牌声明信息
-		c.JSON(http.StatusOK, gin.H{
-			"valid": true,
-			"claims": claims,
-			"secret_key": config.JWTSecretKey,
-			"secret_key_hash": fmt.Sprintf("%x", sha256.Sum256([]byte(config.JWTSecretKey))),
-		})
-	})
+	// 诊断接口默认关闭，只有显式启用时才注册
+	if config.DebugEnabled {
+		handlers.RegisterDebugRoutes(router, st, &config, tokenManager)
+		log.Println("诊断接口已启用: /debug（仅限本机或携带管理员令牌访问）")
+	}
 
 	// 启动服务器
-	// 打印服务器启动信息，指定监听端口，并启动 HTTP 服务器，若启动失败则记录错误信息。
+	// 打印服务器启动信息，指定监听端口，在新的 goroutine 中启动 HTTP 服务器，若启动失败则记录错误信息并退出。
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%d", config.ServerPort),
+		Handler: router,
+	}
 	log.Printf("服务器启动，监听端口 %d", config.ServerPort)
-	log.Fatal(router.Run(fmt.Sprintf(":%d", config.ServerPort)))
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("服务器启动失败: %v", err)
+		}
+	}()
+
+	// 等待 SIGINT/SIGTERM 或命令行的 exit 命令
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	select {
+	case <-ctx.Done():
+		log.Println("收到停止信号，开始停止服务")
+	case <-cliExit:
+	}
+	// 恢复默认的信号处理，停止过程中再次按下 Ctrl+C 可以立即退出
+	stop()
+
+	shutdown(srv, config.ShutdownTimeout())
 }
 
-// initDB 函数用于初始化数据库连接，验证连接有效性，并创建必要的数据库表。
-func initDB() {
-	// 构建 MySQL 数据库连接的数据源名称（DSN）
-	// 格式为 username:password@tcp(host:port)/database_name?parseTime=true
-	// parseTime=true 表示将数据库中的时间类型自动解析为 Go 的 time.Time 类型
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true",
-		config.DBUser, config.DBPassword, config.DBHost, config.DBPort, config.DBName)
+// shutdown 函数用于优雅地停止服务：不再接受新连接并等待进行中的请求完成，
+// 向所有 WebSocket 客户端发送 going away 关闭帧并等待它们断开，最后关闭数据库连接池。
+// 参数 srv 是正在运行的 HTTP 服务器。
+// 参数 timeout 是等待请求完成和客户端断开的最长时间，超时后剩余的连接会被直接放弃。
+func shutdown(srv *http.Server, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Shutdown 不会等待已被劫持的 WebSocket 连接，它们在下面单独处理
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("等待 HTTP 请求完成超时: %v", err)
+	}
+
+	// 通知客户端服务即将停止，客户端收到 1001 后可以稍后重连，而不是把断开当作异常
+	if n := models.Hub.CloseAll(websocket.CloseGoingAway, "server shutting down"); n > 0 {
+		log.Printf("正在断开 %d 个 WebSocket 客户端", n)
+	}
+	if err := models.Hub.Wait(ctx); err != nil {
+		log.Printf("等待 WebSocket 客户端断开超时: %v", err)
+	}
+
+	// 请求和连接都已结束，此时关闭数据库不会影响正在执行的写入
+	if err := st.Close(); err != nil {
+		log.Printf("关闭数据库失败: %v", err)
+	}
+	log.Println("服务已停止")
+}
 
+// initDB 函数用于根据配置打开存储后端（MySQL、SQLite 或内存），
+// 验证连接有效性，并执行尚未执行的数据库迁移。
+func initDB() {
 	var err error
-	// 使用 sql.Open 函数创建一个数据库连接池，该函数不会立即建立实际的数据库连接，
-	// 而是初始化一个连接池，后续可复用连接以提高性能。
-	db, err = sql.Open("mysql", dsn)
+	// store.Open 根据 db_driver 选择存储后端，MySQL 和 SQLite 会在打开时建立连接
+	st, err = store.Open(&config)
 	if err != nil {
-		// 若创建连接池失败，打印错误信息并使用 log.Fatalf 终止程序，
-		// 因为数据库连接失败会导致程序无法正常工作。
-		log.Fatalf("数据库连接失败: %v", err)
+		// 存储后端不可用时程序无法正常工作，直接终止
+		log.Fatalf("初始化存储失败: %v", err)
 	}
 
-	// 使用 db.Ping 方法尝试与数据库建立实际连接，验证连接池是否能正常连接到数据库。
-	// 该操作会发送一个简单的请求到数据库服务器，若成功则表示连接有效。
-	if err := db.Ping(); err != nil {
-		// 若连接测试失败，打印错误信息并使用 log.Fatalf 终止程序，
-		// 确保程序不会在无法连接数据库的情况下继续运行。
-		log.Fatalf("数据库连接测试失败: %v", err)
+	// 将数据库结构升级到最新版本，已执行过的迁移会被跳过
+	if _, err := st.MigrateUp(0); err != nil {
+		log.Fatalf("数据库迁移失败: %v", err)
 	}
 
-	// 调用 handlers 包中的 CreateTables 函数，在数据库中创建程序运行所需的表。
-	handlers.CreateTables(db)
-}
\ No newline at end of file
+	// 为引入事件日志之前的历史数据补录发射事件，已补录过的用户会被跳过
+	// 补录失败时不能继续启动，否则该用户之后的同步会用不完整的事件日志覆盖其历史数据
+	if _, err := st.BackfillLaunchEvents(); err != nil {
+		log.Fatalf("补录发射事件失败: %v", err)
+	}
+}
@@ -0,0 +1,345 @@
+package models
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultConfigPath 是未指定 --config 和 LC_CONFIG 时读取的配置文件路径。
+const DefaultConfigPath = "config/config.json"
+
+// EnvPrefix 是覆盖配置项的环境变量前缀，环境变量名为前缀加上大写的 JSON 键名，如 LC_SERVER_PORT。
+const EnvPrefix = "LC_"
+
+// DefaultConfig 返回所有配置项的默认值。
+// 未在这里列出的配置项以零值表示“使用默认值”，由 Config 上对应的方法解释。
+func DefaultConfig() Config {
+	return Config{
+		ServerPort: 12345,
+		DBDriver:   "mysql",
+		DBPath:     "data/launch_counter.db",
+		DBHost:     "localhost",
+		DBPort:     3306,
+		Env:        "release",
+		// 引入用户时区之前服务端固定使用该时区，保留为默认值使已有数据的统计结果不变
+		DefaultTimezone: "Asia/Shanghai",
+	}
+}
+
+// LoadConfig 按以下顺序合并配置，后面的来源覆盖前面的来源：
+//  1. DefaultConfig 中的默认值；
+//  2. 配置文件，路径由 --config 参数、LC_CONFIG 环境变量或 DefaultConfigPath 给出；
+//  3. LC_ 开头的环境变量，如 LC_DB_PASSWORD；
+//  4. 命令行参数，如 --db-password，参数名为 JSON 键名中的下划线换成连字符。
+//
+// 只有显式指定的配置文件不存在时才报错，默认路径下没有配置文件时只使用环境变量和命令行参数，
+// 便于在只读文件系统的容器中运行。配置文件不会被改写，除非传入 --generate-jwt-key
+// 且尚未配置 jwt_secret_key，此时生成新密钥并只写入该键。
+// 参数 args 是不含程序名的命令行参数。输入 -h 时返回 flag.ErrHelp。
+func LoadConfig(args []string) (Config, error) {
+	config := DefaultConfig()
+	fields := configFields()
+
+	fs := flag.NewFlagSet("launchcounter", flag.ContinueOnError)
+	configPath := fs.String("config", "", "配置文件路径（环境变量 LC_CONFIG，默认 "+DefaultConfigPath+"）")
+	generateKey := fs.Bool("generate-jwt-key", false, "未配置 jwt_secret_key 时生成一个并写入配置文件")
+	// 命令行参数要在配置文件和环境变量之后生效，这里只记录下来
+	var overrides []configOverride
+	for _, field := range fields {
+		field := field
+		record := func(raw string) error {
+			overrides = append(overrides, configOverride{field, raw})
+			return nil
+		}
+		usage := fmt.Sprintf("覆盖配置项 %s（环境变量 %s）", field.key, field.env())
+		if field.kind() == reflect.Bool {
+			fs.BoolFunc(field.flag(), usage, record)
+		} else {
+			fs.Func(field.flag(), usage, record)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return config, err
+	}
+	if fs.NArg() > 0 {
+		return config, fmt.Errorf("无法识别的参数: %s", strings.Join(fs.Args(), " "))
+	}
+
+	path, explicit := *configPath, true
+	if path == "" {
+		path, explicit = os.Getenv(EnvPrefix+"CONFIG"), true
+	}
+	if path == "" {
+		path, explicit = DefaultConfigPath, false
+	}
+	if err := loadConfigFile(path, &config); err != nil {
+		if !errors.Is(err, os.ErrNotExist) || explicit {
+			return config, err
+		}
+		log.Printf("未找到配置文件 %s，使用默认值、环境变量和命令行参数", path)
+	}
+
+	for _, field := range fields {
+		if raw, ok := os.LookupEnv(field.env()); ok {
+			if err := field.set(&config, raw); err != nil {
+				return config, fmt.Errorf("环境变量 %s: %v", field.env(), err)
+			}
+		}
+	}
+	for _, o := range overrides {
+		if err := o.field.set(&config, o.raw); err != nil {
+			return config, fmt.Errorf("参数 --%s: %v", o.field.flag(), err)
+		}
+	}
+	config.JWTSecretKey = strings.TrimSpace(config.JWTSecretKey)
+
+	if *generateKey {
+		if config.JWTSecretKey != "" {
+			log.Println("已配置 jwt_secret_key，跳过生成")
+		} else {
+			key, err := generateSecretKey()
+			if err != nil {
+				return config, err
+			}
+			if err := writeConfigKey(path, "jwt_secret_key", key); err != nil {
+				return config, fmt.Errorf("写入 JWT 密钥失败: %v", err)
+			}
+			config.JWTSecretKey = key
+			log.Printf("已生成 JWT 密钥并写入 %s", path)
+		}
+	}
+
+	if err := config.Validate(); err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+// loadConfigFile 把配置文件中的内容合并到 config 中，文件中没有的键保持原值。
+// 无法解析的文件、类型不符的值和未知的键都会返回错误，避免拼写错误的配置项被静默忽略。
+func loadConfigFile(path string, config *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("读取配置文件失败: %w", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(config); err != nil {
+		return fmt.Errorf("解析配置文件 %s 失败: %v", path, err)
+	}
+	return nil
+}
+
+// generateSecretKey 生成 32 字节的随机密钥，以 Base64 编码返回。
+func generateSecretKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("无法生成JWT密钥: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// writeConfigKey 只修改配置文件中的一个键，其余内容原样保留；文件不存在时创建只含该键的文件。
+// 环境变量和命令行参数中的值不会因此被写入文件；写回后各键按字母顺序排列。
+func writeConfigKey(path, key, value string) error {
+	entries := make(map[string]json.RawMessage)
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	entries[key] = encoded
+	data, err = json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	// 配置文件中保存着密钥，新建时只允许所有者读写
+	return os.WriteFile(path, append(data, '\n'), 0o600)
+}
+
+// Validate 检查配置项的取值，返回所有不合法的配置项。
+func (c *Config) Validate() error {
+	var errs []error
+	invalid := func(key, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+	}
+
+	if c.ServerPort < 1 || c.ServerPort > 65535 {
+		invalid("server_port", "端口 %d 超出范围", c.ServerPort)
+	}
+	switch c.Env {
+	case "dev", "release":
+	default:
+		invalid("env", "应为 dev 或 release，实际为 %q", c.Env)
+	}
+
+	switch c.DBDriver {
+	case "", "mysql":
+		if c.DBHost == "" {
+			invalid("db_host", "使用 MySQL 时不能为空")
+		}
+		if c.DBPort < 1 || c.DBPort > 65535 {
+			invalid("db_port", "端口 %d 超出范围", c.DBPort)
+		}
+		if c.DBUser == "" {
+			invalid("db_user", "使用 MySQL 时不能为空")
+		}
+		if c.DBName == "" {
+			invalid("db_name", "使用 MySQL 时不能为空")
+		}
+	case "sqlite":
+		if c.DBPath == "" {
+			invalid("db_path", "使用 SQLite 时不能为空")
+		}
+	case "memory":
+	default:
+		invalid("db_driver", "应为 mysql、sqlite 或 memory，实际为 %q", c.DBDriver)
+	}
+
+	// 以下配置项为 0 时使用默认值，负数没有意义
+	for key, value := range map[string]int64{
+		"max_clock_skew_seconds":   int64(c.MaxClockSkewSeconds),
+		"access_token_ttl_minutes": int64(c.AccessTokenTTLMinutes),
+		"refresh_token_ttl_days":   int64(c.RefreshTokenTTLDays),
+		"login_lockout_minutes":    int64(c.LoginLockoutMinutes),
+		"ws_ping_interval_seconds": int64(c.WSPingIntervalSeconds),
+		"ws_pong_timeout_seconds":  int64(c.WSPongTimeoutSeconds),
+		"ws_write_timeout_seconds": int64(c.WSWriteTimeoutSeconds),
+		"ws_max_message_bytes":     c.WSMaxMessageBytes,
+		"ws_replay_buffer_size":    int64(c.WSReplayBufferSize),
+		"ws_ticket_ttl_seconds":    int64(c.WSTicketTTLSeconds),
+		"shutdown_timeout_seconds": int64(c.ShutdownTimeoutSeconds),
+	} {
+		if value < 0 {
+			invalid(key, "不能为负数")
+		}
+	}
+
+	for _, origin := range c.WSAllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" {
+			invalid("ws_allowed_origins", "%q 不是合法的来源，应形如 https://app.example.com", origin)
+		}
+	}
+
+	if c.DefaultTimezone != "" {
+		if _, err := time.LoadLocation(c.DefaultTimezone); err != nil {
+			invalid("default_timezone", "%q 不是合法的 IANA 时区", c.DefaultTimezone)
+		}
+	}
+
+	// 与 tokens 包支持的算法保持一致
+	switch c.JWTAlgorithm {
+	case "", "HS256", "EdDSA", "RS256":
+	default:
+		invalid("jwt_algorithm", "应为 HS256、EdDSA 或 RS256，实际为 %q", c.JWTAlgorithm)
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	// 按键名排序，使错误信息的顺序稳定
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return fmt.Errorf("配置无效:\n%w", errors.Join(errs...))
+}
+
+// configField 是 Config 中一个可以通过环境变量和命令行参数设置的字段。
+type configField struct {
+	// key 是字段的 JSON 键名
+	key   string
+	index int
+	typ   reflect.Type
+}
+
+// configOverride 是一个待应用的命令行参数。
+type configOverride struct {
+	field configField
+	raw   string
+}
+
+// configFields 返回 Config 中所有带 JSON 键名的字段。
+func configFields() []configField {
+	t := reflect.TypeOf(Config{})
+	fields := make([]configField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		key, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if key == "" || key == "-" {
+			continue
+		}
+		fields = append(fields, configField{key: key, index: i, typ: t.Field(i).Type})
+	}
+	return fields
+}
+
+// env 返回覆盖该字段的环境变量名。
+func (f configField) env() string {
+	return EnvPrefix + strings.ToUpper(f.key)
+}
+
+// flag 返回覆盖该字段的命令行参数名。
+func (f configField) flag() string {
+	return strings.ReplaceAll(f.key, "_", "-")
+}
+
+func (f configField) kind() reflect.Kind {
+	return f.typ.Kind()
+}
+
+// set 把字符串形式的取值解析后写入 config。列表以逗号分隔，空字符串表示空列表。
+func (f configField) set(config *Config, raw string) error {
+	v := reflect.ValueOf(config).Elem().Field(f.index)
+	switch f.kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+		if err != nil || v.OverflowInt(n) {
+			return fmt.Errorf("%q 不是合法的整数", raw)
+		}
+		v.SetInt(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("%q 不是合法的布尔值", raw)
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		items := []string{}
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("不支持的配置类型 %s", f.typ)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package models
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// MinUsernameLength 和 MaxUsernameLength 是用户名允许的字符数范围。
+	MinUsernameLength = 3
+	MaxUsernameLength = 32
+	// MinPasswordLength 是密码的最小字符数。
+	MinPasswordLength = 8
+	// MaxPasswordBytes 是密码的最大字节数，bcrypt 只使用前 72 个字节，超出部分会被静默忽略。
+	MaxPasswordBytes = 72
+)
+
+// ValidateCredentials 按注册策略校验用户名和密码，校验通过时返回 nil。
+func ValidateCredentials(username, password string) ValidationErrors {
+	var errs ValidationErrors
+	validateUsername(username, &errs)
+	errs = append(errs, ValidatePassword(username, password)...)
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// ValidatePassword 按密码策略校验密码，用于注册和修改密码。
+// 密码长度为 8 到 72 字节，至少包含字母、数字、其他字符中的两类，且不能与用户名相同。
+func ValidatePassword(username, password string) ValidationErrors {
+	var errs ValidationErrors
+
+	if utf8.RuneCountInString(password) < MinPasswordLength {
+		errs.add("password", "长度不能少于 %d 个字符", MinPasswordLength)
+	}
+	if len(password) > MaxPasswordBytes {
+		errs.add("password", "长度不能超过 %d 个字节", MaxPasswordBytes)
+	}
+
+	var letter, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLetter(r):
+			letter = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	classes := 0
+	for _, ok := range []bool{letter, digit, other} {
+		if ok {
+			classes++
+		}
+	}
+	if classes < 2 {
+		errs.add("password", "至少需要包含字母、数字、符号中的两类")
+	}
+	if username != "" && strings.EqualFold(password, username) {
+		errs.add("password", "不能与用户名相同")
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// validateUsername 校验用户名：3 到 32 个字符，只能包含字母（含中文）、数字、下划线、连字符和点，
+// 且不能以符号开头或结尾，避免与看起来相同的用户名混淆。
+func validateUsername(username string, errs *ValidationErrors) {
+	n := utf8.RuneCountInString(username)
+	if n < MinUsernameLength || n > MaxUsernameLength {
+		errs.add("username", "长度必须在 %d 到 %d 个字符之间", MinUsernameLength, MaxUsernameLength)
+	}
+	for _, r := range username {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '-' && r != '.' {
+			errs.add("username", "只能包含字母、数字、下划线、连字符和点")
+			break
+		}
+	}
+	if strings.Trim(username, "_-.") != username {
+		errs.add("username", "不能以符号开头或结尾")
+	}
+}
@@ -0,0 +1,188 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LaunchEvent 表示一次独立的发射记录。
+// launch_events 表是发射数据的唯一数据来源，LaunchData 中的总数和年/月/日统计都由事件推导而来。
+type LaunchEvent struct {
+	UserID     int       `json:"user_id"`
+	EventID    string    `json:"event_id"`    // 客户端生成的事件 ID，同一用户下唯一，用于去重
+	Device     string    `json:"device"`      // 产生该事件的设备标识，可为空
+	LaunchedAt time.Time `json:"launched_at"` // 发射时间
+	// 统计该事件使用的 IANA 时区，为空表示跟随用户的时区。
+	// 用户修改时区但不重新统计历史数据时，已有事件会记录修改前的时区，统计结果保持不变
+	Timezone string `json:"timezone,omitempty"`
+}
+
+// YearKey、MonthKey 和 DayKey 生成与客户端一致的统计键，
+// 格式分别为 "2024"、"2024-3" 和 "2024-3-5"（月份和日期不补零）。
+func YearKey(t time.Time) string {
+	return strconv.Itoa(t.Year())
+}
+
+func MonthKey(t time.Time) string {
+	return fmt.Sprintf("%d-%d", t.Year(), int(t.Month()))
+}
+
+func DayKey(t time.Time) string {
+	return fmt.Sprintf("%d-%d-%d", t.Year(), int(t.Month()), t.Day())
+}
+
+// ParseDayKey 将 "2024-3-5" 格式的日统计键解析为指定时区下当天零点的时间。
+func ParseDayKey(key string, loc *time.Location) (time.Time, error) {
+	parts := strings.Split(key, "-")
+	if len(parts) != 3 {
+		return time.Time{}, fmt.Errorf("无效的日期键: %s", key)
+	}
+	nums := make([]int, 3)
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("无效的日期键: %s", key)
+		}
+		nums[i] = n
+	}
+	t := time.Date(nums[0], time.Month(nums[1]), nums[2], 0, 0, 0, 0, loc)
+	// time.Date 会自动进位（如 2 月 30 日变为 3 月 2 日），这里要求键本身就是合法日期
+	if t.Year() != nums[0] || int(t.Month()) != nums[1] || t.Day() != nums[2] {
+		return time.Time{}, fmt.Errorf("无效的日期键: %s", key)
+	}
+	return t, nil
+}
+
+// NewEventID 生成一个随机的事件 ID，用于服务端代替客户端创建事件的场景。
+func NewEventID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// 随机数生成失败时退化为基于时间的 ID，仍能满足同一用户下的唯一性要求
+		return fmt.Sprintf("srv-%d", time.Now().UnixNano())
+	}
+	return "srv-" + hex.EncodeToString(b)
+}
+
+// AggregateEvents 根据发射事件列表计算用户的聚合发射数据。
+// 参数 loc 是用户的时区，没有记录时区的事件按它划分年/月/日，记录了时区的事件按事件自己的时区划分。
+func AggregateEvents(userID int, events []LaunchEvent, loc *time.Location) LaunchData {
+	data := LaunchData{
+		UserID:    userID,
+		YearData:  make(map[string]int),
+		MonthData: make(map[string]int),
+		DayData:   make(map[string]int),
+		Timezone:  loc.String(),
+	}
+	for _, e := range events {
+		t := e.LaunchedAt.In(e.Location(loc))
+		data.Total++
+		data.YearData[YearKey(t)]++
+		data.MonthData[MonthKey(t)]++
+		data.DayData[DayKey(t)]++
+		if t.After(data.LastLaunch) {
+			data.LastLaunch = e.LaunchedAt.In(loc)
+		}
+	}
+	return data
+}
+
+// EventsFromSnapshot 将客户端提交的完整快照转换为发射事件。
+// 只有快照中某天的次数多于服务端当前记录时，才会为差值部分创建新事件；
+// 次数减少的日期会被忽略，因为事件日志只允许追加。
+// 新事件的时间取快照中的最后发射时间（若恰好在当天），否则取当天中午。
+func EventsFromSnapshot(current, incoming LaunchData, device string, loc *time.Location) []LaunchEvent {
+	days := make([]string, 0, len(incoming.DayData))
+	for day := range incoming.DayData {
+		days = append(days, day)
+	}
+	sort.Strings(days)
+
+	lastLaunch := incoming.LastLaunch.In(loc)
+	var events []LaunchEvent
+	for _, day := range days {
+		diff := incoming.DayData[day] - current.DayData[day]
+		if diff <= 0 {
+			continue
+		}
+		start, err := ParseDayKey(day, loc)
+		if err != nil {
+			continue
+		}
+		at := start.Add(12 * time.Hour)
+		if DayKey(lastLaunch) == DayKey(start) {
+			at = lastLaunch
+		}
+		for i := 0; i < diff; i++ {
+			events = append(events, LaunchEvent{
+				UserID:     current.UserID,
+				EventID:    NewEventID(),
+				Device:     device,
+				LaunchedAt: at,
+			})
+		}
+	}
+	return events
+}
+
+// BackfillEvents 为只有聚合数据、没有事件记录的历史用户生成等价的发射事件。
+// 事件 ID 是确定性的，重复执行不会产生重复事件。生成的事件数量总是等于总数，以保证用户的总数不变：
+// 若总数大于各日统计之和，多出的部分记录在最后发射时间上；
+// 若各日统计之和大于总数（旧客户端的数据不一致），丢弃最早的多余事件并记录日志。
+func BackfillEvents(data LaunchData, loc *time.Location) []LaunchEvent {
+	type backfillDay struct {
+		key   string
+		start time.Time
+	}
+	days := make([]backfillDay, 0, len(data.DayData))
+	for key := range data.DayData {
+		start, err := ParseDayKey(key, loc)
+		if err != nil {
+			continue
+		}
+		days = append(days, backfillDay{key: key, start: start})
+	}
+	// 键不补零，按字符串排序不是时间顺序
+	sort.Slice(days, func(i, j int) bool { return days[i].start.Before(days[j].start) })
+
+	lastLaunch := data.LastLaunch.In(loc)
+	var events []LaunchEvent
+	for _, day := range days {
+		at := day.start.Add(12 * time.Hour)
+		if DayKey(lastLaunch) == DayKey(day.start) {
+			at = lastLaunch
+		}
+		for i := 0; i < data.DayData[day.key]; i++ {
+			events = append(events, LaunchEvent{
+				UserID:     data.UserID,
+				EventID:    fmt.Sprintf("backfill-%s-%d", day.key, i),
+				Device:     "backfill",
+				LaunchedAt: at,
+			})
+		}
+	}
+	if surplus := len(events) - data.Total; surplus > 0 {
+		log.Printf("用户 %d 的日统计之和 %d 大于总数 %d，补录时丢弃最早的 %d 条事件", data.UserID, len(events), data.Total, surplus)
+		events = events[surplus:]
+	}
+	extraAt := data.LastLaunch
+	if extraAt.IsZero() && len(events) > 0 {
+		extraAt = events[len(events)-1].LaunchedAt
+	} else if extraAt.IsZero() {
+		extraAt = time.Now()
+	}
+	for i := len(events); i < data.Total; i++ {
+		events = append(events, LaunchEvent{
+			UserID:     data.UserID,
+			EventID:    fmt.Sprintf("backfill-extra-%d", i),
+			Device:     "backfill",
+			LaunchedAt: extraAt,
+		})
+	}
+	return events
+}
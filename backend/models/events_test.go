@@ -0,0 +1,53 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackfillEventsKeepsTotal(t *testing.T) {
+	loc := time.FixedZone("UTC+8", 8*3600)
+	tests := []struct {
+		name  string
+		data  LaunchData
+		first string
+	}{
+		{
+			name:  "日统计之和等于总数",
+			data:  LaunchData{Total: 3, DayData: map[string]int{"2024-10-1": 1, "2024-9-30": 2}},
+			first: "2024-9-30",
+		},
+		{
+			name:  "总数大于日统计之和",
+			data:  LaunchData{Total: 5, DayData: map[string]int{"2024-10-1": 1, "2024-9-30": 2}},
+			first: "2024-9-30",
+		},
+		{
+			// 键不补零时按字符串排序 "2024-10-1" 会排在 "2024-9-30" 之前，应丢弃的是更早的 9 月 30 日
+			name:  "日统计之和大于总数时丢弃最早的事件",
+			data:  LaunchData{Total: 2, DayData: map[string]int{"2024-10-1": 1, "2024-9-30": 2}},
+			first: "2024-9-30",
+		},
+		{
+			name:  "丢弃整天的事件",
+			data:  LaunchData{Total: 1, DayData: map[string]int{"2024-10-1": 1, "2024-9-30": 2}},
+			first: "2024-10-1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := BackfillEvents(tt.data, loc)
+			if len(events) != tt.data.Total {
+				t.Fatalf("生成 %d 个事件，期望与总数 %d 相同", len(events), tt.data.Total)
+			}
+			if got := DayKey(events[0].LaunchedAt.In(loc)); got != tt.first {
+				t.Errorf("第一个事件在 %s，期望 %s", got, tt.first)
+			}
+			for i := 1; i < len(events); i++ {
+				if events[i].LaunchedAt.Before(events[i-1].LaunchedAt) {
+					t.Errorf("事件没有按时间排序: %v 在 %v 之后", events[i].LaunchedAt, events[i-1].LaunchedAt)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// 历史数据查询支持的时间粒度。
+const (
+	GranularityHour  = "hour"
+	GranularityDay   = "day"
+	GranularityWeek  = "week"
+	GranularityMonth = "month"
+	GranularityYear  = "year"
+)
+
+// MaxHistoryPoints 是单次历史查询最多返回的时间点数量，超过时需要缩小范围或使用更粗的粒度。
+const MaxHistoryPoints = 2000
+
+// ErrHistoryTooLarge 表示查询范围在指定粒度下的时间点数量超过 MaxHistoryPoints。
+var ErrHistoryTooLarge = fmt.Errorf("查询范围过大，单次最多返回 %d 个时间点", MaxHistoryPoints)
+
+// HistoryPoint 是时间序列中的一个时间段。
+// Key 与 day_data 等统计键的格式一致：小时为 "2024-3-5 14:00"，周为 ISO 周 "2024-W10"。
+type HistoryPoint struct {
+	Key   string    `json:"key"`
+	Start time.Time `json:"start"`
+	Count int       `json:"count"`
+}
+
+// History 是按粒度划分、没有发射的时间段补零的连续时间序列。
+// From 是第一个时间段的开始，To 是最后一个时间段的结束（不含）。
+type History struct {
+	Timezone    string         `json:"timezone"`
+	Granularity string         `json:"granularity"`
+	From        time.Time      `json:"from"`
+	To          time.Time      `json:"to"`
+	Total       int            `json:"total"`
+	Points      []HistoryPoint `json:"points"`
+}
+
+// ValidGranularity 判断 g 是否是支持的时间粒度。
+func ValidGranularity(g string) bool {
+	switch g {
+	case GranularityHour, GranularityDay, GranularityWeek, GranularityMonth, GranularityYear:
+		return true
+	}
+	return false
+}
+
+// DefaultHistoryFrom 返回未指定起点时的默认起点：小时粒度为最近 24 小时，日为 30 天，
+// 周为 12 周，月为 12 个月，年为 5 年，均包含 to 所在的时间段。
+func DefaultHistoryFrom(to time.Time, granularity string) time.Time {
+	switch granularity {
+	case GranularityHour:
+		return to.Add(-23 * time.Hour)
+	case GranularityWeek:
+		return to.AddDate(0, 0, -7*11)
+	case GranularityMonth:
+		return to.AddDate(0, -11, 0)
+	case GranularityYear:
+		return to.AddDate(-4, 0, 0)
+	default:
+		return to.AddDate(0, 0, -29)
+	}
+}
+
+// NewHistory 创建覆盖 [from, to] 的时间序列，时间段按 loc 划分，各时间段的次数均为 0。
+// 时间段数量超过 MaxHistoryPoints 时返回 ErrHistoryTooLarge。
+func NewHistory(from, to time.Time, granularity string, loc *time.Location) (History, error) {
+	if !ValidGranularity(granularity) {
+		return History{}, fmt.Errorf("无效的时间粒度: %s", granularity)
+	}
+	if to.Before(from) {
+		return History{}, errors.New("结束时间不能早于开始时间")
+	}
+	history := History{Timezone: loc.String(), Granularity: granularity, Points: []HistoryPoint{}}
+	start := bucketStart(from.In(loc), granularity)
+	history.From = start
+	for ; !start.After(to); start = nextBucket(start, granularity) {
+		if len(history.Points) == MaxHistoryPoints {
+			return History{}, ErrHistoryTooLarge
+		}
+		history.Points = append(history.Points, HistoryPoint{Key: historyKey(start, granularity), Start: start})
+	}
+	history.To = start
+	return history, nil
+}
+
+// Add 将发射事件计入对应的时间段，时间序列范围之外的事件被忽略。
+// 与 day_data 一样，每个事件按其自身时区的日期和时刻归入时间段，loc 是用户的时区。
+func (h *History) Add(events []LaunchEvent, loc *time.Location) {
+	index := make(map[string]int, len(h.Points))
+	for i, p := range h.Points {
+		index[p.Key] = i
+	}
+	for _, e := range events {
+		t := e.LaunchedAt.In(e.Location(loc))
+		// 按事件时区下的日期和时刻换算到用户时区中同名的时间段
+		wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc)
+		if i, ok := index[historyKey(bucketStart(wall, h.Granularity), h.Granularity)]; ok {
+			h.Points[i].Count++
+			h.Total++
+		}
+	}
+}
+
+// bucketStart 返回 t 所在时间段的开始时间，按 t 自身的时区计算，周从星期一开始。
+func bucketStart(t time.Time, granularity string) time.Time {
+	y, m, d := t.Date()
+	switch granularity {
+	case GranularityHour:
+		return time.Date(y, m, d, t.Hour(), 0, 0, 0, t.Location())
+	case GranularityWeek:
+		offset := (int(t.Weekday()) + 6) % 7
+		return time.Date(y, m, d-offset, 0, 0, 0, 0, t.Location())
+	case GranularityMonth:
+		return time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+	case GranularityYear:
+		return time.Date(y, 1, 1, 0, 0, 0, 0, t.Location())
+	default:
+		return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	}
+}
+
+// nextBucket 返回下一个时间段的开始时间。按日历字段推进，夏令时切换的日子仍然只占一个时间段。
+func nextBucket(start time.Time, granularity string) time.Time {
+	y, m, d := start.Date()
+	switch granularity {
+	case GranularityHour:
+		next := time.Date(y, m, d, start.Hour()+1, 0, 0, 0, start.Location())
+		// 夏令时切换时按日历字段计算的结果可能不递增，此时直接按绝对时间推进
+		if !next.After(start) {
+			next = start.Add(time.Hour)
+		}
+		return next
+	case GranularityWeek:
+		return time.Date(y, m, d+7, 0, 0, 0, 0, start.Location())
+	case GranularityMonth:
+		return time.Date(y, m+1, 1, 0, 0, 0, 0, start.Location())
+	case GranularityYear:
+		return time.Date(y+1, 1, 1, 0, 0, 0, 0, start.Location())
+	default:
+		return time.Date(y, m, d+1, 0, 0, 0, 0, start.Location())
+	}
+}
+
+// historyKey 返回时间段的键。
+func historyKey(start time.Time, granularity string) string {
+	switch granularity {
+	case GranularityHour:
+		return fmt.Sprintf("%s %02d:00", DayKey(start), start.Hour())
+	case GranularityWeek:
+		year, week := start.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	case GranularityMonth:
+		return MonthKey(start)
+	case GranularityYear:
+		return YearKey(start)
+	default:
+		return DayKey(start)
+	}
+}
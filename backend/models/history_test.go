@@ -0,0 +1,166 @@
+package models
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// historyKeys 返回时间序列中全部时间段的键。
+func historyKeys(h History) []string {
+	keys := make([]string, 0, len(h.Points))
+	for _, p := range h.Points {
+		keys = append(keys, p.Key)
+	}
+	return keys
+}
+
+// historyCounts 返回次数不为 0 的时间段。
+func historyCounts(h History) map[string]int {
+	counts := make(map[string]int)
+	for _, p := range h.Points {
+		if p.Count > 0 {
+			counts[p.Key] = p.Count
+		}
+	}
+	return counts
+}
+
+func TestHistoryHourAcrossDST(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("加载时区失败: %v", err)
+	}
+	utc := func(month time.Month, day, hour, min int) LaunchEvent {
+		return LaunchEvent{LaunchedAt: time.Date(2024, month, day, hour, min, 0, 0, time.UTC)}
+	}
+
+	tests := []struct {
+		name   string
+		day    time.Time
+		points int
+		events []LaunchEvent
+		counts map[string]int
+	}{
+		{
+			// 2024-03-10 凌晨 2 点跳到 3 点，当天只有 23 个小时，没有 02:00 这个时间段
+			name:   "夏令时开始",
+			day:    time.Date(2024, 3, 10, 0, 0, 0, 0, ny),
+			points: 23,
+			// 01:30 EST 和 03:30 EDT
+			events: []LaunchEvent{utc(3, 10, 6, 30), utc(3, 10, 7, 30)},
+			counts: map[string]int{"2024-3-10 01:00": 1, "2024-3-10 03:00": 1},
+		},
+		{
+			// 2024-11-03 凌晨 2 点回到 1 点，两个 1 点按日历字段属于同一个时间段
+			name:   "夏令时结束",
+			day:    time.Date(2024, 11, 3, 0, 0, 0, 0, ny),
+			points: 24,
+			// 01:30 EDT、01:30 EST 和 02:30 EST
+			events: []LaunchEvent{utc(11, 3, 5, 30), utc(11, 3, 6, 30), utc(11, 3, 7, 30)},
+			counts: map[string]int{"2024-11-3 01:00": 2, "2024-11-3 02:00": 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			end := tt.day.AddDate(0, 0, 1).Add(-time.Nanosecond)
+			h, err := NewHistory(tt.day, end, GranularityHour, ny)
+			if err != nil {
+				t.Fatalf("创建时间序列失败: %v", err)
+			}
+			if len(h.Points) != tt.points {
+				t.Fatalf("共 %d 个时间段，期望 %d 个: %v", len(h.Points), tt.points, historyKeys(h))
+			}
+			seen := make(map[string]bool)
+			for i, p := range h.Points {
+				if seen[p.Key] {
+					t.Errorf("时间段 %s 重复", p.Key)
+				}
+				seen[p.Key] = true
+				if i > 0 && !p.Start.After(h.Points[i-1].Start) {
+					t.Errorf("时间段 %s 的开始时间没有递增", p.Key)
+				}
+			}
+			if !h.From.Equal(tt.day) || !h.To.Equal(tt.day.AddDate(0, 0, 1)) {
+				t.Errorf("范围 [%v, %v)，期望覆盖 %v 整天", h.From, h.To, tt.day)
+			}
+
+			h.Add(tt.events, ny)
+			if got := historyCounts(h); !reflect.DeepEqual(got, tt.counts) {
+				t.Errorf("各时间段次数 %v，期望 %v", got, tt.counts)
+			}
+			if h.Total != len(tt.events) {
+				t.Errorf("总数 %d，期望 %d", h.Total, len(tt.events))
+			}
+		})
+	}
+}
+
+func TestHistoryWeekAcrossYears(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to time.Time
+		keys     []string
+		event    time.Time
+		count    string
+	}{
+		{
+			// 2021-01-01 是星期五，属于 2020 年的第 53 周
+			name:  "1 月 1 日属于上一年的最后一周",
+			from:  time.Date(2020, 12, 25, 0, 0, 0, 0, time.UTC),
+			to:    time.Date(2021, 1, 10, 0, 0, 0, 0, time.UTC),
+			keys:  []string{"2020-W52", "2020-W53", "2021-W01"},
+			event: time.Date(2021, 1, 2, 12, 0, 0, 0, time.UTC),
+			count: "2020-W53",
+		},
+		{
+			// 2024-12-30 是星期一，包含 2025-01-01 的一周属于 2025 年的第 1 周
+			name:  "12 月 31 日属于下一年的第一周",
+			from:  time.Date(2024, 12, 20, 0, 0, 0, 0, time.UTC),
+			to:    time.Date(2025, 1, 5, 0, 0, 0, 0, time.UTC),
+			keys:  []string{"2024-W51", "2024-W52", "2025-W01"},
+			event: time.Date(2024, 12, 31, 12, 0, 0, 0, time.UTC),
+			count: "2025-W01",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := NewHistory(tt.from, tt.to, GranularityWeek, time.UTC)
+			if err != nil {
+				t.Fatalf("创建时间序列失败: %v", err)
+			}
+			if got := historyKeys(h); !reflect.DeepEqual(got, tt.keys) {
+				t.Fatalf("时间段 %v，期望 %v", got, tt.keys)
+			}
+			if h.Points[0].Start.Weekday() != time.Monday {
+				t.Errorf("周从 %v 开始，期望星期一", h.Points[0].Start.Weekday())
+			}
+			h.Add([]LaunchEvent{{LaunchedAt: tt.event}}, time.UTC)
+			if got := historyCounts(h); !reflect.DeepEqual(got, map[string]int{tt.count: 1}) {
+				t.Errorf("各时间段次数 %v，期望 %s 为 1", got, tt.count)
+			}
+		})
+	}
+}
+
+func TestNewHistoryLimit(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	last := from.Add((MaxHistoryPoints - 1) * time.Hour)
+
+	h, err := NewHistory(from, last, GranularityHour, time.UTC)
+	if err != nil || len(h.Points) != MaxHistoryPoints {
+		t.Fatalf("恰好 %d 个时间段时返回 %d 个, %v", MaxHistoryPoints, len(h.Points), err)
+	}
+	if _, err := NewHistory(from, last.Add(time.Hour), GranularityHour, time.UTC); !errors.Is(err, ErrHistoryTooLarge) {
+		t.Fatalf("超过 %d 个时间段时返回 %v，期望 ErrHistoryTooLarge", MaxHistoryPoints, err)
+	}
+	if _, err := NewHistory(last, from, GranularityHour, time.UTC); err == nil {
+		t.Fatal("结束时间早于开始时间时应当失败")
+	}
+	if _, err := NewHistory(from, last, "minute", time.UTC); err == nil {
+		t.Fatal("无效的粒度应当失败")
+	}
+}
@@ -0,0 +1,157 @@
+package models
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// SendQueueSize 是每个客户端发送队列的容量。
+const SendQueueSize = 256
+
+// Hub 是全局的在线客户端注册表，所有对在线连接的访问都经由它完成。
+var Hub = NewClientHub()
+
+// ClientHub 维护按用户划分的在线客户端列表。
+//
+// 连接的生命周期由各自的协程负责，注册表只负责登记：
+//   - 连接处理函数在升级成功后调用 Register，在 ReadPump 返回后调用 Unregister；
+//   - 任何地方需要断开连接时只调用 Client.Close，它是幂等的，且不会关闭 Send 通道，
+//     因此并发的推送不会因为向已关闭的通道发送而 panic；
+//   - 底层连接只由 WritePump 关闭，ReadPump 随之返回。
+type ClientHub struct {
+	mu      sync.RWMutex
+	clients map[int][]*Client
+}
+
+// NewClientHub 创建空的客户端注册表。
+func NewClientHub() *ClientHub {
+	return &ClientHub{clients: make(map[int][]*Client)}
+}
+
+// NewClient 为已升级的 WebSocket 连接创建客户端，协商的子协议取自连接本身。
+// 其余的用户信息由调用方在注册前填写。
+func NewClient(conn *websocket.Conn, settings WSSettings) *Client {
+	return &Client{
+		Conn:      conn,
+		ConnectAt: time.Now(),
+		Protocol:  conn.Subprotocol(),
+		Settings:  settings,
+		Send:      make(chan Envelope, SendQueueSize),
+		done:      make(chan struct{}),
+	}
+}
+
+// Register 登记一个在线客户端。
+func (h *ClientHub) Register(c *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.clients[c.UserID] = append(h.clients[c.UserID], c)
+}
+
+// Unregister 注销客户端并关闭它，客户端不在注册表中时返回 false。重复调用是安全的。
+func (h *ClientHub) Unregister(c *Client) bool {
+	c.Close()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	list := h.clients[c.UserID]
+	for i, existing := range list {
+		if existing == c {
+			// 复制一份新的切片，避免影响正在遍历旧切片的调用方
+			rest := make([]*Client, 0, len(list)-1)
+			rest = append(rest, list[:i]...)
+			rest = append(rest, list[i+1:]...)
+			if len(rest) == 0 {
+				delete(h.clients, c.UserID)
+			} else {
+				h.clients[c.UserID] = rest
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// ForUser 对指定用户的每个在线客户端调用 fn，期间持有读锁，fn 中不能调用 Register 或 Unregister。
+func (h *ClientHub) ForUser(userID int, fn func(c *Client)) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, c := range h.clients[userID] {
+		fn(c)
+	}
+}
+
+// UserClients 返回指定用户当前在线客户端的副本。
+func (h *ClientHub) UserClients(userID int) []*Client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return append([]*Client(nil), h.clients[userID]...)
+}
+
+// CloseAll 以指定的关闭帧关闭全部在线客户端，返回关闭的数量，用于服务停止前通知客户端。
+// 客户端随后由各自的连接处理函数注销，可以用 Wait 等待注销完成。
+func (h *ClientHub) CloseAll(code int, text string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	count := 0
+	for _, list := range h.clients {
+		for _, c := range list {
+			c.CloseWith(code, text)
+			count++
+		}
+	}
+	return count
+}
+
+// Wait 等待全部客户端注销，ctx 结束时返回其错误。
+func (h *ClientHub) Wait(ctx context.Context) error {
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		h.mu.RLock()
+		empty := len(h.clients) == 0
+		h.mu.RUnlock()
+		if empty {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Counts 返回每个在线用户的客户端数量。
+func (h *ClientHub) Counts() map[int]int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	counts := make(map[int]int, len(h.clients))
+	for userID, list := range h.clients {
+		counts[userID] = len(list)
+	}
+	return counts
+}
+
+// Close 关闭客户端：通知写协程发送正常关闭帧并断开连接，之后的 Enqueue 都会返回 false。
+// 可以在任意协程中重复调用，只有第一次生效。
+func (c *Client) Close() {
+	c.CloseWith(websocket.CloseNormalClosure, "")
+}
+
+// CloseWith 与 Close 相同，但由调用方指定关闭帧的状态码和原因。
+// 已经关闭的客户端不受影响，关闭帧以第一次调用为准。
+func (c *Client) CloseWith(code int, text string) {
+	c.closeOnce.Do(func() {
+		c.closeCode, c.closeText = code, text
+		close(c.done)
+	})
+}
+
+// Done 返回客户端关闭时被关闭的通道。
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
@@ -0,0 +1,251 @@
+package models
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// testSettings 使用较短的超时，保证测试中的连接能够及时断开。
+var testSettings = WSSettings{
+	PingInterval:   50 * time.Millisecond,
+	PongWait:       time.Second,
+	WriteWait:      time.Second,
+	MaxMessageSize: 4096,
+}
+
+// startHubServer 启动一个按 WebSocket 连接处理函数的方式使用 hub 的测试服务器。
+// 客户端通过 user 查询参数指定用户 ID；每个连接结束后 handlers 计数减一。
+func startHubServer(t *testing.T, hub *ClientHub, handlers *sync.WaitGroup) *httptest.Server {
+	t.Helper()
+	upgrader := websocket.Upgrader{Subprotocols: []string{ProtocolV2}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlers.Add(1)
+		defer handlers.Done()
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		client := NewClient(conn, testSettings)
+		client.UserID = len(r.URL.Query().Get("user"))
+		client.SessionID = r.URL.Query().Get("session")
+
+		hub.Register(client)
+		defer hub.Unregister(client)
+		go client.WritePump()
+		client.ReadPump(func(c *Client, env Envelope) {
+			c.Enqueue(NewEnvelope(MessagePong, env.ID, nil))
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// dial 连接测试服务器，失败时返回 nil。可以在测试协程之外的协程中调用。
+func dial(t *testing.T, srv *httptest.Server, query string) *websocket.Conn {
+	t.Helper()
+	dialer := websocket.Dialer{Subprotocols: []string{ProtocolV2}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/?"+query, nil)
+	if err != nil {
+		t.Errorf("连接失败: %v", err)
+		return nil
+	}
+	return conn
+}
+
+// waitEmpty 等待注册表中的客户端全部注销。
+func waitEmpty(t *testing.T, hub *ClientHub) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(hub.Counts()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("仍有客户端未注销: %v", hub.Counts())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestClientCloseIsIdempotent(t *testing.T) {
+	hub := NewClientHub()
+	var handlers sync.WaitGroup
+	srv := startHubServer(t, hub, &handlers)
+	conn := dial(t, srv, "user=a")
+	if conn == nil {
+		t.FailNow()
+	}
+	defer conn.Close()
+
+	var client *Client
+	for client == nil {
+		if list := hub.UserClients(1); len(list) > 0 {
+			client = list[0]
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() { defer wg.Done(); client.Close() }()
+		go func() { defer wg.Done(); hub.Unregister(client) }()
+	}
+	wg.Wait()
+
+	if client.Enqueue(NewEnvelope(MessageNotice, "", nil)) {
+		t.Fatal("客户端关闭后 Enqueue 应返回 false")
+	}
+	if hub.Unregister(client) {
+		t.Fatal("重复注销应返回 false")
+	}
+	select {
+	case <-client.Done():
+	default:
+		t.Fatal("Done 通道应已关闭")
+	}
+
+	// 服务端关闭后客户端应收到关闭帧
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				t.Fatalf("期望正常关闭帧，实际: %v", err)
+			}
+			break
+		}
+	}
+	handlers.Wait()
+	waitEmpty(t, hub)
+}
+
+// TestClientHubConcurrentLifecycle 并发地建立和断开大量连接，同时持续向这些用户推送消息并关闭部分客户端，
+// 使用 go test -race 运行时可以发现注册、注销和推送之间的数据竞争。
+func TestClientHubConcurrentLifecycle(t *testing.T) {
+	hub := NewClientHub()
+	var handlers sync.WaitGroup
+	srv := startHubServer(t, hub, &handlers)
+
+	const users = 4
+	const clients = 60
+
+	stop := make(chan struct{})
+	var broadcasters sync.WaitGroup
+	for b := 0; b < 3; b++ {
+		broadcasters.Add(1)
+		go func(b int) {
+			defer broadcasters.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				userID := i%users + 1
+				env := NewEnvelope(MessageNotice, "", NoticePayload{Level: "info", Message: "广播"})
+				hub.ForUser(userID, func(c *Client) {
+					// 与 broadcastToUser 相同：队列已满时关闭客户端
+					if !c.Enqueue(env) {
+						c.Close()
+					}
+				})
+				// 偶尔由服务端主动断开一个连接，与客户端自行断开、读写协程退出并发发生
+				if i%50 == b {
+					if list := hub.UserClients(userID); len(list) > 0 {
+						list[i%len(list)].Close()
+					}
+				}
+				hub.Counts()
+			}
+		}(b)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conn := dial(t, srv, "user="+strings.Repeat("u", i%users+1))
+			if conn == nil {
+				return
+			}
+			defer conn.Close()
+
+			if i%2 == 0 {
+				// 发送一条消息后立即断开，不等待回复
+				msg, _ := json.Marshal(Envelope{Type: MessagePing, ID: "p"})
+				conn.WriteMessage(websocket.TextMessage, msg)
+				return
+			}
+			// 读取若干条推送，连接被服务端关闭时提前结束
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			for n := 0; n < 5; n++ {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	close(stop)
+	broadcasters.Wait()
+	handlers.Wait()
+	waitEmpty(t, hub)
+}
+
+func TestDisconnectClientsSendsSessionRevoked(t *testing.T) {
+	var handlers sync.WaitGroup
+	srv := startHubServer(t, Hub, &handlers)
+
+	keep := dial(t, srv, "user=abcdefg&session=keep")
+	if keep == nil {
+		t.FailNow()
+	}
+	defer keep.Close()
+	revoked := dial(t, srv, "user=abcdefg&session=gone")
+	if revoked == nil {
+		t.FailNow()
+	}
+	defer revoked.Close()
+	for len(Hub.UserClients(7)) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if n := DisconnectClients(7, "gone", "测试"); n != 1 {
+		t.Fatalf("期望断开 1 个连接，实际 %d", n)
+	}
+
+	revoked.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, msg, err := revoked.ReadMessage()
+	if err != nil {
+		t.Fatalf("读取 session_revoked 失败: %v", err)
+	}
+	var env Envelope
+	if err := json.Unmarshal(msg, &env); err != nil || env.Type != MessageSessionRevoked {
+		t.Fatalf("期望 session_revoked，实际 %s", msg)
+	}
+	if _, _, err := revoked.ReadMessage(); !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Fatalf("期望 1008 关闭帧，实际: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(Hub.UserClients(7)) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("被吊销的连接未注销，剩余 %d 个", len(Hub.UserClients(7)))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if Hub.UserClients(7)[0].SessionID != "keep" {
+		t.Fatal("断开了错误的连接")
+	}
+
+	keep.Close()
+	handlers.Wait()
+	waitEmpty(t, Hub)
+}
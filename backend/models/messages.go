@@ -0,0 +1,153 @@
+package models
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// ProtocolV2 是使用消息信封协议的 WebSocket 子协议名称。
+// 握手时未协商该子协议的客户端按旧协议处理，只接收原始的 LaunchData 快照。
+const ProtocolV2 = "launchcounter.v2"
+
+// 服务端发送给客户端的消息类型。
+const (
+	// MessageSnapshot 携带用户完整的聚合数据
+	MessageSnapshot = "snapshot"
+	// MessageDelta 只携带相对上一修订号发生变化的部分
+	MessageDelta = "delta"
+	// MessageNotice 是展示给用户的文字通知
+	MessageNotice = "notice"
+	// MessageSessionRevoked 表示连接所属的会话已被吊销，服务端随后会关闭连接
+	MessageSessionRevoked = "session_revoked"
+	// MessagePong 是对客户端 ping 的回复
+	MessagePong = "pong"
+	// MessageError 表示客户端的某条消息处理失败，id 与出错的消息相同
+	MessageError = "error"
+)
+
+// 客户端发送给服务端的消息类型。
+const (
+	// MessageLaunch 在连接上直接记录一次发射，服务端以 ack 回复
+	MessageLaunch = "launch"
+	// MessageAck 由服务端回复 launch，客户端也可以用它确认已处理某条推送
+	MessageAck = "ack"
+	// MessagePing 是客户端发起的应用层心跳
+	MessagePing = "ping"
+)
+
+// Envelope 是 WebSocket 上传输的消息信封。
+// Type 决定 Payload 的结构；ID 用于关联请求与回复。
+// Seq 是快照和增量消息携带的序号，取值为该用户数据的修订号，按用户单调递增且在服务重启后保持不变，
+// 客户端重连时通过 /ws?since=<seq> 请求补发错过的更新。
+type Envelope struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Seq     int64           `json:"seq,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// NewEnvelope 创建消息信封，payload 为 nil 时不携带负载。
+func NewEnvelope(typ, id string, payload interface{}) Envelope {
+	env := Envelope{Type: typ, ID: id}
+	if payload != nil {
+		raw, err := json.Marshal(payload)
+		if err != nil {
+			// 负载都是服务端自己的类型，序列化失败说明代码有误
+			log.Printf("序列化 %s 消息失败: %v", typ, err)
+		}
+		env.Payload = raw
+	}
+	return env
+}
+
+// SnapshotEnvelope 创建携带完整数据的快照消息。
+func SnapshotEnvelope(data LaunchData) Envelope {
+	env := NewEnvelope(MessageSnapshot, "", data)
+	env.Seq = data.Revision
+	return env
+}
+
+// DeltaEnvelope 创建从 before 到 after 的增量消息。
+func DeltaEnvelope(before, after LaunchData) Envelope {
+	env := NewEnvelope(MessageDelta, "", DiffLaunchData(before, after))
+	env.Seq = after.Revision
+	return env
+}
+
+// DeltaPayload 是增量消息的负载。
+// 三个映射只包含取值发生变化的键，取值为变化后的次数，0 表示该键已被移除。
+// 客户端本地修订号大于等于 Revision 时说明已经包含该更新，可以忽略；
+// 本地修订号不等于 BaseRevision 时说明漏掉了更新，应携带 since 重连或请求完整快照。
+type DeltaPayload struct {
+	BaseRevision int64          `json:"base_revision"`
+	Revision     int64          `json:"revision"`
+	Total        int            `json:"total"`
+	LastLaunch   time.Time      `json:"last_launch"`
+	YearData     map[string]int `json:"year_data"`
+	MonthData    map[string]int `json:"month_data"`
+	DayData      map[string]int `json:"day_data"`
+	Timezone     string         `json:"timezone"`
+}
+
+// DiffLaunchData 计算两份聚合数据之间的差异。
+func DiffLaunchData(before, after LaunchData) DeltaPayload {
+	return DeltaPayload{
+		BaseRevision: before.Revision,
+		Revision:     after.Revision,
+		Total:        after.Total,
+		LastLaunch:   after.LastLaunch,
+		YearData:     diffBuckets(before.YearData, after.YearData),
+		MonthData:    diffBuckets(before.MonthData, after.MonthData),
+		DayData:      diffBuckets(before.DayData, after.DayData),
+		Timezone:     after.Timezone,
+	}
+}
+
+func diffBuckets(before, after map[string]int) map[string]int {
+	diff := make(map[string]int)
+	for k, v := range after {
+		if before[k] != v {
+			diff[k] = v
+		}
+	}
+	for k := range before {
+		if _, ok := after[k]; !ok {
+			diff[k] = 0
+		}
+	}
+	return diff
+}
+
+// NoticePayload 是通知消息的负载，Level 取值为 info、warning 或 error。
+type NoticePayload struct {
+	Level   string `json:"level"`
+	Message string `json:"message"`
+}
+
+// SessionRevokedPayload 是会话吊销消息的负载。
+type SessionRevokedPayload struct {
+	Reason string `json:"reason"`
+}
+
+// LaunchPayload 是客户端 launch 消息的负载，字段含义与 POST /sync/events 中的单个事件相同。
+type LaunchPayload struct {
+	EventID    string    `json:"event_id"`
+	Device     string    `json:"device"`
+	LaunchedAt time.Time `json:"launched_at"`
+	Timezone   string    `json:"timezone"`
+}
+
+// AckPayload 是服务端对 launch 消息的确认。
+// Duplicate 为 true 表示该事件 ID 之前已经记录过，本次没有产生新的发射。
+type AckPayload struct {
+	Accepted  bool  `json:"accepted"`
+	Duplicate bool  `json:"duplicate"`
+	Revision  int64 `json:"revision"`
+}
+
+// ErrorPayload 是错误消息的负载，Fields 列出校验失败的字段。
+type ErrorPayload struct {
+	Message string           `json:"message"`
+	Fields  ValidationErrors `json:"fields,omitempty"`
+}
@@ -0,0 +1,304 @@
+package models
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRateLimitPerMinute 和 DefaultRateLimitBurst 是每个 IP 访问全部接口的默认限速。
+	DefaultRateLimitPerMinute = 120
+	DefaultRateLimitBurst     = 30
+	// DefaultLoginRateLimitPerMinute 和 DefaultLoginRateLimitBurst 是登录、注册接口
+	// 按 IP 和按用户名分别计算的默认限速。
+	DefaultLoginRateLimitPerMinute = 10
+	DefaultLoginRateLimitBurst     = 5
+	// DefaultWSLaunchRateLimitPerMinute 和 DefaultWSLaunchRateLimitBurst 是每个 WebSocket 连接发送 launch 消息的默认限速。
+	DefaultWSLaunchRateLimitPerMinute = 60
+	DefaultWSLaunchRateLimitBurst     = 10
+	// DefaultLoginMaxFailures 是账号被临时锁定前允许的连续登录失败次数。
+	DefaultLoginMaxFailures = 5
+	// DefaultLoginLockout 是账号被锁定的时长，同时也是统计失败次数的时间窗口。
+	DefaultLoginLockout = 15 * time.Minute
+)
+
+// RateLimiter 是按键（IP 或用户名）划分的令牌桶限速器。
+// 每个键拥有容量为 burst 的令牌桶，令牌以每分钟 perMinute 个的速度补充。
+type RateLimiter struct {
+	mu      sync.Mutex
+	rate    float64 // 每秒补充的令牌数
+	burst   float64
+	buckets map[string]*bucket
+	// lastSweep 是上次清理空闲令牌桶的时间
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter 创建令牌桶限速器。perMinute 小于等于 0 时返回 nil，表示不限速。
+func NewRateLimiter(perMinute, burst int) *RateLimiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow 从 key 对应的令牌桶中取出一个令牌。
+// 令牌不足时返回 false 以及预计可以重试的等待时间。对 nil 限速器总是返回 true。
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	// 按距上次访问的时间补充令牌，最多补满
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// Peek 判断 key 对应的令牌桶中是否还有令牌，但不取出令牌。
+// 令牌不足时返回 false 以及预计可以重试的等待时间。对 nil 限速器总是返回 true。
+func (l *RateLimiter) Peek(key string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		return true, 0
+	}
+	tokens := math.Min(l.burst, b.tokens+time.Since(b.last).Seconds()*l.rate)
+	if tokens < 1 {
+		return false, time.Duration((1 - tokens) / l.rate * float64(time.Second))
+	}
+	return true, 0
+}
+
+// sweep 每分钟清理一次已经补满的令牌桶，避免大量一次性访问的 IP 占用内存。调用方必须持有锁。
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Lockout 描述一个因登录失败次数过多而被临时锁定的账号。
+type Lockout struct {
+	Username    string
+	Failures    int
+	LockedUntil time.Time
+}
+
+type loginFailures struct {
+	count       int
+	first       time.Time
+	lockedUntil time.Time
+}
+
+// trustedLogin 记录账号曾在某个 IP 上成功登录，账号被锁定时该 IP 仍然可以登录。
+type trustedLogin struct {
+	// failures 是该 IP 上最近一次成功登录之后的失败次数，达到阈值后不再信任该 IP
+	failures int
+	until    time.Time
+}
+
+// LoginGuard 保护登录接口免受暴力破解：按用户名限速，并在连续失败达到阈值后临时锁定账号。
+// 锁定针对账号本身，来自大量 IP 的分布式猜测同样会被拦住；账号曾在某个 IP 上成功登录时，
+// 该 IP 不受锁定和按用户名限速的影响，故意输错密码的人无法把已登录过的账号主人锁在外面。
+// 按用户名的限速只在登录失败时扣减令牌。
+// 用户名不区分大小写，与 MySQL 默认的排序规则一致。
+type LoginGuard struct {
+	limiter     *RateLimiter
+	maxFailures int
+	lockout     time.Duration
+	// trustFor 是成功登录后该 IP 保持受信任的时长
+	trustFor time.Duration
+
+	mu       sync.Mutex
+	failures map[string]*loginFailures
+	trusted  map[string]*trustedLogin
+	// lastSweep 是上次清理过期记录的时间
+	lastSweep time.Time
+}
+
+// NewLoginGuard 根据配置创建登录保护器。
+// 成功登录的 IP 在刷新令牌的有效期内保持受信任，与该设备保持登录的时长一致。
+func NewLoginGuard(config *Config) *LoginGuard {
+	perMinute, burst := config.LoginRateLimit()
+	return &LoginGuard{
+		limiter:     NewRateLimiter(perMinute, burst),
+		maxFailures: config.MaxLoginFailures(),
+		lockout:     config.LoginLockout(),
+		trustFor:    config.RefreshTokenTTL(),
+		failures:    make(map[string]*loginFailures),
+		trusted:     make(map[string]*trustedLogin),
+	}
+}
+
+// trustKey 返回用户名和 IP 组合的受信任记录键。
+func trustKey(name, ip string) string {
+	return name + "\x00" + ip
+}
+
+// Check 判断是否允许从 ip 对该用户名进行一次登录尝试，本身不扣减限速令牌。
+// 不允许时返回 false、需要等待的时间，以及是否因为账号被锁定（而不是单纯限速）。
+func (g *LoginGuard) Check(username, ip string) (ok bool, retryAfter time.Duration, locked bool) {
+	name := strings.ToLower(username)
+	now := time.Now()
+
+	g.mu.Lock()
+	if t, exists := g.trusted[trustKey(name, ip)]; exists && now.Before(t.until) {
+		g.mu.Unlock()
+		return true, 0, false
+	}
+	if f, exists := g.failures[name]; exists {
+		if wait := f.lockedUntil.Sub(now); wait > 0 {
+			g.mu.Unlock()
+			return false, wait, true
+		}
+	}
+	g.mu.Unlock()
+
+	if allowed, wait := g.limiter.Peek(name); !allowed {
+		return false, wait, false
+	}
+	return true, 0, false
+}
+
+// RecordFailure 记录一次来自 ip 的登录失败，达到阈值时锁定账号并返回 true。
+// 时间窗口内的第一次失败距今超过锁定时长时重新计数。
+// 受信任的 IP 上的失败单独计数，达到阈值后该 IP 不再受信任，之后与其他 IP 一样受锁定约束。
+func (g *LoginGuard) RecordFailure(username, ip string) bool {
+	name := strings.ToLower(username)
+	// 只有失败的尝试扣减按用户名的令牌，其他人无法靠频繁尝试让账号的主人一直收到 429
+	g.limiter.Allow(name)
+	if g.maxFailures <= 0 {
+		return false
+	}
+	now := time.Now()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	// 不存在的用户名同样会产生失败记录，写入时顺带清理，避免随机用户名撑大内存
+	g.sweep(now)
+
+	key := trustKey(name, ip)
+	if t, ok := g.trusted[key]; ok && now.Before(t.until) {
+		t.failures++
+		if t.failures >= g.maxFailures {
+			delete(g.trusted, key)
+		}
+		return false
+	}
+
+	f, ok := g.failures[name]
+	if !ok || now.Sub(f.first) > g.lockout {
+		f = &loginFailures{first: now}
+		g.failures[name] = f
+	}
+	f.count++
+	if f.count >= g.maxFailures {
+		f.lockedUntil = now.Add(g.lockout)
+		return true
+	}
+	return false
+}
+
+// RecordSuccess 在登录成功后信任该 IP，并在账号未被锁定时清除其失败记录。
+// 账号被锁定期间从受信任的 IP 登录成功不会解除锁定，否则猜测密码的人可以借此重新开始计数。
+func (g *LoginGuard) RecordSuccess(username, ip string) {
+	name := strings.ToLower(username)
+	now := time.Now()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.trusted[trustKey(name, ip)] = &trustedLogin{until: now.Add(g.trustFor)}
+	if f, ok := g.failures[name]; ok && !now.Before(f.lockedUntil) {
+		delete(g.failures, name)
+	}
+}
+
+// sweep 每分钟清理一次已经解锁且超出统计窗口的失败记录，以及已经过期的受信任记录。调用方必须持有锁。
+func (g *LoginGuard) sweep(now time.Time) {
+	if now.Sub(g.lastSweep) < time.Minute {
+		return
+	}
+	g.lastSweep = now
+	for key, f := range g.failures {
+		if !now.Before(f.lockedUntil) && now.Sub(f.first) > g.lockout {
+			delete(g.failures, key)
+		}
+	}
+	for key, t := range g.trusted {
+		if !now.Before(t.until) {
+			delete(g.trusted, key)
+		}
+	}
+}
+
+// Lockouts 按解锁时间顺序返回当前仍处于锁定状态的账号。
+func (g *LoginGuard) Lockouts() []Lockout {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	g.sweep(now)
+	var result []Lockout
+	for name, f := range g.failures {
+		if now.Before(f.lockedUntil) {
+			result = append(result, Lockout{Username: name, Failures: f.count, LockedUntil: f.lockedUntil})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].LockedUntil.Before(result[j].LockedUntil) })
+	return result
+}
+
+// Unlock 解除账号锁定并清空失败次数，账号原本没有失败记录时返回 false。
+func (g *LoginGuard) Unlock(username string) bool {
+	name := strings.ToLower(username)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.failures[name]; !ok {
+		return false
+	}
+	delete(g.failures, name)
+	return true
+}
@@ -0,0 +1,100 @@
+package models
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestLoginGuardLocksAccount(t *testing.T) {
+	g := NewLoginGuard(&Config{LoginMaxFailures: 3, LoginRateLimitPerMinute: -1})
+	g.RecordSuccess("alice", "10.0.0.1")
+
+	// 来自不同 IP 的失败共同计入账号的失败次数
+	for i := 0; i < 3; i++ {
+		g.RecordFailure("Alice", fmt.Sprintf("10.0.1.%d", i))
+	}
+	if ok, _, locked := g.Check("alice", "10.0.2.1"); ok || !locked {
+		t.Fatalf("失败次数达到阈值后账号应被锁定")
+	}
+	// 账号主人曾成功登录过的 IP 不受锁定影响
+	if ok, _, _ := g.Check("ALICE", "10.0.0.1"); !ok {
+		t.Fatalf("受信任的 IP 不应被锁定")
+	}
+	// 锁定期间从受信任的 IP 登录成功不会解除锁定
+	g.RecordSuccess("alice", "10.0.0.1")
+	if ok, _, _ := g.Check("alice", "10.0.2.1"); ok {
+		t.Fatalf("受信任的 IP 登录成功不应解除锁定")
+	}
+	if n := len(g.Lockouts()); n != 1 {
+		t.Fatalf("锁定记录 %d 条，期望 1 条", n)
+	}
+	if !g.Unlock("ALICE") {
+		t.Fatalf("解锁应找到失败记录")
+	}
+	if ok, _, _ := g.Check("alice", "10.0.2.1"); !ok {
+		t.Fatalf("解锁后应允许登录")
+	}
+}
+
+func TestLoginGuardRevokesTrustAfterFailures(t *testing.T) {
+	g := NewLoginGuard(&Config{LoginMaxFailures: 3, LoginRateLimitPerMinute: -1})
+	g.RecordSuccess("alice", "10.0.0.1")
+	for i := 0; i < 3; i++ {
+		if g.RecordFailure("alice", "10.0.0.1") {
+			t.Fatalf("受信任的 IP 上的失败不应锁定账号")
+		}
+	}
+	// 受信任的 IP 连续失败后与其他 IP 一样计入账号的失败次数
+	for i := 0; i < 3; i++ {
+		g.RecordFailure("alice", "10.0.0.1")
+	}
+	if ok, _, locked := g.Check("alice", "10.0.0.1"); ok || !locked {
+		t.Fatalf("不再受信任的 IP 应受锁定约束")
+	}
+}
+
+func TestLoginGuardChargesUsernameOnFailure(t *testing.T) {
+	g := NewLoginGuard(&Config{LoginMaxFailures: -1, LoginRateLimitPerMinute: 1, LoginRateLimitBurst: 2})
+	// 检查本身不扣减令牌，成功的登录不会被其他人的尝试挤掉
+	for i := 0; i < 10; i++ {
+		if ok, _, _ := g.Check("alice", "10.0.0.1"); !ok {
+			t.Fatalf("没有失败记录时第 %d 次检查被限速", i+1)
+		}
+	}
+	g.RecordFailure("alice", "10.0.1.1")
+	g.RecordFailure("alice", "10.0.1.2")
+	if ok, wait, locked := g.Check("alice", "10.0.1.3"); ok || locked || wait <= 0 {
+		t.Fatalf("失败次数用完令牌后应被限速，实际 ok=%v wait=%v locked=%v", ok, wait, locked)
+	}
+	g.RecordSuccess("alice", "10.0.0.1")
+	if ok, _, _ := g.Check("alice", "10.0.0.1"); !ok {
+		t.Fatalf("受信任的 IP 不应受按用户名的限速影响")
+	}
+}
+
+func TestLoginGuardSweepsExpiredFailures(t *testing.T) {
+	g := NewLoginGuard(&Config{LoginMaxFailures: 3, LoginRateLimitPerMinute: -1})
+	for i := 0; i < 100; i++ {
+		g.RecordFailure(fmt.Sprintf("user%d", i), "10.0.0.1")
+	}
+	g.RecordSuccess("carol", "10.0.0.1")
+	// 将全部记录移到统计窗口之外，下一次写入应将它们清理掉
+	g.mu.Lock()
+	for _, f := range g.failures {
+		f.first = f.first.Add(-2 * g.lockout)
+	}
+	for _, t := range g.trusted {
+		t.until = time.Now().Add(-time.Second)
+	}
+	g.lastSweep = time.Time{}
+	g.mu.Unlock()
+
+	g.RecordFailure("bob", "10.0.0.1")
+	if n := len(g.failures); n != 1 {
+		t.Fatalf("清理后剩余 %d 条失败记录，期望 1 条", n)
+	}
+	if n := len(g.trusted); n != 0 {
+		t.Fatalf("清理后剩余 %d 条受信任记录，期望 0 条", n)
+	}
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// sensitiveQueryParams 是日志中需要隐藏取值的查询参数。
+var sensitiveQueryParams = []string{"token", "access_token", "refresh_token", "ticket"}
+
+// RedactToken 返回令牌在日志中的替代文本，只保留长度和短指纹。
+// 指纹是 SHA-256 的前 4 个字节，足以在多条日志之间关联同一个令牌，但无法还原令牌本身。
+func RedactToken(token string) string {
+	if token == "" {
+		return "[空]"
+	}
+	sum := sha256.Sum256([]byte(token))
+	return fmt.Sprintf("[已隐藏 长度=%d 指纹=%x]", len(token), sum[:4])
+}
+
+// RedactQuery 隐藏请求路径中敏感查询参数的取值，例如 "/ws?token=..."。
+// 参数 path 是包含查询字符串的请求路径，没有查询字符串时原样返回。
+func RedactQuery(path string) string {
+	base, rawQuery, ok := strings.Cut(path, "?")
+	if !ok {
+		return path
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		// 无法解析的查询字符串可能包含任意内容，整体隐藏
+		return base + "?[已隐藏]"
+	}
+	changed := false
+	for _, key := range sensitiveQueryParams {
+		if _, exists := query[key]; exists {
+			query.Set(key, "REDACTED")
+			changed = true
+		}
+	}
+	if !changed {
+		return path
+	}
+	return base + "?" + query.Encode()
+}
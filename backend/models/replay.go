@@ -0,0 +1,111 @@
+package models
+
+import "sync"
+
+const (
+	// DefaultReplayBufferSize 是每个用户默认保留的增量消息条数。
+	DefaultReplayBufferSize = 64
+	// MaxReplayBufferSize 是补发缓冲区的上限，补发时所有消息一次性放入发送队列，不能超过队列容量。
+	MaxReplayBufferSize = 200
+)
+
+// Updates 是全局的推送更新日志，服务启动时按配置替换为指定缓冲区大小的实例。
+var Updates = NewUpdateLog(DefaultReplayBufferSize)
+
+// UpdateLog 为每个用户分配推送更新的序号，并保留最近的增量消息，
+// 使断线重连的客户端可以通过 since 参数补发错过的更新，而不必重新拉取完整数据。
+// 序号取自数据的修订号，因此服务重启后客户端手中的序号依然有效：
+// 缓冲区为空时只要修订号没有变化就无需补发，否则回退为完整快照。
+type UpdateLog struct {
+	mu      sync.Mutex
+	size    int
+	streams map[int]*updateStream
+}
+
+type updateStream struct {
+	// last 是最近一次推送后的数据，下一条增量以它为基准计算，保证增量首尾相接
+	last LaunchData
+	// entries 按序号递增保存最近的增量消息，bases 是对应增量的基准修订号
+	entries []Envelope
+	bases   []int64
+}
+
+// NewUpdateLog 创建每个用户最多保留 size 条增量消息的更新日志，size 小于等于 0 时使用默认值。
+func NewUpdateLog(size int) *UpdateLog {
+	if size <= 0 {
+		size = DefaultReplayBufferSize
+	}
+	if size > MaxReplayBufferSize {
+		size = MaxReplayBufferSize
+	}
+	return &UpdateLog{size: size, streams: make(map[int]*updateStream)}
+}
+
+// Publish 记录用户数据从 before 变为 after 的一次更新，并在持有锁的情况下调用 deliver 推送。
+// 同一用户的更新按序号顺序交给 deliver，各连接收到的消息顺序与序号一致。
+// 增量以上一次推送的数据为基准计算；并发请求导致的过期更新（修订号不大于已推送的修订号）会被忽略，
+// 因为较新的那次推送已经包含了它的变化。
+func (l *UpdateLog) Publish(userID int, before, after LaunchData, deliver func(delta, snapshot Envelope)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s, ok := l.streams[userID]
+	if !ok {
+		s = &updateStream{last: before}
+		l.streams[userID] = s
+	}
+	if after.Revision <= s.last.Revision {
+		return
+	}
+
+	delta := DeltaEnvelope(s.last, after)
+	s.entries = append(s.entries, delta)
+	s.bases = append(s.bases, s.last.Revision)
+	if over := len(s.entries) - l.size; over > 0 {
+		s.entries = append([]Envelope(nil), s.entries[over:]...)
+		s.bases = append([]int64(nil), s.bases[over:]...)
+	}
+	s.last = after
+
+	deliver(delta, SnapshotEnvelope(after))
+}
+
+// Resume 计算重连客户端需要补发的消息，并在持有锁的情况下调用 attach。
+// attach 应在其中注册客户端并放入补发消息，这样补发与之后的实时推送之间不会遗漏或乱序。
+// 参数 since 是客户端已处理的最后一个序号，小于 0 表示客户端没有提供；
+// 参数 current 是从存储中读取的当前数据。
+// 能够从缓冲区补齐时 replay 为 since 之后的全部增量（可能为空）；
+// 客户端没有提供序号、序号超出缓冲区范围或与服务端不一致时 replay 为一条完整快照，resumed 为 false。
+func (l *UpdateLog) Resume(userID int, since int64, current LaunchData, attach func(replay []Envelope, resumed bool)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s, ok := l.streams[userID]
+	if ok && s.last.Revision >= current.Revision {
+		// 存储中读到的数据可能早于刚刚推送的更新，以较新的为准
+		current = s.last
+	}
+
+	if since >= 0 {
+		if since == current.Revision {
+			attach(nil, true)
+			return
+		}
+		if ok && s.last.Revision == current.Revision {
+			for i, base := range s.bases {
+				if base == since {
+					attach(append([]Envelope(nil), s.entries[i:]...), true)
+					return
+				}
+			}
+		}
+	}
+	attach([]Envelope{SnapshotEnvelope(current)}, false)
+}
+
+// Forget 丢弃用户的更新记录，用于删除用户等不再需要补发的场景。
+func (l *UpdateLog) Forget(userID int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.streams, userID)
+}
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultAccessTokenTTL 是访问令牌的默认有效期，与引入会话之前的令牌相同。
+	// 目前的客户端只保存访问令牌而不会刷新，有效期过短会让用户频繁重新登录；
+	// 支持刷新的客户端发布后可以通过 access_token_ttl_minutes 缩短。
+	DefaultAccessTokenTTL = 7 * 24 * time.Hour
+	// DefaultRefreshTokenTTL 是刷新令牌的默认有效期，每次刷新都会重新计算。
+	DefaultRefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// ErrMalformedRefreshToken 表示刷新令牌的格式不正确。
+var ErrMalformedRefreshToken = errors.New("刷新令牌格式无效")
+
+// Session 是一次登录产生的服务器端会话。
+// 访问令牌中携带会话 ID，会话被吊销后该会话签发的全部令牌随之失效。
+// 刷新令牌只保存其 SHA-256 哈希，每次刷新都会轮换为新的令牌。
+type Session struct {
+	ID          string
+	UserID      int
+	RefreshHash string
+	UserAgent   string
+	IP          string
+	CreatedAt   time.Time
+	LastUsedAt  time.Time
+	ExpiresAt   time.Time
+	// RevokedAt 为零值表示会话未被吊销
+	RevokedAt time.Time
+}
+
+// Active 判断会话在 now 时刻是否仍然有效。
+func (s Session) Active(now time.Time) bool {
+	return s.RevokedAt.IsZero() && now.Before(s.ExpiresAt)
+}
+
+// NewSessionID 生成随机的会话 ID。
+func NewSessionID() string {
+	return randomHex(16)
+}
+
+// NewRefreshToken 为指定会话生成新的刷新令牌，返回令牌本身及其哈希。
+// 令牌格式为 "<会话 ID>.<随机串>"，服务器据此定位会话后再比较哈希。
+func NewRefreshToken(sessionID string) (token, hash string) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic("无法生成刷新令牌: " + err.Error())
+	}
+	secret := base64.RawURLEncoding.EncodeToString(b)
+	return sessionID + "." + secret, HashRefreshToken(secret)
+}
+
+// ParseRefreshToken 将刷新令牌拆分为会话 ID 和随机串的哈希。
+func ParseRefreshToken(token string) (sessionID, hash string, err error) {
+	sessionID, secret, ok := strings.Cut(token, ".")
+	if !ok || sessionID == "" || secret == "" {
+		return "", "", ErrMalformedRefreshToken
+	}
+	return sessionID, HashRefreshToken(secret), nil
+}
+
+// HashRefreshToken 计算刷新令牌随机串的 SHA-256 哈希，数据库中只保存该哈希。
+func HashRefreshToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomHex 返回 n 个随机字节的十六进制表示。
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic("无法生成随机数: " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}
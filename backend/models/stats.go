@@ -0,0 +1,222 @@
+package models
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// StatsPeriods 是统计趋势时可选的周期及其天数。趋势比较的是截至当前时刻的最近一个周期和它之前的一个周期。
+var StatsPeriods = map[string]int{
+	"week":  7,
+	"month": 30,
+	"year":  365,
+}
+
+// DefaultStatsPeriod 是未指定周期时使用的趋势周期。
+const DefaultStatsPeriod = "month"
+
+// Stats 是由服务端根据发射事件计算的统计数据，各端直接展示，不再各自计算。
+// 日期相关的统计与 day_data 一样按事件的时区划分，日期使用 DayKey 格式。
+type Stats struct {
+	Timezone    string     `json:"timezone"`
+	GeneratedAt time.Time  `json:"generated_at"`
+	Total       int        `json:"total"`
+	FirstLaunch *time.Time `json:"first_launch"`
+	LastLaunch  *time.Time `json:"last_launch"`
+	// ActiveDays 是至少发射过一次的天数
+	ActiveDays int `json:"active_days"`
+	// CurrentStreak 是截至今天的连续发射天数；今天还没有发射时从昨天开始计算，连续记录仍然有效
+	CurrentStreak Streak `json:"current_streak"`
+	// LongestStreak 是历史最长的连续发射天数，长度相同时取最近的一段
+	LongestStreak Streak       `json:"longest_streak"`
+	Averages      StatsAverage `json:"averages"`
+	// Weekdays 是按星期统计的发射次数，下标 0 为星期日
+	Weekdays       [7]int      `json:"weekdays"`
+	BusiestWeekday *BusiestDay `json:"busiest_weekday"`
+	Hours          [24]int     `json:"hours"`
+	LongestGap     *LaunchGap  `json:"longest_gap"`
+	Trend          StatsTrend  `json:"trend"`
+}
+
+// Streak 是一段连续发射的日期，Days 为 0 时 Start 和 End 为空。
+type Streak struct {
+	Days  int    `json:"days"`
+	Start string `json:"start,omitempty"`
+	End   string `json:"end,omitempty"`
+}
+
+// StatsAverage 是从第一次发射当天到今天为止的平均发射次数，保留两位小数。
+// 每周和每月的平均值由每日平均值换算，每月按 365.2425/12 天计算。
+type StatsAverage struct {
+	PerDay   float64 `json:"per_day"`
+	PerWeek  float64 `json:"per_week"`
+	PerMonth float64 `json:"per_month"`
+}
+
+// BusiestDay 是发射次数最多的星期，次数相同时取靠前的一天。
+type BusiestDay struct {
+	Weekday int    `json:"weekday"`
+	Name    string `json:"name"`
+	Count   int    `json:"count"`
+}
+
+// LaunchGap 是相邻两次发射之间最长的间隔。
+type LaunchGap struct {
+	Seconds int64     `json:"seconds"`
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+}
+
+// StatsTrend 比较最近一个周期和之前一个周期的发射次数。
+// 之前的周期没有发射时无法计算百分比，ChangePercent 为 null。
+type StatsTrend struct {
+	Period        string   `json:"period"`
+	Days          int      `json:"days"`
+	Current       int      `json:"current"`
+	Previous      int      `json:"previous"`
+	Change        int      `json:"change"`
+	ChangePercent *float64 `json:"change_percent"`
+}
+
+// ComputeStats 根据按时间排序的发射事件计算统计数据。
+// 参数 loc 是用户的时区，用于没有记录时区的事件和确定“今天”。
+// 参数 now 是当前时间，period 是 StatsPeriods 中的趋势周期。
+func ComputeStats(events []LaunchEvent, loc *time.Location, now time.Time, period string) Stats {
+	stats := Stats{
+		Timezone:    loc.String(),
+		GeneratedAt: now,
+		Total:       len(events),
+	}
+
+	// 按发射时间排序，存储层已经排好序时开销很小
+	sorted := append([]LaunchEvent(nil), events...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].LaunchedAt.Before(sorted[j].LaunchedAt) })
+
+	days := make(map[int]bool)
+	for i, e := range sorted {
+		t := e.LaunchedAt.In(e.Location(loc))
+		days[dayNumber(t)] = true
+		stats.Weekdays[t.Weekday()]++
+		stats.Hours[t.Hour()]++
+		if i > 0 {
+			prev := sorted[i-1].LaunchedAt
+			if gap := e.LaunchedAt.Sub(prev); stats.LongestGap == nil || gap > time.Duration(stats.LongestGap.Seconds)*time.Second {
+				stats.LongestGap = &LaunchGap{Seconds: int64(gap / time.Second), From: prev.In(loc), To: e.LaunchedAt.In(loc)}
+			}
+		}
+	}
+	stats.ActiveDays = len(days)
+	stats.Trend = computeTrend(sorted, now, period)
+	if len(sorted) == 0 {
+		return stats
+	}
+
+	first := sorted[0].LaunchedAt.In(loc)
+	last := sorted[len(sorted)-1].LaunchedAt.In(loc)
+	stats.FirstLaunch, stats.LastLaunch = &first, &last
+
+	today := dayNumber(now.In(loc))
+	stats.CurrentStreak = currentStreak(days, today)
+	stats.LongestStreak = longestStreak(days)
+
+	// 第一次发射的日期按该事件自己的时区计算，与 day_data 保持一致
+	firstDay := dayNumber(sorted[0].LaunchedAt.In(sorted[0].Location(loc)))
+	if span := today - firstDay + 1; span > 0 {
+		perDay := float64(stats.Total) / float64(span)
+		stats.Averages = StatsAverage{
+			PerDay:   round2(perDay),
+			PerWeek:  round2(perDay * 7),
+			PerMonth: round2(perDay * 365.2425 / 12),
+		}
+	}
+
+	busiest := 0
+	for d := 1; d < 7; d++ {
+		if stats.Weekdays[d] > stats.Weekdays[busiest] {
+			busiest = d
+		}
+	}
+	stats.BusiestWeekday = &BusiestDay{Weekday: busiest, Name: time.Weekday(busiest).String(), Count: stats.Weekdays[busiest]}
+	return stats
+}
+
+// computeTrend 统计 (now-days, now] 和 (now-2*days, now-days] 两个区间内的发射次数。
+func computeTrend(sorted []LaunchEvent, now time.Time, period string) StatsTrend {
+	days, ok := StatsPeriods[period]
+	if !ok {
+		period, days = DefaultStatsPeriod, StatsPeriods[DefaultStatsPeriod]
+	}
+	trend := StatsTrend{Period: period, Days: days}
+	length := time.Duration(days) * 24 * time.Hour
+	currentStart, previousStart := now.Add(-length), now.Add(-2*length)
+	for _, e := range sorted {
+		// 时钟偏差范围内略晚于 now 的事件同样计入当前周期
+		switch {
+		case e.LaunchedAt.After(currentStart):
+			trend.Current++
+		case e.LaunchedAt.After(previousStart):
+			trend.Previous++
+		}
+	}
+	trend.Change = trend.Current - trend.Previous
+	if trend.Previous > 0 {
+		percent := round2(float64(trend.Change) / float64(trend.Previous) * 100)
+		trend.ChangePercent = &percent
+	}
+	return trend
+}
+
+// currentStreak 计算截至 today 的连续发射天数，today 没有发射时从前一天开始计算。
+func currentStreak(days map[int]bool, today int) Streak {
+	end := today
+	if !days[end] {
+		end--
+	}
+	start := end
+	for days[start] {
+		start--
+	}
+	return newStreak(start+1, end)
+}
+
+// longestStreak 计算最长的连续发射天数。
+func longestStreak(days map[int]bool) Streak {
+	bestStart, bestEnd := 0, -1
+	for day := range days {
+		// 只从一段连续日期的第一天开始向后计数
+		if days[day-1] {
+			continue
+		}
+		end := day
+		for days[end+1] {
+			end++
+		}
+		if length, best := end-day, bestEnd-bestStart; length > best || (length == best && end > bestEnd) {
+			bestStart, bestEnd = day, end
+		}
+	}
+	return newStreak(bestStart, bestEnd)
+}
+
+// newStreak 根据起止日序号创建连续记录，end 小于 start 时表示没有连续记录。
+func newStreak(start, end int) Streak {
+	if end < start {
+		return Streak{}
+	}
+	return Streak{Days: end - start + 1, Start: DayKey(dayTime(start)), End: DayKey(dayTime(end))}
+}
+
+// dayNumber 返回 t 所在日期（按 t 自身的时区）距 1970-01-01 的天数，不受夏令时影响。
+func dayNumber(t time.Time) int {
+	return int(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Unix() / 86400)
+}
+
+// dayTime 是 dayNumber 的逆运算，返回 UTC 下当天零点的时间。
+func dayTime(n int) time.Time {
+	return time.Unix(int64(n)*86400, 0).UTC()
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,140 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestComputeStats(t *testing.T) {
+	loc := time.UTC
+	// ev 返回 2024 年 3 月 day 日 hour 时（UTC）的事件，tz 非空时事件按该时区统计
+	ev := func(day, hour int, tz string) LaunchEvent {
+		return LaunchEvent{LaunchedAt: time.Date(2024, 3, day, hour, 0, 0, 0, time.UTC), Timezone: tz}
+	}
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		events     []LaunchEvent
+		activeDays int
+		current    Streak
+		longest    Streak
+		perDay     float64
+	}{
+		{
+			name: "没有事件",
+		},
+		{
+			name:       "截至今天的连续记录",
+			events:     []LaunchEvent{ev(8, 9, ""), ev(9, 9, ""), ev(10, 9, ""), ev(10, 10, "")},
+			activeDays: 3,
+			current:    Streak{Days: 3, Start: "2024-3-8", End: "2024-3-10"},
+			longest:    Streak{Days: 3, Start: "2024-3-8", End: "2024-3-10"},
+			perDay:     1.33,
+		},
+		{
+			name:       "今天还没有发射时从昨天开始计算",
+			events:     []LaunchEvent{ev(8, 9, ""), ev(9, 9, "")},
+			activeDays: 2,
+			current:    Streak{Days: 2, Start: "2024-3-8", End: "2024-3-9"},
+			longest:    Streak{Days: 2, Start: "2024-3-8", End: "2024-3-9"},
+			perDay:     0.67,
+		},
+		{
+			name:       "昨天和今天都没有发射时连续记录中断",
+			events:     []LaunchEvent{ev(7, 9, ""), ev(8, 9, "")},
+			activeDays: 2,
+			longest:    Streak{Days: 2, Start: "2024-3-7", End: "2024-3-8"},
+			perDay:     0.5,
+		},
+		{
+			name:       "最长连续记录长度相同时取最近的一段",
+			events:     []LaunchEvent{ev(1, 9, ""), ev(2, 9, ""), ev(5, 9, ""), ev(6, 9, "")},
+			activeDays: 4,
+			longest:    Streak{Days: 2, Start: "2024-3-5", End: "2024-3-6"},
+			perDay:     0.4,
+		},
+		{
+			// UTC 3 月 9 日 20 点在上海已是 3 月 10 日，第一次发射的日期按事件自己的时区计算
+			name:       "事件时区与用户时区不同",
+			events:     []LaunchEvent{ev(9, 20, "Asia/Shanghai"), ev(10, 9, "")},
+			activeDays: 1,
+			current:    Streak{Days: 1, Start: "2024-3-10", End: "2024-3-10"},
+			longest:    Streak{Days: 1, Start: "2024-3-10", End: "2024-3-10"},
+			perDay:     2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats := ComputeStats(tt.events, loc, now, "week")
+			if stats.Total != len(tt.events) || stats.ActiveDays != tt.activeDays {
+				t.Errorf("总数 %d，活跃天数 %d，期望 %d、%d", stats.Total, stats.ActiveDays, len(tt.events), tt.activeDays)
+			}
+			if stats.CurrentStreak != tt.current {
+				t.Errorf("当前连续记录 %+v，期望 %+v", stats.CurrentStreak, tt.current)
+			}
+			if stats.LongestStreak != tt.longest {
+				t.Errorf("最长连续记录 %+v，期望 %+v", stats.LongestStreak, tt.longest)
+			}
+			if stats.Averages.PerDay != tt.perDay {
+				t.Errorf("每日平均 %v，期望 %v", stats.Averages.PerDay, tt.perDay)
+			}
+		})
+	}
+}
+
+func TestComputeTrend(t *testing.T) {
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	week := 7 * 24 * time.Hour
+	at := func(offsets ...time.Duration) []LaunchEvent {
+		events := make([]LaunchEvent, 0, len(offsets))
+		for _, d := range offsets {
+			events = append(events, LaunchEvent{LaunchedAt: now.Add(d)})
+		}
+		return events
+	}
+	percent := func(v float64) *float64 { return &v }
+
+	tests := []struct {
+		name   string
+		events []LaunchEvent
+		period string
+		want   StatsTrend
+	}{
+		{
+			name:   "之前的周期没有发射",
+			events: at(-time.Hour),
+			period: "week",
+			want:   StatsTrend{Period: "week", Days: 7, Current: 1, Change: 1},
+		},
+		{
+			// 恰好在周期起点的事件属于之前的周期，恰好在两个周期之前的事件不计入
+			name:   "周期边界",
+			events: at(-2*week, -2*week+time.Second, -week, -week+time.Second, time.Minute),
+			period: "week",
+			want:   StatsTrend{Period: "week", Days: 7, Current: 2, Previous: 2, Change: 0, ChangePercent: percent(0)},
+		},
+		{
+			name:   "次数减少",
+			events: at(-10*24*time.Hour, -9*24*time.Hour, -8*24*time.Hour, -time.Hour),
+			period: "week",
+			want:   StatsTrend{Period: "week", Days: 7, Current: 1, Previous: 3, Change: -2, ChangePercent: percent(-66.67)},
+		},
+		{
+			name:   "无效的周期使用默认值",
+			events: at(-20*24*time.Hour, -40*24*time.Hour),
+			period: "decade",
+			want:   StatsTrend{Period: "month", Days: 30, Current: 1, Previous: 1, Change: 0, ChangePercent: percent(0)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := computeTrend(tt.events, now, tt.period); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("趋势 %+v，期望 %+v", got, tt.want)
+			}
+		})
+	}
+}
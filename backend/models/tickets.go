@@ -0,0 +1,77 @@
+package models
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultWSTicketTTL 是 WebSocket 连接票据的默认有效期。
+// 票据只用于紧接着发起的一次连接，有效期很短，即使出现在代理日志中也很快失效。
+const DefaultWSTicketTTL = 30 * time.Second
+
+// ProtocolTokenPrefix 是通过 Sec-WebSocket-Protocol 传递访问令牌时使用的子协议前缀，
+// 客户端请求的子协议形如 "launchcounter.token.<访问令牌>"，同时必须请求 ProtocolV2。
+// 服务端只会回应 ProtocolV2，令牌不会出现在响应中。
+const ProtocolTokenPrefix = "launchcounter.token."
+
+// Ticket 是一张已签发的连接票据所代表的身份。
+type Ticket struct {
+	UserID    int
+	SessionID string
+	ExpiresAt time.Time
+}
+
+// TicketStore 保存尚未使用的 WebSocket 连接票据。
+// 客户端先用访问令牌换取票据，再以 /ws?ticket=<票据> 建立连接，长期有效的令牌因此不会出现在 URL 中。
+// 每张票据只能使用一次，服务重启后全部失效。
+type TicketStore struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	tickets map[string]Ticket
+}
+
+// NewTicketStore 创建有效期为 ttl 的票据存储，ttl 小于等于 0 时使用默认值。
+func NewTicketStore(ttl time.Duration) *TicketStore {
+	if ttl <= 0 {
+		ttl = DefaultWSTicketTTL
+	}
+	return &TicketStore{ttl: ttl, tickets: make(map[string]Ticket)}
+}
+
+// TTL 返回票据的有效期。
+func (s *TicketStore) TTL() time.Duration {
+	return s.ttl
+}
+
+// Issue 为指定用户和会话签发一张新票据。
+func (s *TicketStore) Issue(userID int, sessionID string) string {
+	ticket := randomHex(24)
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// 顺带清理过期未使用的票据，票据数量与短时间内的连接次数相当，遍历开销可以忽略
+	for key, t := range s.tickets {
+		if now.After(t.ExpiresAt) {
+			delete(s.tickets, key)
+		}
+	}
+	s.tickets[ticket] = Ticket{UserID: userID, SessionID: sessionID, ExpiresAt: now.Add(s.ttl)}
+	return ticket
+}
+
+// Redeem 使用一张票据，票据不存在、已使用或已过期时返回 false。
+func (s *TicketStore) Redeem(ticket string) (Ticket, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tickets[ticket]
+	if !ok {
+		return Ticket{}, false
+	}
+	delete(s.tickets, ticket)
+	if time.Now().After(t.ExpiresAt) {
+		return Ticket{}, false
+	}
+	return t, true
+}
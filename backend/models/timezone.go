@@ -0,0 +1,62 @@
+package models
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultLocation 是没有设置时区的用户统计发射数据时使用的时区，启动时按配置中的 default_timezone 设置。
+var DefaultLocation = time.Local
+
+// locations 缓存已加载的时区，避免每次统计都重新解析时区数据库。
+var locations sync.Map
+
+// maxTimezoneLength 是时区名称的最大长度，与数据库中 timezone 字段的长度一致。
+const maxTimezoneLength = 64
+
+// ValidateTimezone 检查 name 是否是可以由用户设置的 IANA 时区名，如 "Asia/Shanghai" 或 "UTC"。
+// 含义取决于服务器环境的 "Local" 不被接受。
+func ValidateTimezone(name string) error {
+	if name == "" || name == "Local" || len(name) > maxTimezoneLength {
+		return fmt.Errorf("无效的时区: %q", name)
+	}
+	_, err := loadLocation(name)
+	return err
+}
+
+// UserLocation 返回按名称 name 统计发射数据使用的时区，name 为空或无法加载时返回 DefaultLocation。
+func UserLocation(name string) *time.Location {
+	if name == "" {
+		return DefaultLocation
+	}
+	loc, err := loadLocation(name)
+	if err != nil {
+		return DefaultLocation
+	}
+	return loc
+}
+
+// Location 返回统计该事件使用的时区：事件记录了时区时使用事件自己的时区，否则使用用户的时区 userLoc。
+func (e LaunchEvent) Location(userLoc *time.Location) *time.Location {
+	if e.Timezone == "" {
+		return userLoc
+	}
+	loc, err := loadLocation(e.Timezone)
+	if err != nil {
+		return userLoc
+	}
+	return loc
+}
+
+func loadLocation(name string) (*time.Location, error) {
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("无效的时区: %q", name)
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
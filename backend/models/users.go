@@ -1,11 +1,8 @@
 package models
 
 import (
-	"crypto/rand"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"os"
 	"sync"
 	"time"
 	"log"
@@ -15,6 +12,10 @@ import (
 
 type Config struct {
 	ServerPort    int      `json:"server_port"`
+	// 存储后端类型: mysql（默认）、sqlite 或 memory
+	DBDriver      string   `json:"db_driver"`
+	// SQLite 数据库文件路径，仅在 db_driver 为 sqlite 时使用
+	DBPath        string   `json:"db_path"`
 	DBHost        string   `json:"db_host"`
 	DBPort        int      `json:"db_port"`
 	DBUser        string   `json:"db_user"`
@@ -22,12 +23,253 @@ type Config struct {
 	DBName        string   `json:"db_name"`
 	JWTSecretKey  string   `json:"jwt_secret_key"`
 	Env           string   `json:"env"`
+	// 允许客户端时钟比服务器超前的秒数，超过该范围的未来日期会被拒绝，0 表示使用默认值
+	MaxClockSkewSeconds int `json:"max_clock_skew_seconds"`
+	// 访问令牌有效期（分钟），0 表示使用默认值
+	AccessTokenTTLMinutes int `json:"access_token_ttl_minutes"`
+	// 刷新令牌有效期（天），0 表示使用默认值
+	RefreshTokenTTLDays int `json:"refresh_token_ttl_days"`
+	// 为 true 时关闭 POST /auth/register，只能通过命令行创建账号
+	DisableRegistration bool `json:"disable_registration"`
+	// 为 true 时 POST /sync 拒绝不带 revision 的快照。默认仍接受以兼容尚未升级的客户端，
+	// 但服务端无法发现基于过期数据的提交，两台设备的并发修改可能丢失；之后的版本会改为默认拒绝
+	RequireSyncRevision bool `json:"require_sync_revision"`
+	// 每个 IP 每分钟允许的请求数及突发量，0 表示使用默认值，负数表示不限速
+	RateLimitPerMinute int `json:"rate_limit_per_minute"`
+	RateLimitBurst     int `json:"rate_limit_burst"`
+	// 登录和注册接口按 IP、按用户名分别计算的限速，取值规则同上；按用户名的限速只计算登录失败的尝试
+	LoginRateLimitPerMinute int `json:"login_rate_limit_per_minute"`
+	LoginRateLimitBurst     int `json:"login_rate_limit_burst"`
+	// 每个 WebSocket 连接每分钟允许发送的 launch 消息数及突发量，取值规则同上
+	WSLaunchRateLimitPerMinute int `json:"ws_launch_rate_limit_per_minute"`
+	WSLaunchRateLimitBurst     int `json:"ws_launch_rate_limit_burst"`
+	// 连续登录失败多少次后临时锁定账号，0 表示使用默认值，负数表示不锁定。
+	// 账号曾成功登录过的 IP 在刷新令牌有效期内不受锁定影响
+	LoginMaxFailures int `json:"login_max_failures"`
+	// 账号锁定时长（分钟），0 表示使用默认值
+	LoginLockoutMinutes int `json:"login_lockout_minutes"`
+	// 为 true 时注册 /debug 诊断接口，默认关闭
+	DebugEnabled bool `json:"debug_enabled"`
+	// 从非本机访问诊断接口时需要在 X-Admin-Token 头中提供的令牌，为空时只允许本机访问
+	DebugAdminToken string `json:"debug_admin_token"`
+	// 服务端向 WebSocket 客户端发送 ping 的间隔（秒），0 表示使用默认值
+	WSPingIntervalSeconds int `json:"ws_ping_interval_seconds"`
+	// 等待客户端 pong 或任意消息的最长时间（秒），超时的连接会被注销，0 表示使用默认值
+	WSPongTimeoutSeconds int `json:"ws_pong_timeout_seconds"`
+	// 单次写入 WebSocket 消息的超时时间（秒），0 表示使用默认值
+	WSWriteTimeoutSeconds int `json:"ws_write_timeout_seconds"`
+	// 客户端单条消息的最大字节数，0 表示使用默认值
+	WSMaxMessageBytes int64 `json:"ws_max_message_bytes"`
+	// 每个用户保留的增量消息条数，断线重连的客户端可以据此补发错过的更新，0 表示使用默认值
+	WSReplayBufferSize int `json:"ws_replay_buffer_size"`
+	// 允许建立 WebSocket 连接的浏览器来源，如 "https://app.example.com"，"*" 表示允许任意来源。
+	// 不携带 Origin 头的原生客户端和同源页面总是允许
+	WSAllowedOrigins []string `json:"ws_allowed_origins"`
+	// WebSocket 连接票据的有效期（秒），0 表示使用默认值
+	WSTicketTTLSeconds int `json:"ws_ticket_ttl_seconds"`
+	// 为 true 时不再接受 /ws?token= 形式的访问令牌，只接受票据或 Sec-WebSocket-Protocol 中的令牌。
+	// 默认仍接受以兼容尚未升级的客户端，每次使用都会记录废弃警告
+	WSDisableQueryToken bool `json:"ws_disable_query_token"`
+	// 访问令牌的签发者（iss）和受众（aud），校验时必须与配置一致，为空表示使用默认值
+	JWTIssuer   string `json:"jwt_issuer"`
+	JWTAudience string `json:"jwt_audience"`
+	// 密钥环文件路径，保存签名密钥及其轮换状态，为空表示使用默认路径。
+	// 文件不存在时由 jwt_secret_key 导入一把 HS256 密钥
+	JWTKeyringPath string `json:"jwt_keyring_path"`
+	// 新密钥使用的签名算法，可选 HS256、EdDSA、RS256，为空表示 HS256
+	JWTAlgorithm string `json:"jwt_algorithm"`
+	// 停止服务时等待进行中的请求完成、WebSocket 客户端断开的最长时间（秒），0 表示使用默认值
+	ShutdownTimeoutSeconds int `json:"shutdown_timeout_seconds"`
+	// 没有设置时区的用户统计发射数据时使用的 IANA 时区，为空表示服务器本地时区
+	DefaultTimezone string `json:"default_timezone"`
+}
+
+// MaxClockSkew 返回校验客户端时间时允许的最大时钟偏差。
+func (c *Config) MaxClockSkew() time.Duration {
+	if c.MaxClockSkewSeconds <= 0 {
+		return DefaultMaxClockSkew
+	}
+	return time.Duration(c.MaxClockSkewSeconds) * time.Second
+}
+
+// AccessTokenTTL 返回访问令牌的有效期。
+func (c *Config) AccessTokenTTL() time.Duration {
+	if c.AccessTokenTTLMinutes <= 0 {
+		return DefaultAccessTokenTTL
+	}
+	return time.Duration(c.AccessTokenTTLMinutes) * time.Minute
+}
+
+// RefreshTokenTTL 返回刷新令牌的有效期。
+func (c *Config) RefreshTokenTTL() time.Duration {
+	if c.RefreshTokenTTLDays <= 0 {
+		return DefaultRefreshTokenTTL
+	}
+	return time.Duration(c.RefreshTokenTTLDays) * 24 * time.Hour
+}
+
+// RateLimit 返回全局按 IP 限速的每分钟请求数和突发量，每分钟请求数为 0 时表示不限速。
+func (c *Config) RateLimit() (perMinute, burst int) {
+	return rateLimitOrDefault(c.RateLimitPerMinute, c.RateLimitBurst, DefaultRateLimitPerMinute, DefaultRateLimitBurst)
+}
+
+// LoginRateLimit 返回登录和注册接口的每分钟请求数和突发量，每分钟请求数为 0 时表示不限速。
+func (c *Config) LoginRateLimit() (perMinute, burst int) {
+	return rateLimitOrDefault(c.LoginRateLimitPerMinute, c.LoginRateLimitBurst, DefaultLoginRateLimitPerMinute, DefaultLoginRateLimitBurst)
+}
+
+// WSLaunchRateLimit 返回每个 WebSocket 连接发送 launch 消息的每分钟次数和突发量，每分钟次数为 0 时表示不限速。
+func (c *Config) WSLaunchRateLimit() (perMinute, burst int) {
+	return rateLimitOrDefault(c.WSLaunchRateLimitPerMinute, c.WSLaunchRateLimitBurst, DefaultWSLaunchRateLimitPerMinute, DefaultWSLaunchRateLimitBurst)
+}
+
+func rateLimitOrDefault(perMinute, burst, defaultPerMinute, defaultBurst int) (int, int) {
+	if perMinute < 0 {
+		return 0, 0
+	}
+	if perMinute == 0 {
+		perMinute = defaultPerMinute
+	}
+	if burst <= 0 {
+		burst = defaultBurst
+	}
+	return perMinute, burst
+}
+
+// MaxLoginFailures 返回锁定账号前允许的连续登录失败次数，返回 0 表示不锁定。
+func (c *Config) MaxLoginFailures() int {
+	if c.LoginMaxFailures < 0 {
+		return 0
+	}
+	if c.LoginMaxFailures == 0 {
+		return DefaultLoginMaxFailures
+	}
+	return c.LoginMaxFailures
+}
+
+// LoginLockout 返回账号因登录失败被锁定的时长。
+func (c *Config) LoginLockout() time.Duration {
+	if c.LoginLockoutMinutes <= 0 {
+		return DefaultLoginLockout
+	}
+	return time.Duration(c.LoginLockoutMinutes) * time.Minute
+}
+
+// WebSocket 返回 WebSocket 连接的心跳和超时设置。
+// pong 等待时间必须大于 ping 间隔，否则正常的连接也会在两次 ping 之间超时，此时将其调整为 ping 间隔的两倍。
+func (c *Config) WebSocket() WSSettings {
+	settings := WSSettings{
+		PingInterval:   DefaultWSPingInterval,
+		PongWait:       DefaultWSPongWait,
+		WriteWait:      DefaultWSWriteWait,
+		MaxMessageSize: DefaultWSMaxMessageSize,
+	}
+	if c.WSPingIntervalSeconds > 0 {
+		settings.PingInterval = time.Duration(c.WSPingIntervalSeconds) * time.Second
+	}
+	if c.WSPongTimeoutSeconds > 0 {
+		settings.PongWait = time.Duration(c.WSPongTimeoutSeconds) * time.Second
+	}
+	if c.WSWriteTimeoutSeconds > 0 {
+		settings.WriteWait = time.Duration(c.WSWriteTimeoutSeconds) * time.Second
+	}
+	if c.WSMaxMessageBytes > 0 {
+		settings.MaxMessageSize = c.WSMaxMessageBytes
+	}
+	if settings.PongWait <= settings.PingInterval {
+		settings.PongWait = 2 * settings.PingInterval
+	}
+	return settings
+}
+
+const (
+	// DefaultJWTIssuer 是访问令牌默认的签发者。
+	DefaultJWTIssuer = "launchcounter"
+	// DefaultJWTAudience 是访问令牌默认的受众。
+	DefaultJWTAudience = "launchcounter-app"
+)
+
+// Timezone 返回没有设置时区的用户使用的时区。
+func (c *Config) Timezone() *time.Location {
+	if c.DefaultTimezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(c.DefaultTimezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// DefaultShutdownTimeout 是停止服务时默认的最长等待时间。
+const DefaultShutdownTimeout = 15 * time.Second
+
+// ShutdownTimeout 返回停止服务时的最长等待时间。
+func (c *Config) ShutdownTimeout() time.Duration {
+	if c.ShutdownTimeoutSeconds <= 0 {
+		return DefaultShutdownTimeout
+	}
+	return time.Duration(c.ShutdownTimeoutSeconds) * time.Second
+}
+
+// DefaultJWTKeyringPath 是密钥环文件的默认路径。
+const DefaultJWTKeyringPath = "config/jwt_keys.json"
+
+// KeyringPath 返回密钥环文件的路径。
+func (c *Config) KeyringPath() string {
+	if c.JWTKeyringPath == "" {
+		return DefaultJWTKeyringPath
+	}
+	return c.JWTKeyringPath
+}
+
+// KeyRetention 返回密钥退役后继续用于校验的时长：访问令牌有效期加上允许的时钟偏差，
+// 保证退役前签发的令牌都能用到自然过期。
+func (c *Config) KeyRetention() time.Duration {
+	return c.AccessTokenTTL() + c.MaxClockSkew()
+}
+
+// TokenIssuer 返回访问令牌的签发者。
+func (c *Config) TokenIssuer() string {
+	if c.JWTIssuer == "" {
+		return DefaultJWTIssuer
+	}
+	return c.JWTIssuer
+}
+
+// TokenAudience 返回访问令牌的受众。
+func (c *Config) TokenAudience() string {
+	if c.JWTAudience == "" {
+		return DefaultJWTAudience
+	}
+	return c.JWTAudience
+}
+
+// WSTicketTTL 返回 WebSocket 连接票据的有效期。
+func (c *Config) WSTicketTTL() time.Duration {
+	if c.WSTicketTTLSeconds <= 0 {
+		return DefaultWSTicketTTL
+	}
+	return time.Duration(c.WSTicketTTLSeconds) * time.Second
+}
+
+// ReplayBufferSize 返回每个用户保留的增量消息条数，超过上限时按上限计算。
+func (c *Config) ReplayBufferSize() int {
+	switch {
+	case c.WSReplayBufferSize <= 0:
+		return DefaultReplayBufferSize
+	case c.WSReplayBufferSize > MaxReplayBufferSize:
+		return MaxReplayBufferSize
+	}
+	return c.WSReplayBufferSize
 }
 
 type User struct {
 	ID       int    `json:"id"`
 	Username string `json:"username"`
 	Password string `json:"-"`
+	// 统计发射数据使用的 IANA 时区，为空表示使用服务器的默认时区
+	Timezone string `json:"timezone"`
 }
 
 type LaunchData struct {
@@ -37,6 +279,8 @@ type LaunchData struct {
 	MonthData  map[string]int  `json:"month_data"`
 	DayData    map[string]int  `json:"day_data"`
 	LastLaunch time.Time       `json:"last_launch"`
+	Revision   int64           `json:"revision"` // 修订号，数据每次变化时递增，用于乐观并发控制
+	Timezone   string          `json:"timezone"` // 划分年/月/日统计所用的时区
 }
 
 type Client struct {
@@ -45,54 +289,35 @@ type Client struct {
 	Username   string
 	IP         string
 	ConnectAt  time.Time
-	Send       chan LaunchData
+	SessionID  string // 建立连接所用令牌对应的会话 ID
+	Protocol   string // 握手时协商的子协议，为空表示只接收快照的旧版客户端
+	Settings   WSSettings // 心跳和超时设置，零值表示不启用对应的机制
+	Send       chan Envelope // 发送队列，永远不会被关闭，关闭客户端请使用 Close
+
+	done      chan struct{}
+	closeOnce sync.Once
+	// closeCode 和 closeText 是关闭时发送给客户端的关闭帧内容，在 done 关闭之前写入
+	closeCode int
+	closeText string
 }
 
-var (
-	Clients     = make(map[int][]*Client)
-	ClientsLock sync.RWMutex
+const (
+	// DefaultWSPingInterval 是服务端发送 ping 的默认间隔。
+	DefaultWSPingInterval = 30 * time.Second
+	// DefaultWSPongWait 是默认的读超时：超过该时间没有收到 pong 或任何消息即认为连接已断开。
+	DefaultWSPongWait = 75 * time.Second
+	// DefaultWSWriteWait 是单次写入的默认超时。
+	DefaultWSWriteWait = 10 * time.Second
+	// DefaultWSMaxMessageSize 是客户端单条消息的默认上限。
+	DefaultWSMaxMessageSize = 64 * 1024
 )
 
-// 加载配置（如果JWT密钥为空则生成）
-// LoadConfig 函数用于从指定文件加载配置信息到 Config 结构体中。
-// 如果配置文件中 JWT 密钥为空，会生成一个新的密钥，并将更新后的配置保存回文件。
-// 参数 filename 是配置文件的路径，config 是指向 Config 结构体的指针，用于存储配置信息。
-func LoadConfig(filename string, config *Config) {
-	// 尝试读取现有配置文件
-	// 使用 os.Open 打开指定的配置文件，如果文件存在且打开成功，则继续处理
-	if file, err := os.Open(filename); err == nil {
-		// 确保文件在函数结束时关闭，避免资源泄漏
-		defer file.Close()
-		// 使用 json.NewDecoder 从文件中解码 JSON 数据到 config 结构体
-		// 若解码失败，config 会保留默认值
-		json.NewDecoder(file).Decode(config)
-	}
-
-	// 检查 JWT 密钥是否为空
-	// 如果 JWT 密钥为空字符串，需要生成一个新的密钥以保证安全性
-	if config.JWTSecretKey == "" {
-		// 生成一个 32 字节的随机密钥
-		// 使用 crypto/rand 包生成安全的随机字节序列，适用于加密场景
-		key := make([]byte, 32)
-		if _, err := rand.Read(key); err != nil {
-			// 若随机字节生成失败，抛出恐慌并记录错误信息
-			// 因为 JWT 密钥生成失败会影响系统的认证功能，程序无法继续安全运行
-			panic("无法生成JWT密钥: " + err.Error())
-		}
-		// 将生成的随机字节序列使用 Base64 标准编码转换为字符串
-		// 方便存储和传输
-		config.JWTSecretKey = base64.StdEncoding.EncodeToString(key)
-	}
-
-	// 保存配置信息到文件
-	// 使用 os.Create 创建或覆盖指定的配置文件，如果创建成功，则继续处理
-	if file, err := os.Create(filename); err == nil {
-		// 确保文件在函数结束时关闭，避免资源泄漏
-		defer file.Close()
-		// 使用 json.NewEncoder 将 config 结构体编码为 JSON 数据并写入文件
-		// 若写入失败，可能需要手动检查文件权限等问题
-		json.NewEncoder(file).Encode(config)
-	}
+// WSSettings 是单个 WebSocket 连接的心跳和超时设置。
+type WSSettings struct {
+	PingInterval   time.Duration
+	PongWait       time.Duration
+	WriteWait      time.Duration
+	MaxMessageSize int64
 }
 
 // 获取在线客户端信息
@@ -100,15 +325,11 @@ func LoadConfig(filename string, config *Config) {
 // 该函数会返回一个映射，键为格式化后的用户 ID（格式为 "user_<用户ID>"），
 // 值为该用户对应的客户端信息列表。每个客户端信息包含 IP 地址、连接时间和连接时长。
 func GetOnlineClients() map[string][]map[string]interface{} {
-	// 加读锁，防止在读取 Clients 时被其他协程修改，保证并发安全
-	ClientsLock.RLock()
-	// 函数结束时释放读锁，确保资源正确释放
-	defer ClientsLock.RUnlock()
-
 	// 初始化结果映射，用于存储最终的客户端信息
 	result := make(map[string][]map[string]interface{})
-	// 遍历 Clients 映射，其中 key 为用户 ID，value 为该用户对应的客户端列表
-	for userID, clients := range Clients {
+	// 遍历在线用户，逐个读取该用户的客户端列表副本，避免长时间持有注册表的锁
+	for userID := range Hub.Counts() {
+		clients := Hub.UserClients(userID)
 		// 初始化该用户的客户端信息列表
 		userClients := make([]map[string]interface{}, 0)
 		// 遍历该用户的客户端列表
@@ -128,56 +349,159 @@ func GetOnlineClients() map[string][]map[string]interface{} {
 	return result
 }
 
-// WritePump 是 Client 结构体的方法，用于持续从 Client 的 Send 通道读取数据，
-// 并将数据以 JSON 格式通过 WebSocket 连接发送给客户端。
-// 当通道关闭或发送过程中出现错误时，会关闭 WebSocket 连接。
+// DisconnectClients 断开指定用户的 WebSocket 连接，用于会话被吊销后立即踢下线。
+// 参数 sessionID 为空时断开该用户的全部连接，否则只断开使用该会话登录的连接。
+// 参数 reason 会通过 session_revoked 消息告知客户端，写协程发送该消息后关闭连接；
+// 发送队列已满时直接关闭客户端。连接断开后 ReadPump 返回，随后由连接处理函数完成注销。
+// 返回断开的连接数量。
+func DisconnectClients(userID int, sessionID, reason string) int {
+	count := 0
+	Hub.ForUser(userID, func(client *Client) {
+		if sessionID == "" || client.SessionID == sessionID {
+			if !client.Enqueue(NewEnvelope(MessageSessionRevoked, "", SessionRevokedPayload{Reason: reason})) {
+				client.Close()
+			}
+			count++
+		}
+	})
+	return count
+}
+
+// NotifyUser 向指定用户的所有新协议客户端推送一条文字通知，返回成功放入发送队列的连接数量。
+// 参数 level 取值为 info、warning 或 error。
+func NotifyUser(userID int, level, message string) int {
+	env := NewEnvelope(MessageNotice, "", NoticePayload{Level: level, Message: message})
+	count := 0
+	Hub.ForUser(userID, func(client *Client) {
+		if client.Protocol == ProtocolV2 && client.Enqueue(env) {
+			count++
+		}
+	})
+	return count
+}
+
+// Enqueue 尝试将消息放入客户端的发送队列，队列已满或客户端已关闭时立即返回 false 而不阻塞。
+func (c *Client) Enqueue(env Envelope) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+	select {
+	case c.Send <- env:
+		return true
+	default:
+		return false
+	}
+}
+
+// WritePump 是 Client 结构体的方法，用于持续从 Client 的 Send 通道读取消息，
+// 并将消息以 JSON 格式通过 WebSocket 连接发送给客户端。
+// 客户端被关闭、发送过程中出现错误或发送完 session_revoked 消息后，会关闭 WebSocket 连接。
+// WritePump 是唯一关闭底层连接的地方，连接关闭后 ReadPump 随之返回。
 func (c *Client) WritePump() {
-	// 使用 defer 确保在函数退出时关闭 WebSocket 连接，避免资源泄漏
+	// 定时发送 ping，未配置间隔时使用一个永远不会触发的通道
+	var pings <-chan time.Time
+	if c.Settings.PingInterval > 0 {
+		ticker := time.NewTicker(c.Settings.PingInterval)
+		defer ticker.Stop()
+		pings = ticker.C
+	}
+
+	// 使用 defer 确保在函数退出时关闭 WebSocket 连接，避免资源泄漏；
+	// 因写入失败退出时同时将客户端标记为关闭，使后续的 Enqueue 立即失败
 	defer func() {
+		c.Close()
 		c.Conn.Close()
 	}()
 
-	// 进入无限循环，持续监听 Send 通道，等待数据发送
+	// 进入无限循环，持续监听 Send 通道，等待消息发送
 	for {
 		select {
-		// 从 c.Send 通道接收数据，ok 表示通道是否正常打开
-		case data, ok := <-c.Send:
-			// 检查通道是否已关闭
-			if !ok {
-				// 通道已关闭，发送 WebSocket 关闭消息告知客户端连接即将关闭
-				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
+		case <-pings:
+			// 发送 ping，客户端的 WebSocket 实现会自动回复 pong，由 ReadPump 延长读超时
+			c.setWriteDeadline()
+			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
-
-			// 序列化数据
-			// 将接收到的 LaunchData 类型数据使用 json.Marshal 方法转换为 JSON 字节切片
-			jsonData, err := json.Marshal(data)
-			if err != nil {
-				// 序列化失败，记录错误日志并跳过本次发送，继续等待下一次数据
-				log.Printf("序列化数据失败: %v", err)
-				continue
-			}
-
-			// 发送JSON数据
-			// 使用 WriteMessage 方法将 JSON 数据以文本消息的形式通过 WebSocket 连接发送给客户端
-			if err := c.Conn.WriteMessage(websocket.TextMessage, jsonData); err != nil {
+		case <-c.done:
+			// 客户端已关闭，发送 WebSocket 关闭消息告知客户端连接即将关闭
+			c.setWriteDeadline()
+			c.Conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(c.closeCode, c.closeText))
+			return
+		// 从 c.Send 通道接收消息
+		case env := <-c.Send:
+			c.setWriteDeadline()
+			if err := c.writeEnvelope(env); err != nil {
 				// 发送失败，记录错误日志并退出函数，结束写操作
 				log.Printf("发送消息失败: %v", err)
 				return
 			}
+
+			// 会话已被吊销，通知客户端后主动关闭连接
+			if env.Type == MessageSessionRevoked {
+				c.Conn.WriteMessage(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "session revoked"))
+				return
+			}
 		}
 	}
 }
 
+// setWriteDeadline 为下一次写入设置超时，避免写协程被不再读取数据的客户端永久阻塞。
+func (c *Client) setWriteDeadline() {
+	if c.Settings.WriteWait > 0 {
+		c.Conn.SetWriteDeadline(time.Now().Add(c.Settings.WriteWait))
+	}
+}
+
+// extendReadDeadline 在收到 pong 或任意消息后延长读超时。
+// 超过 PongWait 没有收到任何数据时 ReadMessage 返回超时错误，连接随之被注销，
+// 这样半开的移动端连接不会一直留在在线列表中。
+func (c *Client) extendReadDeadline() {
+	if c.Settings.PongWait > 0 {
+		c.Conn.SetReadDeadline(time.Now().Add(c.Settings.PongWait))
+	}
+}
+
+// writeEnvelope 按客户端协商的协议写出一条消息。
+// 旧版客户端只理解原始的 LaunchData JSON，因此只转发快照消息的负载，其余类型直接跳过。
+func (c *Client) writeEnvelope(env Envelope) error {
+	if c.Protocol != ProtocolV2 {
+		if env.Type != MessageSnapshot {
+			return nil
+		}
+		return c.Conn.WriteMessage(websocket.TextMessage, env.Payload)
+	}
+
+	// 序列化消息信封
+	jsonData, err := json.Marshal(env)
+	if err != nil {
+		// 序列化失败，记录错误日志并跳过本条消息
+		log.Printf("序列化数据失败: %v", err)
+		return nil
+	}
+	// 使用 WriteMessage 方法将 JSON 数据以文本消息的形式通过 WebSocket 连接发送给客户端
+	return c.Conn.WriteMessage(websocket.TextMessage, jsonData)
+}
+
 // 添加 WebSocket 读协程
 // ReadPump 是 Client 结构体的方法，用于持续从 WebSocket 连接读取客户端发送的消息。
-// 当读取过程中出现错误或者连接关闭时，会自动关闭 WebSocket 连接。
-// 由于本项目不需要处理来自客户端的消息，该方法仅关注错误处理。
-func (c *Client) ReadPump() {
-	// 使用 defer 确保在函数退出时关闭 WebSocket 连接，避免资源泄漏
-	defer func() {
-		c.Conn.Close()
-	}()
+// 当读取过程中出现错误或者连接关闭时返回，并关闭客户端，由写协程断开 WebSocket 连接。
+// 参数 handle 处理每条解析成功的消息信封；旧版客户端发送的消息会被忽略。
+func (c *Client) ReadPump(handle func(c *Client, env Envelope)) {
+	// 使用 defer 确保在函数退出时关闭客户端，写协程随后关闭 WebSocket 连接
+	defer c.Close()
+
+	// 限制单条消息大小，超出时 ReadMessage 返回错误并断开连接
+	if c.Settings.MaxMessageSize > 0 {
+		c.Conn.SetReadLimit(c.Settings.MaxMessageSize)
+	}
+	c.extendReadDeadline()
+	c.Conn.SetPongHandler(func(string) error {
+		c.extendReadDeadline()
+		return nil
+	})
 
 	// 进入无限循环，持续从 WebSocket 连接读取消息
 	for {
@@ -185,17 +509,28 @@ func (c *Client) ReadPump() {
 		// 第一个返回值是消息类型（如文本消息、二进制消息等），
 		// 第二个返回值是消息数据的字节切片，
 		// 第三个返回值是可能出现的错误。
-		// 由于本项目不需要处理消息内容，这里忽略前两个返回值
-		_, _, err := c.Conn.ReadMessage()
+		_, message, err := c.Conn.ReadMessage()
 		if err != nil {
 			// 判断是否为意外关闭错误，CloseGoingAway 表示客户端正常关闭，CloseAbnormalClosure 表示异常关闭
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				// 记录意外关闭的错误信息，方便后续排查问题
 				log.Printf("WebSocket错误: %v", err)
 			}
-			// 出现错误，跳出循环，结束读取操作
+			// 出现错误（包括心跳超时），跳出循环，结束读取操作
 			break
 		}
-		// 本项目不需要处理来自客户端的消息，继续下一次读取
+		// 客户端发送的任何消息都说明连接仍然存活
+		c.extendReadDeadline()
+
+		// 旧版客户端不会发送有意义的消息，继续下一次读取
+		if c.Protocol != ProtocolV2 || handle == nil {
+			continue
+		}
+		var env Envelope
+		if err := json.Unmarshal(message, &env); err != nil || env.Type == "" {
+			c.Enqueue(NewEnvelope(MessageError, "", ErrorPayload{Message: "无法解析的消息"}))
+			continue
+		}
+		handle(c, env)
 	}
-}
\ No newline at end of file
+}
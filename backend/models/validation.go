@@ -0,0 +1,188 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultMaxClockSkew 是未配置时允许的客户端时钟超前量。
+const DefaultMaxClockSkew = 5 * time.Minute
+
+// FieldError 描述单个字段的校验错误，Field 使用 "day_data[2024-3-5]" 形式定位具体的键。
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationErrors 是一组字段校验错误，实现了 error 接口。
+type ValidationErrors []FieldError
+
+func (v ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(v))
+	for _, e := range v {
+		msgs = append(msgs, e.Field+": "+e.Message)
+	}
+	return "数据校验失败: " + strings.Join(msgs, "; ")
+}
+
+// sorted 按字段名排序，使遍历映射产生的错误顺序稳定。
+func (v ValidationErrors) sorted() ValidationErrors {
+	sort.SliceStable(v, func(i, j int) bool { return v[i].Field < v[j].Field })
+	return v
+}
+
+func (v *ValidationErrors) add(field, format string, args ...interface{}) {
+	*v = append(*v, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// ValidateLaunchData 校验并规范化客户端提交的发射数据。
+// 规范化：补零的键（如 "2024-03-05"）会被转换为客户端使用的不补零格式，次数为 0 的键会被移除。
+// 校验：键必须是真实存在的年/月/日，次数不能为负，日期不能超过 now 加上 maxSkew，
+// 总数必须等于各年之和，每年必须等于该年各月之和，每月必须等于该月各日之和。
+// 参数 loc 是判断日期是否在未来时使用的时区。校验通过时返回 nil。
+func ValidateLaunchData(data *LaunchData, now time.Time, maxSkew time.Duration, loc *time.Location) ValidationErrors {
+	var errs ValidationErrors
+	limit := now.Add(maxSkew).In(loc)
+
+	if data.Total < 0 {
+		errs.add("total", "不能为负数")
+	}
+	if data.LastLaunch.After(limit) {
+		errs.add("last_launch", "不能晚于服务器当前时间")
+	}
+
+	data.YearData = normalizeBuckets(data.YearData, "year_data", 1, limit, loc, &errs)
+	data.MonthData = normalizeBuckets(data.MonthData, "month_data", 2, limit, loc, &errs)
+	data.DayData = normalizeBuckets(data.DayData, "day_data", 3, limit, loc, &errs)
+	// 键或次数本身有误时，一致性检查的结果没有意义
+	if len(errs) > 0 {
+		return errs.sorted()
+	}
+
+	yearSum := 0
+	for _, n := range data.YearData {
+		yearSum += n
+	}
+	if yearSum != data.Total {
+		errs.add("total", "总数 %d 与年度统计之和 %d 不一致", data.Total, yearSum)
+	}
+	checkRollup(data.YearData, data.MonthData, "year_data", &errs)
+	checkRollup(data.MonthData, data.DayData, "month_data", &errs)
+	if len(errs) > 0 {
+		return errs.sorted()
+	}
+	return nil
+}
+
+// normalizeBuckets 校验一组统计数据的键和次数，返回以规范格式为键的新映射。
+// 参数 parts 表示键的段数：1 为年，2 为年-月，3 为年-月-日。
+func normalizeBuckets(buckets map[string]int, name string, parts int, limit time.Time, loc *time.Location, errs *ValidationErrors) map[string]int {
+	normalized := make(map[string]int, len(buckets))
+	for key, n := range buckets {
+		field := fmt.Sprintf("%s[%s]", name, key)
+		start, ok := parseBucketKey(key, parts, loc)
+		if !ok {
+			errs.add(field, "无效的键")
+			continue
+		}
+		if n < 0 {
+			errs.add(field, "次数不能为负数")
+			continue
+		}
+		if n == 0 {
+			continue
+		}
+		if start.After(limit) {
+			errs.add(field, "日期不能晚于服务器当前时间")
+			continue
+		}
+		// "2024-03" 与 "2024-3" 规范化后是同一个键，次数合并
+		normalized[bucketKey(start, parts)] += n
+	}
+	return normalized
+}
+
+// checkRollup 检查上一级统计中的每个键都等于下一级中属于它的键的次数之和。
+func checkRollup(parents, children map[string]int, name string, errs *ValidationErrors) {
+	sums := make(map[string]int, len(parents))
+	for key, n := range children {
+		parent := key[:strings.LastIndex(key, "-")]
+		sums[parent] += n
+	}
+	for key, n := range parents {
+		if sums[key] != n {
+			errs.add(fmt.Sprintf("%s[%s]", name, key), "次数 %d 与下级统计之和 %d 不一致", n, sums[key])
+		}
+	}
+	// 下级统计中存在、上级统计中缺失的键
+	for key, n := range sums {
+		if _, ok := parents[key]; !ok {
+			errs.add(fmt.Sprintf("%s[%s]", name, key), "次数 0 与下级统计之和 %d 不一致", n)
+		}
+	}
+}
+
+// parseBucketKey 解析年、年-月或年-月-日形式的键，返回该时间段的起始时间。
+// 月份和日期可以补零，但必须是真实存在的日期。
+func parseBucketKey(key string, parts int, loc *time.Location) (time.Time, bool) {
+	fields := strings.Split(key, "-")
+	if len(fields) != parts {
+		return time.Time{}, false
+	}
+	nums := []int{0, 1, 1}
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 0 || len(f) > 4 {
+			return time.Time{}, false
+		}
+		nums[i] = n
+	}
+	if nums[0] < 1970 || nums[1] < 1 || nums[1] > 12 {
+		return time.Time{}, false
+	}
+	t := time.Date(nums[0], time.Month(nums[1]), nums[2], 0, 0, 0, 0, loc)
+	if t.Day() != nums[2] {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// bucketKey 按段数生成规范格式的统计键。
+func bucketKey(t time.Time, parts int) string {
+	switch parts {
+	case 1:
+		return YearKey(t)
+	case 2:
+		return MonthKey(t)
+	default:
+		return DayKey(t)
+	}
+}
+
+// ValidateLaunchEvents 校验增量同步提交的发射事件。
+// 事件 ID 必须非空且不超过 64 个字符，发射时间必须存在且不能超过 now 加上 maxSkew，
+// 时区可以为空，否则必须是合法的 IANA 时区名。
+func ValidateLaunchEvents(events []LaunchEvent, now time.Time, maxSkew time.Duration) ValidationErrors {
+	var errs ValidationErrors
+	limit := now.Add(maxSkew)
+	for i, e := range events {
+		if e.EventID == "" || len(e.EventID) > 64 {
+			errs.add(fmt.Sprintf("events[%d].event_id", i), "必须为 1 到 64 个字符")
+		}
+		if e.LaunchedAt.IsZero() {
+			errs.add(fmt.Sprintf("events[%d].launched_at", i), "不能为空")
+		} else if e.LaunchedAt.After(limit) {
+			errs.add(fmt.Sprintf("events[%d].launched_at", i), "不能晚于服务器当前时间")
+		}
+		if e.Timezone != "" && ValidateTimezone(e.Timezone) != nil {
+			errs.add(fmt.Sprintf("events[%d].timezone", i), "无效的时区")
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
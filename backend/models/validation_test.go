@@ -0,0 +1,153 @@
+package models
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestValidateLaunchData(t *testing.T) {
+	loc := time.FixedZone("UTC+8", 8*3600)
+	now := time.Date(2024, 3, 5, 12, 0, 0, 0, loc)
+
+	tests := []struct {
+		name   string
+		data   LaunchData
+		fields []string
+	}{
+		{
+			name: "一致的数据",
+			data: LaunchData{
+				Total:     3,
+				YearData:  map[string]int{"2024": 3},
+				MonthData: map[string]int{"2024-2": 1, "2024-3": 2},
+				DayData:   map[string]int{"2024-2-29": 1, "2024-3-5": 2},
+			},
+		},
+		{
+			name: "总数与年度统计不一致",
+			data: LaunchData{
+				Total:     4,
+				YearData:  map[string]int{"2024": 3},
+				MonthData: map[string]int{"2024-3": 3},
+				DayData:   map[string]int{"2024-3-5": 3},
+			},
+			fields: []string{"total"},
+		},
+		{
+			name: "月度统计与日统计不一致",
+			data: LaunchData{
+				Total:     3,
+				YearData:  map[string]int{"2024": 3},
+				MonthData: map[string]int{"2024-3": 3},
+				DayData:   map[string]int{"2024-3-4": 1, "2024-3-5": 1},
+			},
+			fields: []string{"month_data[2024-3]"},
+		},
+		{
+			name: "键只出现在下级统计中",
+			data: LaunchData{
+				Total:     2,
+				YearData:  map[string]int{"2024": 2},
+				MonthData: map[string]int{"2024-3": 1},
+				DayData:   map[string]int{"2024-3-5": 1, "2023-12-31": 1},
+			},
+			fields: []string{"month_data[2023-12]", "year_data[2024]"},
+		},
+		{
+			name: "不存在的日期",
+			data: LaunchData{
+				Total:     1,
+				YearData:  map[string]int{"2024": 1},
+				MonthData: map[string]int{"2024-2": 1},
+				DayData:   map[string]int{"2024-2-30": 1},
+			},
+			fields: []string{"day_data[2024-2-30]"},
+		},
+		{
+			name: "格式错误的键和负数",
+			data: LaunchData{
+				Total:     -1,
+				YearData:  map[string]int{"24": 1},
+				MonthData: map[string]int{"2024-13": 1, "2024-3": -1},
+				DayData:   map[string]int{"2024-3": 1},
+			},
+			fields: []string{"day_data[2024-3]", "month_data[2024-13]", "month_data[2024-3]", "total", "year_data[24]"},
+		},
+		{
+			name: "未来的日期",
+			data: LaunchData{
+				Total:      1,
+				YearData:   map[string]int{"2024": 1},
+				MonthData:  map[string]int{"2024-3": 1},
+				DayData:    map[string]int{"2024-3-6": 1},
+				LastLaunch: now.Add(time.Hour),
+			},
+			fields: []string{"day_data[2024-3-6]", "last_launch"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := ValidateLaunchData(&tt.data, now, DefaultMaxClockSkew, loc)
+			var fields []string
+			for _, e := range errs {
+				fields = append(fields, e.Field)
+			}
+			sort.Strings(fields)
+			if !reflect.DeepEqual(fields, tt.fields) {
+				t.Errorf("出错的字段 %v，期望 %v（%v）", fields, tt.fields, errs)
+			}
+		})
+	}
+}
+
+func TestValidateLaunchDataNormalizes(t *testing.T) {
+	loc := time.UTC
+	data := LaunchData{
+		Total:     3,
+		YearData:  map[string]int{"2024": 3, "2023": 0},
+		MonthData: map[string]int{"2024-03": 1, "2024-3": 2},
+		DayData:   map[string]int{"2024-03-05": 3, "2024-3-6": 0},
+	}
+	if errs := ValidateLaunchData(&data, time.Date(2024, 3, 6, 0, 0, 0, 0, loc), 0, loc); errs != nil {
+		t.Fatalf("校验失败: %v", errs)
+	}
+	if want := map[string]int{"2024": 3}; !reflect.DeepEqual(data.YearData, want) {
+		t.Errorf("year_data %v，期望 %v", data.YearData, want)
+	}
+	if want := map[string]int{"2024-3": 3}; !reflect.DeepEqual(data.MonthData, want) {
+		t.Errorf("month_data %v，期望 %v", data.MonthData, want)
+	}
+	if want := map[string]int{"2024-3-5": 3}; !reflect.DeepEqual(data.DayData, want) {
+		t.Errorf("day_data %v，期望 %v", data.DayData, want)
+	}
+}
+
+func TestValidateLaunchEvents(t *testing.T) {
+	now := time.Date(2024, 3, 5, 12, 0, 0, 0, time.UTC)
+	skew := 5 * time.Minute
+	events := []LaunchEvent{
+		{EventID: "ok", LaunchedAt: now.Add(skew)},
+		{EventID: "future", LaunchedAt: now.Add(skew + time.Second)},
+		{EventID: "", LaunchedAt: now},
+		{EventID: "zero"},
+		{EventID: "tz", LaunchedAt: now, Timezone: "Mars/Olympus"},
+		{EventID: "tz-ok", LaunchedAt: now, Timezone: "Asia/Shanghai"},
+	}
+
+	errs := ValidateLaunchEvents(events, now, skew)
+	var fields []string
+	for _, e := range errs {
+		fields = append(fields, e.Field)
+	}
+	want := []string{"events[1].launched_at", "events[2].event_id", "events[3].launched_at", "events[4].timezone"}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("出错的字段 %v，期望 %v", fields, want)
+	}
+
+	if errs := ValidateLaunchEvents(events[:1], now, skew); errs != nil {
+		t.Errorf("合法的事件校验失败: %v", errs)
+	}
+}
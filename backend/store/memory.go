@@ -0,0 +1,393 @@
+package store
+
+import (
+	"backend/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryStore 是完全保存在内存中的 Store 实现，进程退出后数据丢失。
+// 用于本地试用和不依赖数据库的处理函数测试。
+type memoryStore struct {
+	mu     sync.Mutex
+	nextID int
+	users  map[int]models.User
+	data   map[int]models.LaunchData
+	events map[int][]models.LaunchEvent
+	// eventIDs 记录每个用户已存在的事件 ID，用于去重
+	eventIDs map[int]map[string]bool
+	sessions map[string]models.Session
+}
+
+// NewMemory 创建一个空的内存存储。
+func NewMemory() Store {
+	return &memoryStore{
+		nextID:   1,
+		users:    make(map[int]models.User),
+		data:     make(map[int]models.LaunchData),
+		events:   make(map[int][]models.LaunchEvent),
+		eventIDs: make(map[int]map[string]bool),
+		sessions: make(map[string]models.Session),
+	}
+}
+
+func (m *memoryStore) CreateUser(username, passwordHash string) (models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.users {
+		if u.Username == username {
+			return models.User{}, ErrUserExists
+		}
+	}
+	user := models.User{ID: m.nextID, Username: username, Password: passwordHash}
+	m.nextID++
+	m.users[user.ID] = user
+	m.data[user.ID] = emptyLaunchData(user.ID)
+	return user, nil
+}
+
+func (m *memoryStore) GetUserByID(id int) (models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return models.User{}, ErrNotFound
+	}
+	return user, nil
+}
+
+func (m *memoryStore) GetUserByUsername(username string) (models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.users {
+		if u.Username == username {
+			return u, nil
+		}
+	}
+	return models.User{}, ErrNotFound
+}
+
+func (m *memoryStore) ListUsers() ([]models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	users := make([]models.User, 0, len(m.users))
+	for _, u := range m.users {
+		users = append(users, models.User{ID: u.ID, Username: u.Username})
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
+
+func (m *memoryStore) DeleteUser(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.users, id)
+	delete(m.data, id)
+	delete(m.events, id)
+	delete(m.eventIDs, id)
+	for sid, session := range m.sessions {
+		if session.UserID == id {
+			delete(m.sessions, sid)
+		}
+	}
+	return nil
+}
+
+func (m *memoryStore) UpdatePassword(id int, passwordHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	user.Password = passwordHash
+	m.users[id] = user
+	m.revokeUserSessions(id)
+	return nil
+}
+
+func (m *memoryStore) CreateSession(session models.Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sessions[session.ID] = session
+	return nil
+}
+
+func (m *memoryStore) GetSession(id string) (models.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.sessions[id]
+	if !ok {
+		return models.Session{}, ErrNotFound
+	}
+	return session, nil
+}
+
+func (m *memoryStore) RotateSession(id, oldHash, newHash string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	session, ok := m.sessions[id]
+	if !ok || session.RefreshHash != oldHash || !session.Active(now) {
+		return ErrNotFound
+	}
+	session.RefreshHash = newHash
+	session.LastUsedAt = now
+	session.ExpiresAt = expiresAt
+	m.sessions[id] = session
+	return nil
+}
+
+func (m *memoryStore) RevokeSession(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.sessions[id]
+	if !ok || !session.RevokedAt.IsZero() {
+		return ErrNotFound
+	}
+	session.RevokedAt = time.Now()
+	m.sessions[id] = session
+	return nil
+}
+
+func (m *memoryStore) RevokeUserSessions(userID int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.revokeUserSessions(userID), nil
+}
+
+// revokeUserSessions 吊销用户的全部有效会话，调用方必须持有锁。
+func (m *memoryStore) revokeUserSessions(userID int) int {
+	now := time.Now()
+	count := 0
+	for id, session := range m.sessions {
+		if session.UserID == userID && session.RevokedAt.IsZero() {
+			session.RevokedAt = now
+			m.sessions[id] = session
+			count++
+		}
+	}
+	return count
+}
+
+func (m *memoryStore) GetLaunchData(userID int) (models.LaunchData, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.launchData(userID), nil
+}
+
+func (m *memoryStore) ListLaunchEvents(userID int) ([]models.LaunchEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]models.LaunchEvent(nil), m.events[userID]...), nil
+}
+
+func (m *memoryStore) ListLaunchEventsBetween(userID int, from, to time.Time) ([]models.LaunchEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var events []models.LaunchEvent
+	for _, e := range m.events[userID] {
+		if !e.LaunchedAt.Before(from) && e.LaunchedAt.Before(to) {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+func (m *memoryStore) AppendLaunchEvents(userID int, build EventBuilder) (models.LaunchData, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current := m.launchData(userID)
+	events, err := build(current)
+	if err != nil {
+		return current, 0, err
+	}
+
+	ids := m.eventIDs[userID]
+	if ids == nil {
+		ids = make(map[string]bool)
+		m.eventIDs[userID] = ids
+	}
+	inserted := 0
+	for _, e := range events {
+		if ids[e.EventID] {
+			continue
+		}
+		ids[e.EventID] = true
+		e.UserID = userID
+		m.events[userID] = append(m.events[userID], e)
+		inserted++
+	}
+	// 全部是重复事件时数据没有变化，不增加修订号
+	if inserted == 0 {
+		return current, 0, nil
+	}
+
+	// 与 SQL 实现保持一致，事件按发射时间排序
+	sort.SliceStable(m.events[userID], func(i, j int) bool {
+		return m.events[userID][i].LaunchedAt.Before(m.events[userID][j].LaunchedAt)
+	})
+	merged := m.rebuild(userID, current.Revision)
+	return merged, inserted, nil
+}
+
+func (m *memoryStore) MergeLaunchEvents(userID int, merge EventMerger) (models.LaunchData, models.LaunchData, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	before := m.launchData(userID)
+	add, remove, err := merge(append([]models.LaunchEvent(nil), m.events[userID]...))
+	if err != nil {
+		return before, before, err
+	}
+
+	ids := m.eventIDs[userID]
+	if ids == nil {
+		ids = make(map[string]bool)
+		m.eventIDs[userID] = ids
+	}
+	changed := 0
+	if len(remove) > 0 {
+		removed := make(map[string]bool, len(remove))
+		for _, id := range remove {
+			removed[id] = true
+		}
+		kept := m.events[userID][:0]
+		for _, e := range m.events[userID] {
+			if removed[e.EventID] {
+				delete(ids, e.EventID)
+				changed++
+				continue
+			}
+			kept = append(kept, e)
+		}
+		m.events[userID] = kept
+	}
+	for _, e := range add {
+		if ids[e.EventID] {
+			continue
+		}
+		ids[e.EventID] = true
+		e.UserID = userID
+		m.events[userID] = append(m.events[userID], e)
+		changed++
+	}
+	if changed == 0 {
+		return before, before, nil
+	}
+
+	sort.SliceStable(m.events[userID], func(i, j int) bool {
+		return m.events[userID][i].LaunchedAt.Before(m.events[userID][j].LaunchedAt)
+	})
+	return before, m.rebuild(userID, before.Revision), nil
+}
+
+func (m *memoryStore) SetUserTimezone(userID int, timezone string, rebucket bool) (models.LaunchData, models.LaunchData, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[userID]
+	if !ok {
+		return models.LaunchData{}, models.LaunchData{}, ErrNotFound
+	}
+	before := m.launchData(userID)
+	for i := range m.events[userID] {
+		e := &m.events[userID][i]
+		if rebucket {
+			e.Timezone = ""
+		} else if e.Timezone == "" {
+			// 与 SQL 实现一致，跟随用户时区的事件记录下修改前的时区
+			e.Timezone = before.Timezone
+		}
+	}
+	user.Timezone = timezone
+	m.users[userID] = user
+	return before, m.rebuild(userID, before.Revision), nil
+}
+
+// rebuild 根据事件重新计算用户的聚合数据，修订号设为 revision 加 1，返回数据的副本。调用方必须持有锁。
+func (m *memoryStore) rebuild(userID int, revision int64) models.LaunchData {
+	data := models.AggregateEvents(userID, m.events[userID], models.UserLocation(m.users[userID].Timezone))
+	data.Revision = revision + 1
+	m.data[userID] = data
+	return copyLaunchData(data)
+}
+
+// BackfillLaunchEvents 对内存存储没有意义：数据从一开始就以事件形式写入。
+func (m *memoryStore) BackfillLaunchEvents() (int, error) {
+	return 0, nil
+}
+
+// 内存存储没有表结构，迁移相关的方法都是空操作。
+func (m *memoryStore) MigrationStatus() ([]MigrationStatus, error) {
+	return nil, nil
+}
+
+func (m *memoryStore) MigrateUp(target int) (int, error) {
+	return 0, nil
+}
+
+func (m *memoryStore) MigrateDown(steps int) (int, error) {
+	return 0, nil
+}
+
+func (m *memoryStore) Close() error {
+	return nil
+}
+
+// launchData 返回用户聚合数据的副本，调用方必须持有锁。
+func (m *memoryStore) launchData(userID int) models.LaunchData {
+	data, ok := m.data[userID]
+	if !ok {
+		data = emptyLaunchData(userID)
+	}
+	// 用户修改时区后，没有事件的用户也应返回新的时区
+	data.Timezone = models.UserLocation(m.users[userID].Timezone).String()
+	return copyLaunchData(data)
+}
+
+// emptyLaunchData 返回修订号为 0 的空发射数据。
+func emptyLaunchData(userID int) models.LaunchData {
+	return models.LaunchData{
+		UserID:    userID,
+		YearData:  make(map[string]int),
+		MonthData: make(map[string]int),
+		DayData:   make(map[string]int),
+	}
+}
+
+// copyLaunchData 深拷贝发射数据，避免调用方修改存储内部的映射。
+func copyLaunchData(data models.LaunchData) models.LaunchData {
+	cp := data
+	cp.YearData = copyBuckets(data.YearData)
+	cp.MonthData = copyBuckets(data.MonthData)
+	cp.DayData = copyBuckets(data.DayData)
+	return cp
+}
+
+func copyBuckets(buckets map[string]int) map[string]int {
+	cp := make(map[string]int, len(buckets))
+	for k, v := range buckets {
+		cp[k] = v
+	}
+	return cp
+}
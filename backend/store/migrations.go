@@ -0,0 +1,160 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// Migration 是一次带编号的数据库结构变更。
+// Up 将结构升级到该版本，Down 撤销该版本的变更。
+// 注意 MySQL 的 DDL 语句会隐式提交事务，因此 Up/Down 应尽量保持幂等，
+// 以便在中途失败后可以安全地重新执行。
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *sql.Tx) error
+	Down    func(tx *sql.Tx) error
+}
+
+// MigrationStatus 描述一个迁移在当前数据库中的执行状态。
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// execStatements 返回一个依次执行多条 SQL 语句的迁移函数。
+func execStatements(statements ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, stmt := range statements {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// ensureMigrationsTable 创建记录已执行迁移的 schema_migrations 表。
+func (s *sqlStore) ensureMigrationsTable() error {
+	_, err := s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INT PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			applied_at DATETIME NOT NULL
+		)`)
+	return err
+}
+
+// appliedMigrations 返回已执行的迁移版本及其执行时间。
+func (s *sqlStore) appliedMigrations() (map[int]time.Time, error) {
+	if err := s.ensureMigrationsTable(); err != nil {
+		return nil, fmt.Errorf("创建迁移记录表失败: %w", err)
+	}
+	rows, err := s.db.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+func (s *sqlStore) MigrationStatus() ([]MigrationStatus, error) {
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	status := make([]MigrationStatus, 0, len(s.dialect.migrations))
+	for _, m := range s.dialect.migrations {
+		at, ok := applied[m.Version]
+		status = append(status, MigrationStatus{Version: m.Version, Name: m.Name, Applied: ok, AppliedAt: at})
+	}
+	return status, nil
+}
+
+func (s *sqlStore) MigrateUp(target int) (int, error) {
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, m := range s.dialect.migrations {
+		if target > 0 && m.Version > target {
+			break
+		}
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if err := s.runMigration(m, m.Up, true); err != nil {
+			return count, fmt.Errorf("执行迁移 %d (%s) 失败: %w", m.Version, m.Name, err)
+		}
+		log.Printf("已执行数据库迁移 %d: %s", m.Version, m.Name)
+		count++
+	}
+	return count, nil
+}
+
+func (s *sqlStore) MigrateDown(steps int) (int, error) {
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	// 从最新版本开始依次回滚
+	migrations := append([]Migration(nil), s.dialect.migrations...)
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version > migrations[j].Version })
+
+	count := 0
+	for _, m := range migrations {
+		if count >= steps {
+			break
+		}
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if err := s.runMigration(m, m.Down, false); err != nil {
+			return count, fmt.Errorf("回滚迁移 %d (%s) 失败: %w", m.Version, m.Name, err)
+		}
+		log.Printf("已回滚数据库迁移 %d: %s", m.Version, m.Name)
+		count++
+	}
+	return count, nil
+}
+
+// runMigration 在事务中执行迁移函数并更新 schema_migrations 中的记录。
+func (s *sqlStore) runMigration(m Migration, fn func(tx *sql.Tx) error, up bool) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if up {
+		_, err = tx.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+			m.Version, m.Name, time.Now().UTC())
+	} else {
+		_, err = tx.Exec("DELETE FROM schema_migrations WHERE version = ?", m.Version)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
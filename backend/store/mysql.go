@@ -0,0 +1,166 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// NewMySQL 连接 MySQL 数据库并验证连接有效性。
+// 表结构由迁移创建，调用方需要在使用前执行 MigrateUp。
+// 参数 dsn 是数据源名称，格式为 username:password@tcp(host:port)/database_name?parseTime=true，
+// parseTime=true 表示将数据库中的时间类型自动解析为 Go 的 time.Time 类型。
+func NewMySQL(dsn string) (Store, error) {
+	// sql.Open 只初始化连接池，不会立即建立实际的数据库连接
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("数据库连接失败: %w", err)
+	}
+	// 使用 Ping 与数据库建立实际连接，确保不会在无法连接数据库的情况下继续运行
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("数据库连接测试失败: %w", err)
+	}
+	db.SetConnMaxLifetime(time.Hour)
+	return &sqlStore{db: db, dialect: mysqlDialect}, nil
+}
+
+// mysqlDialect 描述 MySQL 的语法差异。
+var mysqlDialect = dialect{
+	insertIgnore: "INSERT IGNORE",
+	forUpdate:    " FOR UPDATE",
+	upsertLaunchData: `
+		INSERT INTO launch_data (user_id, total, year_data, month_data, day_data, last_launch, revision)
+		VALUES (?, ?, ?, ?, ?, ?, 1)
+		ON DUPLICATE KEY UPDATE
+			total = VALUES(total),
+			year_data = VALUES(year_data),
+			month_data = VALUES(month_data),
+			day_data = VALUES(day_data),
+			last_launch = VALUES(last_launch),
+			revision = revision + 1
+	`,
+	isDuplicate: func(err error) bool {
+		// 1062 是 MySQL 的唯一键冲突错误码
+		var mysqlErr *mysql.MySQLError
+		return errors.As(err, &mysqlErr) && mysqlErr.Number == 1062
+	},
+	migrations: mysqlMigrations,
+}
+
+// mysqlMigrations 是 MySQL 的数据库迁移列表，按版本号升序排列。
+// 前三个版本对应引入迁移机制之前由 CREATE TABLE IF NOT EXISTS 创建的结构，
+// 它们都是幂等的，已有部署首次执行时只会补充缺失的部分。
+var mysqlMigrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create_users_and_launch_data",
+		Up: execStatements(`
+			CREATE TABLE IF NOT EXISTS users (
+				-- 用户唯一标识，自增整数类型，作为主键，用于唯一标识每个用户
+				id INT AUTO_INCREMENT PRIMARY KEY,
+				-- 用户名，最大长度 50 个字符，唯一且不能为空，用于用户登录和识别
+				username VARCHAR(50) UNIQUE NOT NULL,
+				-- 用户密码的哈希值，最大长度 255 个字符，不能为空，用于安全存储用户密码
+				password_hash VARCHAR(255) NOT NULL
+			) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`, `
+			CREATE TABLE IF NOT EXISTS launch_data (
+				-- 用户 ID，作为主键，关联 users 表中的用户
+				user_id INT PRIMARY KEY,
+				-- 总发射次数，整数类型，不能为空，默认值为 0，记录用户的总发射次数
+				total INT NOT NULL DEFAULT 0,
+				-- 年度、月度和每日发射数据，JSON 类型
+				year_data JSON,
+				month_data JSON,
+				day_data JSON,
+				-- 最后一次发射时间，时间戳类型，可为空
+				last_launch TIMESTAMP NULL,
+				-- 外键约束，当用户记录删除时，级联删除此表中的相关记录
+				FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+			) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`),
+		Down: execStatements("DROP TABLE IF EXISTS launch_data", "DROP TABLE IF EXISTS users"),
+	},
+	{
+		Version: 2,
+		Name:    "create_launch_events",
+		Up: execStatements(`
+			CREATE TABLE IF NOT EXISTS launch_events (
+				-- 自增主键，同一时间的事件按写入顺序排列
+				id BIGINT AUTO_INCREMENT PRIMARY KEY,
+				user_id INT NOT NULL,
+				-- 客户端生成的事件 ID，同一用户下唯一，用于重试去重
+				event_id VARCHAR(64) NOT NULL,
+				device VARCHAR(100) NOT NULL DEFAULT '',
+				-- 发射时间，以 UTC 存储，精确到毫秒
+				launched_at DATETIME(3) NOT NULL,
+				UNIQUE KEY uniq_user_event (user_id, event_id),
+				INDEX idx_user_time (user_id, launched_at),
+				FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+			) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`),
+		Down: execStatements("DROP TABLE IF EXISTS launch_events"),
+	},
+	{
+		Version: 3,
+		Name:    "add_launch_data_revision",
+		Up: func(tx *sql.Tx) error {
+			return ensureMySQLColumn(tx, "launch_data", "revision", "BIGINT NOT NULL DEFAULT 0")
+		},
+		Down: execStatements("ALTER TABLE launch_data DROP COLUMN revision"),
+	},
+	{
+		Version: 4,
+		Name:    "create_sessions",
+		Up: execStatements(`
+			CREATE TABLE IF NOT EXISTS sessions (
+				-- 会话 ID，随机生成，写入访问令牌的 sid 声明
+				id VARCHAR(64) PRIMARY KEY,
+				user_id INT NOT NULL,
+				-- 当前刷新令牌的 SHA-256 哈希，每次刷新后更新
+				refresh_hash CHAR(64) NOT NULL,
+				user_agent VARCHAR(255) NOT NULL DEFAULT '',
+				ip VARCHAR(64) NOT NULL DEFAULT '',
+				created_at DATETIME NOT NULL,
+				last_used_at DATETIME NOT NULL,
+				expires_at DATETIME NOT NULL,
+				-- 吊销时间，为空表示会话有效
+				revoked_at DATETIME NULL,
+				INDEX idx_sessions_user (user_id),
+				FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+			) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`),
+		Down: execStatements("DROP TABLE IF EXISTS sessions"),
+	},
+	{
+		Version: 5,
+		Name:    "add_timezones",
+		Up: func(tx *sql.Tx) error {
+			// 用户的时区，为空表示使用服务器的默认时区
+			if err := ensureMySQLColumn(tx, "users", "timezone", "VARCHAR(64) NOT NULL DEFAULT ''"); err != nil {
+				return err
+			}
+			// 事件统计所用的时区，为空表示跟随用户的时区
+			return ensureMySQLColumn(tx, "launch_events", "timezone", "VARCHAR(64) NOT NULL DEFAULT ''")
+		},
+		Down: execStatements("ALTER TABLE launch_events DROP COLUMN timezone", "ALTER TABLE users DROP COLUMN timezone"),
+	},
+}
+
+// ensureMySQLColumn 检查指定表中是否存在某个字段，不存在时使用给定的定义添加该字段。
+// MySQL 不支持 ADD COLUMN IF NOT EXISTS，字段可能已由旧版本的建表逻辑创建。
+func ensureMySQLColumn(q dbExecutor, table, column, definition string) error {
+	var count int
+	err := q.QueryRow(`
+		SELECT COUNT(*) FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?
+	`, table, column).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	_, err = q.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
@@ -0,0 +1,89 @@
+package store
+
+import (
+	"backend/models"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+func (s *sqlStore) CreateSession(session models.Session) error {
+	_, err := s.db.Exec(`
+		INSERT INTO sessions (id, user_id, refresh_hash, user_agent, ip, created_at, last_used_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, session.ID, session.UserID, session.RefreshHash, truncate(session.UserAgent, 255), truncate(session.IP, 64),
+		session.CreatedAt.UTC(), session.LastUsedAt.UTC(), session.ExpiresAt.UTC())
+	return err
+}
+
+func (s *sqlStore) GetSession(id string) (models.Session, error) {
+	var session models.Session
+	var revokedAt sql.NullTime
+	err := s.db.QueryRow(`
+		SELECT id, user_id, refresh_hash, user_agent, ip, created_at, last_used_at, expires_at, revoked_at
+		FROM sessions WHERE id = ?
+	`, id).Scan(&session.ID, &session.UserID, &session.RefreshHash, &session.UserAgent, &session.IP,
+		&session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt, &revokedAt)
+	if err == sql.ErrNoRows {
+		return models.Session{}, ErrNotFound
+	}
+	if err != nil {
+		return models.Session{}, err
+	}
+	if revokedAt.Valid {
+		session.RevokedAt = revokedAt.Time
+	}
+	return session, nil
+}
+
+func (s *sqlStore) RotateSession(id, oldHash, newHash string, expiresAt time.Time) error {
+	// 以旧哈希作为更新条件，同一刷新令牌并发刷新时只有一个请求能成功
+	now := time.Now().UTC()
+	result, err := s.db.Exec(`
+		UPDATE sessions SET refresh_hash = ?, last_used_at = ?, expires_at = ?
+		WHERE id = ? AND refresh_hash = ? AND revoked_at IS NULL AND expires_at > ?
+	`, newHash, now, expiresAt.UTC(), id, oldHash, now)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqlStore) RevokeSession(id string) error {
+	result, err := s.db.Exec("UPDATE sessions SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL",
+		time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqlStore) RevokeUserSessions(userID int) (int, error) {
+	return revokeUserSessions(s.db, userID)
+}
+
+// revokeUserSessions 吊销用户的全部有效会话，可在事务中执行。
+func revokeUserSessions(q dbExecutor, userID int) (int, error) {
+	result, err := q.Exec("UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL",
+		time.Now().UTC(), userID)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := result.RowsAffected()
+	return int(n), nil
+}
+
+// truncate 将字符串截断到数据库字段允许的最大字节数，避免客户端提供的超长请求头导致写入失败。
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	// 截断可能落在多字节字符中间，去掉残缺的字节
+	return strings.ToValidUTF8(s[:max], "")
+}
@@ -0,0 +1,499 @@
+package store
+
+import (
+	"backend/models"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// dialect 描述不同 SQL 数据库之间的语法差异，其余查询由 sqlStore 共用。
+type dialect struct {
+	// insertIgnore 是遇到唯一键冲突时忽略该行的插入语句前缀
+	insertIgnore string
+	// forUpdate 是锁定查询结果行的后缀，不支持行锁的数据库为空
+	forUpdate string
+	// upsertLaunchData 写入聚合数据，已存在时更新并将修订号加 1，参数依次为
+	// user_id, total, year_data, month_data, day_data, last_launch
+	upsertLaunchData string
+	// isDuplicate 判断错误是否为唯一键冲突
+	isDuplicate func(err error) bool
+	// migrations 是该数据库的迁移列表，按版本号升序排列
+	migrations []Migration
+}
+
+// sqlStore 是基于 database/sql 的 Store 实现，MySQL 和 SQLite 共用。
+type sqlStore struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+// dbExecutor 抽象了 *sql.DB 和 *sql.Tx 的公共方法，
+// 使辅助函数既能直接执行，也能在事务中执行。
+type dbExecutor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func (s *sqlStore) CreateUser(username, passwordHash string) (models.User, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return models.User{}, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("INSERT INTO users (username, password_hash) VALUES (?, ?)", username, passwordHash)
+	if err != nil {
+		if s.dialect.isDuplicate(err) {
+			return models.User{}, ErrUserExists
+		}
+		return models.User{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return models.User{}, err
+	}
+	// 为新用户创建初始发射数据记录
+	_, err = tx.Exec(`
+		INSERT INTO launch_data (user_id, total, year_data, month_data, day_data, last_launch, revision)
+		VALUES (?, 0, '{}', '{}', '{}', NULL, 0)
+	`, id)
+	if err != nil {
+		return models.User{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return models.User{}, err
+	}
+	return models.User{ID: int(id), Username: username, Password: passwordHash}, nil
+}
+
+func (s *sqlStore) GetUserByID(id int) (models.User, error) {
+	return s.getUser("SELECT id, username, password_hash, timezone FROM users WHERE id = ?", id)
+}
+
+func (s *sqlStore) GetUserByUsername(username string) (models.User, error) {
+	return s.getUser("SELECT id, username, password_hash, timezone FROM users WHERE username = ?", username)
+}
+
+func (s *sqlStore) getUser(query string, arg interface{}) (models.User, error) {
+	var user models.User
+	err := s.db.QueryRow(query, arg).Scan(&user.ID, &user.Username, &user.Password, &user.Timezone)
+	if err == sql.ErrNoRows {
+		return models.User{}, ErrNotFound
+	}
+	return user, err
+}
+
+func (s *sqlStore) ListUsers() ([]models.User, error) {
+	rows, err := s.db.Query("SELECT id, username FROM users ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var user models.User
+		if err := rows.Scan(&user.ID, &user.Username); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func (s *sqlStore) DeleteUser(id int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// 显式删除关联数据，不依赖数据库是否启用了外键级联
+	for _, query := range []string{
+		"DELETE FROM sessions WHERE user_id = ?",
+		"DELETE FROM launch_events WHERE user_id = ?",
+		"DELETE FROM launch_data WHERE user_id = ?",
+	} {
+		if _, err := tx.Exec(query, id); err != nil {
+			return err
+		}
+	}
+	result, err := tx.Exec("DELETE FROM users WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) UpdatePassword(id int, passwordHash string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE users SET password_hash = ? WHERE id = ?", passwordHash, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	// 修改密码后旧密码登录产生的会话全部失效
+	if _, err := revokeUserSessions(tx, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) SetUserTimezone(userID int, timezone string, rebucket bool) (models.LaunchData, models.LaunchData, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return models.LaunchData{}, models.LaunchData{}, err
+	}
+	defer tx.Rollback()
+
+	// 锁定用户的聚合数据行，与 AppendLaunchEvents 互斥
+	before, err := s.loadLaunchData(tx, userID, true)
+	if err != nil {
+		return models.LaunchData{}, models.LaunchData{}, err
+	}
+	if _, err := s.userTimezone(tx, userID); err != nil {
+		return models.LaunchData{}, models.LaunchData{}, err
+	}
+
+	if rebucket {
+		// 清除事件各自记录的时区，全部跟随用户的新时区
+		_, err = tx.Exec("UPDATE launch_events SET timezone = '' WHERE user_id = ?", userID)
+	} else {
+		// 跟随用户时区的事件记录下修改前的时区，统计结果因此保持不变
+		_, err = tx.Exec("UPDATE launch_events SET timezone = ? WHERE user_id = ? AND timezone = ''", before.Timezone, userID)
+	}
+	if err != nil {
+		return models.LaunchData{}, models.LaunchData{}, err
+	}
+	if _, err := tx.Exec("UPDATE users SET timezone = ? WHERE id = ?", timezone, userID); err != nil {
+		return models.LaunchData{}, models.LaunchData{}, err
+	}
+
+	after, err := s.rebuildLaunchData(tx, userID)
+	if err != nil {
+		return models.LaunchData{}, models.LaunchData{}, err
+	}
+	return before, after, tx.Commit()
+}
+
+func (s *sqlStore) GetLaunchData(userID int) (models.LaunchData, error) {
+	return s.loadLaunchData(s.db, userID, false)
+}
+
+func (s *sqlStore) ListLaunchEvents(userID int) ([]models.LaunchEvent, error) {
+	return s.loadLaunchEvents(s.db, userID)
+}
+
+func (s *sqlStore) ListLaunchEventsBetween(userID int, from, to time.Time) ([]models.LaunchEvent, error) {
+	rows, err := s.db.Query(`
+		SELECT event_id, device, launched_at, timezone
+		FROM launch_events
+		WHERE user_id = ? AND launched_at >= ? AND launched_at < ?
+		ORDER BY launched_at, id
+	`, userID, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	return scanLaunchEvents(rows, userID)
+}
+
+func (s *sqlStore) AppendLaunchEvents(userID int, build EventBuilder) (models.LaunchData, int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return models.LaunchData{}, 0, err
+	}
+	defer tx.Rollback()
+
+	// 锁定用户的聚合数据行，保证同一用户的并发写入串行执行
+	current, err := s.loadLaunchData(tx, userID, true)
+	if err != nil {
+		return models.LaunchData{}, 0, err
+	}
+	events, err := build(current)
+	if err != nil {
+		return current, 0, err
+	}
+
+	inserted, err := s.insertLaunchEvents(tx, userID, events)
+	if err != nil {
+		return models.LaunchData{}, 0, err
+	}
+	// 全部是重复事件时数据没有变化，不需要重建，也不应增加修订号
+	if inserted == 0 {
+		return current, 0, nil
+	}
+	merged, err := s.rebuildLaunchData(tx, userID)
+	if err != nil {
+		return models.LaunchData{}, 0, err
+	}
+	return merged, inserted, tx.Commit()
+}
+
+func (s *sqlStore) MergeLaunchEvents(userID int, merge EventMerger) (models.LaunchData, models.LaunchData, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return models.LaunchData{}, models.LaunchData{}, err
+	}
+	defer tx.Rollback()
+
+	// 锁定用户的聚合数据行，与 AppendLaunchEvents 互斥
+	before, err := s.loadLaunchData(tx, userID, true)
+	if err != nil {
+		return models.LaunchData{}, models.LaunchData{}, err
+	}
+	current, err := s.loadLaunchEvents(tx, userID)
+	if err != nil {
+		return models.LaunchData{}, models.LaunchData{}, err
+	}
+	add, remove, err := merge(current)
+	if err != nil {
+		return before, before, err
+	}
+
+	changed := 0
+	for _, id := range remove {
+		result, err := tx.Exec("DELETE FROM launch_events WHERE user_id = ? AND event_id = ?", userID, id)
+		if err != nil {
+			return models.LaunchData{}, models.LaunchData{}, err
+		}
+		if n, err := result.RowsAffected(); err == nil {
+			changed += int(n)
+		}
+	}
+	inserted, err := s.insertLaunchEvents(tx, userID, add)
+	if err != nil {
+		return models.LaunchData{}, models.LaunchData{}, err
+	}
+	// 没有删除也没有新增事件时数据没有变化，不增加修订号
+	if changed+inserted == 0 {
+		return before, before, nil
+	}
+	after, err := s.rebuildLaunchData(tx, userID)
+	if err != nil {
+		return models.LaunchData{}, models.LaunchData{}, err
+	}
+	return before, after, tx.Commit()
+}
+
+func (s *sqlStore) BackfillLaunchEvents() (int, error) {
+	rows, err := s.db.Query(`
+		SELECT user_id, total, day_data, last_launch
+		FROM launch_data ld
+		WHERE total > 0
+		  AND NOT EXISTS (SELECT 1 FROM launch_events le WHERE le.user_id = ld.user_id)
+	`)
+	if err != nil {
+		return 0, err
+	}
+
+	// 先读出全部待补录数据再逐个处理，避免在结果集未关闭时执行写操作
+	var pending []models.LaunchData
+	for rows.Next() {
+		var data models.LaunchData
+		var dayData []byte
+		var lastLaunch sql.NullTime
+		if err := rows.Scan(&data.UserID, &data.Total, &dayData, &lastLaunch); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if err := json.Unmarshal(dayData, &data.DayData); err != nil {
+			log.Printf("解析用户 %d 的日数据失败: %v", data.UserID, err)
+			data.DayData = make(map[string]int)
+		}
+		if lastLaunch.Valid {
+			data.LastLaunch = lastLaunch.Time
+		}
+		pending = append(pending, data)
+	}
+	rows.Close()
+
+	done := 0
+	for _, data := range pending {
+		// 补录失败的用户不能跳过：之后的第一次同步会按只有新事件的事件日志重建聚合数据，覆盖其全部历史
+		if err := s.backfillUser(data); err != nil {
+			return done, fmt.Errorf("补录用户 %d 的发射事件失败: %w", data.UserID, err)
+		}
+		log.Printf("已为用户 %d 补录 %d 条发射事件", data.UserID, data.Total)
+		done++
+	}
+	return done, nil
+}
+
+// backfillUser 在一个事务中为单个用户写入补录事件并重建聚合数据。
+func (s *sqlStore) backfillUser(data models.LaunchData) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// 补录的是引入用户时区之前由客户端统计的数据，按服务器的默认时区解析
+	events := models.BackfillEvents(data, models.DefaultLocation)
+	if _, err := s.insertLaunchEvents(tx, data.UserID, events); err != nil {
+		return err
+	}
+	if _, err := s.rebuildLaunchData(tx, data.UserID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+// insertLaunchEvents 将发射事件写入 launch_events 表。
+// 相同用户下已存在的事件 ID 会被忽略，因此客户端重试不会产生重复记录。
+// 返回实际新增的事件数量。
+func (s *sqlStore) insertLaunchEvents(q dbExecutor, userID int, events []models.LaunchEvent) (int, error) {
+	inserted := 0
+	for _, e := range events {
+		result, err := q.Exec(s.dialect.insertIgnore+` INTO launch_events (user_id, event_id, device, launched_at, timezone)
+			VALUES (?, ?, ?, ?, ?)
+		`, userID, e.EventID, e.Device, e.LaunchedAt.UTC(), e.Timezone)
+		if err != nil {
+			return inserted, err
+		}
+		if n, err := result.RowsAffected(); err == nil {
+			inserted += int(n)
+		}
+	}
+	return inserted, nil
+}
+
+// loadLaunchEvents 按时间顺序读取指定用户的全部发射事件。
+func (s *sqlStore) loadLaunchEvents(q dbExecutor, userID int) ([]models.LaunchEvent, error) {
+	rows, err := q.Query(`
+		SELECT event_id, device, launched_at, timezone
+		FROM launch_events
+		WHERE user_id = ?
+		ORDER BY launched_at, id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanLaunchEvents(rows, userID)
+}
+
+// scanLaunchEvents 读取 event_id, device, launched_at, timezone 四列组成的结果集并关闭它。
+func scanLaunchEvents(rows *sql.Rows, userID int) ([]models.LaunchEvent, error) {
+	defer rows.Close()
+
+	var events []models.LaunchEvent
+	for rows.Next() {
+		e := models.LaunchEvent{UserID: userID}
+		if err := rows.Scan(&e.EventID, &e.Device, &e.LaunchedAt, &e.Timezone); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// userTimezone 返回用户设置的时区，未设置时为空字符串，用户不存在时返回 ErrNotFound。
+func (s *sqlStore) userTimezone(q dbExecutor, userID int) (string, error) {
+	var timezone string
+	err := q.QueryRow("SELECT timezone FROM users WHERE id = ?", userID).Scan(&timezone)
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	}
+	return timezone, err
+}
+
+// loadLaunchData 读取指定用户当前的聚合发射数据。
+// 参数 forUpdate 为 true 时会锁定该行，必须在事务中调用。
+// 用户尚无记录时返回修订号为 0 的空数据。
+func (s *sqlStore) loadLaunchData(q dbExecutor, userID int, forUpdate bool) (models.LaunchData, error) {
+	timezone, err := s.userTimezone(q, userID)
+	if err != nil && err != ErrNotFound {
+		return models.LaunchData{}, err
+	}
+	data := models.LaunchData{
+		UserID:    userID,
+		YearData:  make(map[string]int),
+		MonthData: make(map[string]int),
+		DayData:   make(map[string]int),
+		Timezone:  models.UserLocation(timezone).String(),
+	}
+	query := `
+		SELECT total, year_data, month_data, day_data, last_launch, revision
+		FROM launch_data
+		WHERE user_id = ?`
+	if forUpdate {
+		query += s.dialect.forUpdate
+	}
+
+	var yearData, monthData, dayData []byte
+	var lastLaunch sql.NullTime
+	err = q.QueryRow(query, userID).Scan(&data.Total, &yearData, &monthData, &dayData, &lastLaunch, &data.Revision)
+	if err == sql.ErrNoRows {
+		return data, nil
+	}
+	if err != nil {
+		return models.LaunchData{}, err
+	}
+	if lastLaunch.Valid {
+		data.LastLaunch = lastLaunch.Time
+	}
+	// 解析失败时保留空映射，不影响其余字段的读取
+	for _, field := range []struct {
+		raw  []byte
+		dest *map[string]int
+	}{{yearData, &data.YearData}, {monthData, &data.MonthData}, {dayData, &data.DayData}} {
+		if len(field.raw) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(field.raw, field.dest); err != nil {
+			log.Printf("解析用户 %d 的发射数据失败: %v", userID, err)
+			*field.dest = make(map[string]int)
+		}
+	}
+	return data, nil
+}
+
+// rebuildLaunchData 根据发射事件重新计算用户的聚合数据，并写回 launch_data 表。
+// launch_data 只是事件日志的物化结果，随时可以通过本函数重新生成。
+// 每次重建都会将该行的修订号加 1，返回的数据包含新的修订号。
+func (s *sqlStore) rebuildLaunchData(q dbExecutor, userID int) (models.LaunchData, error) {
+	events, err := s.loadLaunchEvents(q, userID)
+	if err != nil {
+		return models.LaunchData{}, err
+	}
+	timezone, err := s.userTimezone(q, userID)
+	if err != nil && err != ErrNotFound {
+		return models.LaunchData{}, err
+	}
+	data := models.AggregateEvents(userID, events, models.UserLocation(timezone))
+
+	yearData, _ := json.Marshal(data.YearData)
+	monthData, _ := json.Marshal(data.MonthData)
+	dayData, _ := json.Marshal(data.DayData)
+	// 没有任何事件时最后发射时间为 NULL，与新用户的初始记录保持一致
+	var lastLaunch interface{}
+	if !data.LastLaunch.IsZero() {
+		lastLaunch = data.LastLaunch.UTC()
+	}
+
+	_, err = q.Exec(s.dialect.upsertLaunchData, userID, data.Total, yearData, monthData, dayData, lastLaunch)
+	if err != nil {
+		return models.LaunchData{}, err
+	}
+	if err := q.QueryRow("SELECT revision FROM launch_data WHERE user_id = ?", userID).Scan(&data.Revision); err != nil {
+		return models.LaunchData{}, err
+	}
+	return data, nil
+}
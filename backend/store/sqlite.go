@@ -0,0 +1,145 @@
+//go:build !nosqlite
+
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// NewSQLite 打开（必要时创建）嵌入式 SQLite 数据库文件，表结构由迁移创建。
+// 适合不想单独部署 MySQL 的小型自托管场景。参数 path 是数据库文件路径。
+func NewSQLite(path string) (Store, error) {
+	if path == "" {
+		return nil, errors.New("使用 sqlite 时必须配置 db_path")
+	}
+	// _foreign_keys 启用外键约束，_busy_timeout 让并发写入等待而不是立即失败
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_foreign_keys=on&_busy_timeout=5000", path))
+	if err != nil {
+		return nil, fmt.Errorf("打开数据库失败: %w", err)
+	}
+	// SQLite 同一时间只允许一个写事务，使用单连接让写操作在连接池中排队，
+	// 同时也为 AppendLaunchEvents 提供了与 MySQL 行锁等价的串行化保证
+	db.SetMaxOpenConns(1)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("数据库连接测试失败: %w", err)
+	}
+	return &sqlStore{db: db, dialect: sqliteDialect}, nil
+}
+
+// sqliteDialect 描述 SQLite 的语法差异。
+var sqliteDialect = dialect{
+	insertIgnore: "INSERT OR IGNORE",
+	// SQLite 没有行锁，单连接已经保证了事务串行执行
+	forUpdate: "",
+	upsertLaunchData: `
+		INSERT INTO launch_data (user_id, total, year_data, month_data, day_data, last_launch, revision)
+		VALUES (?, ?, ?, ?, ?, ?, 1)
+		ON CONFLICT (user_id) DO UPDATE SET
+			total = excluded.total,
+			year_data = excluded.year_data,
+			month_data = excluded.month_data,
+			day_data = excluded.day_data,
+			last_launch = excluded.last_launch,
+			revision = launch_data.revision + 1
+	`,
+	isDuplicate: func(err error) bool {
+		var sqliteErr sqlite3.Error
+		return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+	},
+	migrations: sqliteMigrations,
+}
+
+// sqliteMigrations 是 SQLite 的数据库迁移列表，版本号与 MySQL 保持一一对应。
+var sqliteMigrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create_users_and_launch_data",
+		Up: execStatements(`
+			CREATE TABLE IF NOT EXISTS users (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				username TEXT NOT NULL UNIQUE,
+				password_hash TEXT NOT NULL
+			)`, `
+			CREATE TABLE IF NOT EXISTS launch_data (
+				user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+				total INTEGER NOT NULL DEFAULT 0,
+				year_data TEXT,
+				month_data TEXT,
+				day_data TEXT,
+				last_launch DATETIME NULL
+			)`),
+		Down: execStatements("DROP TABLE IF EXISTS launch_data", "DROP TABLE IF EXISTS users"),
+	},
+	{
+		Version: 2,
+		Name:    "create_launch_events",
+		Up: execStatements(`
+			CREATE TABLE IF NOT EXISTS launch_events (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				event_id TEXT NOT NULL,
+				device TEXT NOT NULL DEFAULT '',
+				launched_at DATETIME NOT NULL,
+				UNIQUE (user_id, event_id)
+			)`, `
+			CREATE INDEX IF NOT EXISTS idx_launch_events_user_time ON launch_events (user_id, launched_at)`),
+		Down: execStatements("DROP TABLE IF EXISTS launch_events"),
+	},
+	{
+		Version: 3,
+		Name:    "add_launch_data_revision",
+		Up: func(tx *sql.Tx) error {
+			return ensureSQLiteColumn(tx, "launch_data", "revision", "INTEGER NOT NULL DEFAULT 0")
+		},
+		Down: execStatements("ALTER TABLE launch_data DROP COLUMN revision"),
+	},
+	{
+		Version: 4,
+		Name:    "create_sessions",
+		Up: execStatements(`
+			CREATE TABLE IF NOT EXISTS sessions (
+				id TEXT PRIMARY KEY,
+				user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				refresh_hash TEXT NOT NULL,
+				user_agent TEXT NOT NULL DEFAULT '',
+				ip TEXT NOT NULL DEFAULT '',
+				created_at DATETIME NOT NULL,
+				last_used_at DATETIME NOT NULL,
+				expires_at DATETIME NOT NULL,
+				revoked_at DATETIME NULL
+			)`, `
+			CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions (user_id)`),
+		Down: execStatements("DROP TABLE IF EXISTS sessions"),
+	},
+	{
+		Version: 5,
+		Name:    "add_timezones",
+		Up: func(tx *sql.Tx) error {
+			if err := ensureSQLiteColumn(tx, "users", "timezone", "TEXT NOT NULL DEFAULT ''"); err != nil {
+				return err
+			}
+			return ensureSQLiteColumn(tx, "launch_events", "timezone", "TEXT NOT NULL DEFAULT ''")
+		},
+		Down: execStatements("ALTER TABLE launch_events DROP COLUMN timezone", "ALTER TABLE users DROP COLUMN timezone"),
+	},
+}
+
+// ensureSQLiteColumn 检查指定表中是否存在某个字段，不存在时使用给定的定义添加该字段。
+// 引入迁移机制之前创建的 SQLite 数据库已经包含部分字段，需要跳过。
+func ensureSQLiteColumn(q dbExecutor, table, column, definition string) error {
+	var count int
+	err := q.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	_, err = q.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
@@ -0,0 +1,11 @@
+//go:build nosqlite
+
+package store
+
+import "errors"
+
+// NewSQLite 在使用 nosqlite 标签编译时不可用。该标签去掉了依赖 cgo 的 SQLite 驱动，
+// 只使用 MySQL 或内存存储的部署可以借此以 CGO_ENABLED=0 编译为纯 Go 程序。
+func NewSQLite(path string) (Store, error) {
+	return nil, errors.New("此程序编译时使用了 nosqlite 标签，不支持 sqlite，请使用 mysql 或 memory")
+}
@@ -0,0 +1,21 @@
+//go:build !nosqlite
+
+package store
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func init() {
+	backends["sqlite"] = func(t *testing.T) Store {
+		st, err := NewSQLite(filepath.Join(t.TempDir(), "test.db"))
+		if err != nil {
+			t.Fatalf("打开 SQLite 数据库失败: %v", err)
+		}
+		if _, err := st.MigrateUp(0); err != nil {
+			t.Fatalf("执行迁移失败: %v", err)
+		}
+		return st
+	}
+}
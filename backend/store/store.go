@@ -0,0 +1,105 @@
+// Package store 定义了用户和发射数据的存储接口，并提供 MySQL、SQLite 和内存三种实现。
+// 处理函数和命令行界面只依赖 Store 接口，不再直接编写 SQL。
+package store
+
+import (
+	"backend/models"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrNotFound 表示查询的用户不存在。
+	ErrNotFound = errors.New("记录不存在")
+	// ErrUserExists 表示创建用户时用户名已被占用。
+	ErrUserExists = errors.New("用户名已存在")
+	// ErrRevisionConflict 表示客户端提交数据时所基于的修订号已经过期。
+	ErrRevisionConflict = errors.New("修订号冲突")
+)
+
+// EventBuilder 根据用户当前的聚合数据决定需要追加的发射事件。
+// 存储实现会在持有该用户写锁的情况下调用它，因此读取 current 和写入事件之间不会被其他写操作插入。
+// 返回错误时不会写入任何数据，该错误会原样返回给调用方。
+type EventBuilder func(current models.LaunchData) ([]models.LaunchEvent, error)
+
+// EventMerger 根据用户当前的全部发射事件决定需要追加的事件和需要删除的事件 ID。
+// 与 EventBuilder 一样，存储实现会在持有该用户写锁的情况下调用它，返回错误时不会修改任何数据。
+type EventMerger func(current []models.LaunchEvent) (add []models.LaunchEvent, remove []string, err error)
+
+// Store 是存储后端需要实现的接口。
+type Store interface {
+	// CreateUser 创建用户并为其初始化空的发射数据，用户名已存在时返回 ErrUserExists。
+	CreateUser(username, passwordHash string) (models.User, error)
+	// GetUserByID 按 ID 查询用户，不存在时返回 ErrNotFound。
+	GetUserByID(id int) (models.User, error)
+	// GetUserByUsername 按用户名查询用户，不存在时返回 ErrNotFound。
+	GetUserByUsername(username string) (models.User, error)
+	// ListUsers 按 ID 顺序列出全部用户。
+	ListUsers() ([]models.User, error)
+	// DeleteUser 删除用户及其全部发射数据。
+	DeleteUser(id int) error
+	// UpdatePassword 更新用户的密码哈希，并吊销该用户的全部会话。
+	UpdatePassword(id int, passwordHash string) error
+	// SetUserTimezone 修改用户的时区，timezone 为空表示使用服务器的默认时区，用户不存在时返回 ErrNotFound。
+	// rebucket 为 true 时全部历史事件改为按新时区统计；为 false 时已有事件保持修改前的统计结果，只有之后的事件使用新时区。
+	// 修改后重新计算聚合数据并将修订号加 1，返回修改前后的聚合数据。
+	SetUserTimezone(userID int, timezone string, rebucket bool) (models.LaunchData, models.LaunchData, error)
+
+	// CreateSession 保存新建的登录会话。
+	CreateSession(session models.Session) error
+	// GetSession 按 ID 查询会话，不存在时返回 ErrNotFound。已吊销或已过期的会话同样会返回。
+	GetSession(id string) (models.Session, error)
+	// RotateSession 将会话的刷新令牌哈希从 oldHash 替换为 newHash 并延长有效期。
+	// 会话不存在、已吊销或 oldHash 不匹配（刷新令牌已被使用过）时返回 ErrNotFound。
+	RotateSession(id, oldHash, newHash string, expiresAt time.Time) error
+	// RevokeSession 吊销单个会话，会话不存在时返回 ErrNotFound。
+	RevokeSession(id string) error
+	// RevokeUserSessions 吊销用户的全部有效会话，返回吊销的数量。
+	RevokeUserSessions(userID int) (int, error)
+
+	// GetLaunchData 返回用户当前的聚合发射数据，没有任何记录时返回修订号为 0 的空数据。
+	GetLaunchData(userID int) (models.LaunchData, error)
+	// ListLaunchEvents 按时间顺序返回用户的全部发射事件。
+	ListLaunchEvents(userID int) ([]models.LaunchEvent, error)
+	// ListLaunchEventsBetween 按时间顺序返回发射时间在 [from, to) 之间的发射事件。
+	ListLaunchEventsBetween(userID int, from, to time.Time) ([]models.LaunchEvent, error)
+	// AppendLaunchEvents 追加由 build 生成的发射事件，已存在的事件 ID 会被忽略。
+	// 有新事件写入时会重新计算聚合数据并将修订号加 1。
+	// 返回写入后的聚合数据以及实际新增的事件数量。
+	AppendLaunchEvents(userID int, build EventBuilder) (models.LaunchData, int, error)
+	// MergeLaunchEvents 删除 merge 返回的事件 ID 并追加其返回的事件，追加时已存在的事件 ID 会被忽略。
+	// 数据有变化时重新计算聚合数据并将修订号加 1，返回修改前后的聚合数据。
+	MergeLaunchEvents(userID int, merge EventMerger) (models.LaunchData, models.LaunchData, error)
+	// BackfillLaunchEvents 为只有聚合数据、没有事件记录的历史用户补录事件，返回处理的用户数量。
+	// 任一用户补录失败时立即返回错误，调用方不应在补录未完成时继续提供服务。
+	BackfillLaunchEvents() (int, error)
+
+	// MigrationStatus 列出全部迁移及其在当前数据库中的执行状态。
+	MigrationStatus() ([]MigrationStatus, error)
+	// MigrateUp 依次执行尚未执行的迁移，target 大于 0 时只执行到该版本为止。
+	// 返回本次执行的迁移数量。
+	MigrateUp(target int) (int, error)
+	// MigrateDown 从最新版本开始回滚 steps 个已执行的迁移，返回实际回滚的数量。
+	MigrateDown(steps int) (int, error)
+
+	// Close 释放存储后端持有的资源。
+	Close() error
+}
+
+// Open 根据配置中的 db_driver 创建对应的存储后端。
+// 支持 "mysql"（默认）、"sqlite" 和 "memory"。
+func Open(config *models.Config) (Store, error) {
+	switch config.DBDriver {
+	case "", "mysql":
+		dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true",
+			config.DBUser, config.DBPassword, config.DBHost, config.DBPort, config.DBName)
+		return NewMySQL(dsn)
+	case "sqlite":
+		return NewSQLite(config.DBPath)
+	case "memory":
+		return NewMemory(), nil
+	default:
+		return nil, fmt.Errorf("不支持的数据库类型: %s", config.DBDriver)
+	}
+}
@@ -0,0 +1,278 @@
+package store
+
+import (
+	"backend/models"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// backends 是一致性测试覆盖的存储实现，每个实现返回一个已完成迁移的空存储。
+// SQLite 依赖 cgo，在 sqlite_test.go 中加入，使用 nosqlite 标签编译时跳过。
+var backends = map[string]func(t *testing.T) Store{
+	"memory": func(t *testing.T) Store { return NewMemory() },
+}
+
+// TestStoreConformance 对每个存储实现运行同一组用例，确保内存实现与 SQL 实现的行为一致。
+func TestStoreConformance(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, st Store)
+	}{
+		{"修订号递增与冲突", testRevision},
+		{"重复的事件 ID", testDuplicateEvents},
+		{"合并时追加和删除事件", testMergeEvents},
+		{"修改时区重新统计", testTimezoneRebucket},
+		{"吊销会话", testSessions},
+	}
+
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, tt := range tests {
+			open := backends[name]
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				st := open(t)
+				t.Cleanup(func() { st.Close() })
+				tt.run(t, st)
+			})
+		}
+	}
+}
+
+// newTestUser 创建使用 UTC 统计的测试用户。
+func newTestUser(t *testing.T, st Store, username string) models.User {
+	t.Helper()
+	user, err := st.CreateUser(username, "hash")
+	if err != nil {
+		t.Fatalf("创建用户失败: %v", err)
+	}
+	if _, _, err := st.SetUserTimezone(user.ID, "UTC", true); err != nil {
+		t.Fatalf("设置时区失败: %v", err)
+	}
+	return user
+}
+
+// launch 返回 2024 年 3 月 day 日 hour 时（UTC）的事件。
+func launch(id string, day, hour int) models.LaunchEvent {
+	return models.LaunchEvent{EventID: id, Device: "test", LaunchedAt: time.Date(2024, 3, day, hour, 0, 0, 0, time.UTC)}
+}
+
+// appendEvents 追加事件，返回写入后的聚合数据和新增数量。
+func appendEvents(t *testing.T, st Store, userID int, events ...models.LaunchEvent) (models.LaunchData, int) {
+	t.Helper()
+	data, inserted, err := st.AppendLaunchEvents(userID, func(models.LaunchData) ([]models.LaunchEvent, error) {
+		return events, nil
+	})
+	if err != nil {
+		t.Fatalf("追加事件失败: %v", err)
+	}
+	return data, inserted
+}
+
+// eventIDs 返回用户全部事件的 ID，按发射时间排序。
+func eventIDs(t *testing.T, st Store, userID int) []string {
+	t.Helper()
+	events, err := st.ListLaunchEvents(userID)
+	if err != nil {
+		t.Fatalf("查询事件失败: %v", err)
+	}
+	ids := make([]string, 0, len(events))
+	for _, e := range events {
+		ids = append(ids, e.EventID)
+	}
+	return ids
+}
+
+func testRevision(t *testing.T, st Store) {
+	user, err := st.CreateUser("alice", "hash")
+	if err != nil {
+		t.Fatalf("创建用户失败: %v", err)
+	}
+	data, err := st.GetLaunchData(user.ID)
+	if err != nil || data.Revision != 0 || data.Total != 0 {
+		t.Fatalf("新用户的数据为 %+v, %v，期望修订号为 0 的空数据", data, err)
+	}
+
+	data, inserted := appendEvents(t, st, user.ID, launch("a", 1, 9))
+	if inserted != 1 || data.Revision != 1 || data.Total != 1 {
+		t.Fatalf("追加后新增 %d，修订号 %d，总数 %d，期望 1、1、1", inserted, data.Revision, data.Total)
+	}
+
+	// 基于过期修订号的提交由 build 拒绝，不应写入任何数据
+	stale := int64(0)
+	_, _, err = st.AppendLaunchEvents(user.ID, func(current models.LaunchData) ([]models.LaunchEvent, error) {
+		if current.Revision != stale {
+			return nil, ErrRevisionConflict
+		}
+		return []models.LaunchEvent{launch("b", 1, 10)}, nil
+	})
+	if !errors.Is(err, ErrRevisionConflict) {
+		t.Fatalf("修订号过期时返回 %v，期望 ErrRevisionConflict", err)
+	}
+	if got := eventIDs(t, st, user.ID); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Fatalf("冲突后事件为 %v，期望只有 a", got)
+	}
+
+	data, _ = appendEvents(t, st, user.ID, launch("b", 1, 10))
+	if data.Revision != 2 {
+		t.Fatalf("再次追加后修订号为 %d，期望 2", data.Revision)
+	}
+	if got, _ := st.GetLaunchData(user.ID); got.Revision != 2 || got.Total != 2 {
+		t.Fatalf("查询到的修订号 %d，总数 %d，期望 2、2", got.Revision, got.Total)
+	}
+}
+
+func testDuplicateEvents(t *testing.T, st Store) {
+	alice := newTestUser(t, st, "alice")
+	bob := newTestUser(t, st, "bob")
+	before, _ := st.GetLaunchData(alice.ID)
+
+	// 同一批次和之前批次中重复的 ID 都只写入一次
+	data, inserted := appendEvents(t, st, alice.ID, launch("a", 1, 9), launch("b", 1, 10), launch("a", 1, 9))
+	if inserted != 2 || data.Total != 2 || data.Revision != before.Revision+1 {
+		t.Fatalf("新增 %d，总数 %d，修订号 %d，期望 2、2、%d", inserted, data.Total, data.Revision, before.Revision+1)
+	}
+	data, inserted = appendEvents(t, st, alice.ID, launch("b", 1, 10))
+	if inserted != 0 || data.Revision != before.Revision+1 {
+		t.Fatalf("重复提交新增 %d，修订号 %d，期望 0 且修订号不变", inserted, data.Revision)
+	}
+
+	// 事件 ID 只在同一用户内唯一
+	if _, inserted := appendEvents(t, st, bob.ID, launch("a", 1, 9)); inserted != 1 {
+		t.Fatalf("其他用户的相同事件 ID 新增 %d，期望 1", inserted)
+	}
+	if got := eventIDs(t, st, alice.ID); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Fatalf("事件为 %v，期望 [a b]", got)
+	}
+}
+
+func testMergeEvents(t *testing.T, st Store) {
+	user := newTestUser(t, st, "alice")
+	appendEvents(t, st, user.ID, launch("a", 1, 9), launch("b", 2, 9), launch("c", 3, 9))
+
+	before, after, err := st.MergeLaunchEvents(user.ID, func(current []models.LaunchEvent) ([]models.LaunchEvent, []string, error) {
+		if len(current) != 3 {
+			t.Errorf("合并时看到 %d 个事件，期望 3 个", len(current))
+		}
+		return []models.LaunchEvent{launch("d", 4, 9), launch("a", 1, 9)}, []string{"b"}, nil
+	})
+	if err != nil {
+		t.Fatalf("合并失败: %v", err)
+	}
+	if before.Total != 3 || after.Total != 3 || after.Revision != before.Revision+1 {
+		t.Fatalf("合并前后总数 %d、%d，修订号 %d、%d", before.Total, after.Total, before.Revision, after.Revision)
+	}
+	if want := map[string]int{"2024-3-1": 1, "2024-3-3": 1, "2024-3-4": 1}; !reflect.DeepEqual(after.DayData, want) {
+		t.Errorf("合并后的日统计 %v，期望 %v", after.DayData, want)
+	}
+	if got := eventIDs(t, st, user.ID); !reflect.DeepEqual(got, []string{"a", "c", "d"}) {
+		t.Fatalf("合并后事件为 %v，期望 [a c d]", got)
+	}
+
+	// 删除不存在的 ID、追加已存在的 ID 不算变化，修订号不变
+	before, after, err = st.MergeLaunchEvents(user.ID, func([]models.LaunchEvent) ([]models.LaunchEvent, []string, error) {
+		return []models.LaunchEvent{launch("c", 3, 9)}, []string{"x"}, nil
+	})
+	if err != nil || after.Revision != before.Revision {
+		t.Fatalf("没有变化的合并修订号 %d -> %d, %v", before.Revision, after.Revision, err)
+	}
+
+	// merge 返回错误时不修改数据
+	failed := errors.New("failed")
+	if _, _, err := st.MergeLaunchEvents(user.ID, func([]models.LaunchEvent) ([]models.LaunchEvent, []string, error) {
+		return []models.LaunchEvent{launch("e", 5, 9)}, []string{"a"}, failed
+	}); !errors.Is(err, failed) {
+		t.Fatalf("合并返回 %v，期望原样返回 merge 的错误", err)
+	}
+	if got := eventIDs(t, st, user.ID); !reflect.DeepEqual(got, []string{"a", "c", "d"}) {
+		t.Fatalf("合并失败后事件为 %v，期望不变", got)
+	}
+}
+
+func testTimezoneRebucket(t *testing.T, st Store) {
+	user := newTestUser(t, st, "alice")
+	// UTC 3 月 5 日 20 点是上海时间 3 月 6 日 4 点
+	data, _ := appendEvents(t, st, user.ID, launch("a", 5, 20))
+	if want := map[string]int{"2024-3-5": 1}; !reflect.DeepEqual(data.DayData, want) {
+		t.Fatalf("日统计 %v，期望 %v", data.DayData, want)
+	}
+
+	// 不重新统计时已有事件保持原来的日期，之后的事件按新时区统计
+	before, after, err := st.SetUserTimezone(user.ID, "Asia/Shanghai", false)
+	if err != nil {
+		t.Fatalf("修改时区失败: %v", err)
+	}
+	if after.Revision != before.Revision+1 || after.Timezone != "Asia/Shanghai" {
+		t.Fatalf("修改时区后修订号 %d -> %d，时区 %q", before.Revision, after.Revision, after.Timezone)
+	}
+	if want := map[string]int{"2024-3-5": 1}; !reflect.DeepEqual(after.DayData, want) {
+		t.Fatalf("不重新统计时日统计 %v，期望 %v", after.DayData, want)
+	}
+	data, _ = appendEvents(t, st, user.ID, launch("b", 5, 21))
+	if want := map[string]int{"2024-3-5": 1, "2024-3-6": 1}; !reflect.DeepEqual(data.DayData, want) {
+		t.Fatalf("修改时区后新事件的日统计 %v，期望 %v", data.DayData, want)
+	}
+
+	// 重新统计时全部事件改为按新时区统计
+	_, after, err = st.SetUserTimezone(user.ID, "Asia/Shanghai", true)
+	if err != nil {
+		t.Fatalf("修改时区失败: %v", err)
+	}
+	if want := map[string]int{"2024-3-6": 2}; !reflect.DeepEqual(after.DayData, want) {
+		t.Fatalf("重新统计后日统计 %v，期望 %v", after.DayData, want)
+	}
+	if got, _ := st.GetUserByID(user.ID); got.Timezone != "Asia/Shanghai" {
+		t.Fatalf("用户时区为 %q，期望 Asia/Shanghai", got.Timezone)
+	}
+
+	if _, _, err := st.SetUserTimezone(user.ID+100, "UTC", true); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("用户不存在时返回 %v，期望 ErrNotFound", err)
+	}
+}
+
+func testSessions(t *testing.T, st Store) {
+	user := newTestUser(t, st, "alice")
+	now := time.Now().Truncate(time.Second)
+	for _, id := range []string{"s1", "s2"} {
+		if err := st.CreateSession(models.Session{ID: id, UserID: user.ID, RefreshHash: id + "-hash", CreatedAt: now, LastUsedAt: now, ExpiresAt: now.Add(time.Hour)}); err != nil {
+			t.Fatalf("创建会话失败: %v", err)
+		}
+	}
+
+	if err := st.RotateSession("s1", "wrong", "new", now.Add(2*time.Hour)); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("旧哈希不匹配时返回 %v，期望 ErrNotFound", err)
+	}
+	if err := st.RotateSession("s1", "s1-hash", "s1-new", now.Add(2*time.Hour)); err != nil {
+		t.Fatalf("轮换会话失败: %v", err)
+	}
+	if err := st.RotateSession("s1", "s1-hash", "s1-newer", now.Add(2*time.Hour)); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("重复使用刷新令牌时返回 %v，期望 ErrNotFound", err)
+	}
+
+	if err := st.RevokeSession("s1"); err != nil {
+		t.Fatalf("吊销会话失败: %v", err)
+	}
+	if s, err := st.GetSession("s1"); err != nil || s.Active(now) {
+		t.Fatalf("吊销后的会话 %+v, %v 不应有效", s, err)
+	}
+	if err := st.RevokeSession("missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("吊销不存在的会话返回 %v，期望 ErrNotFound", err)
+	}
+
+	// 修改密码吊销该用户的全部会话
+	if err := st.UpdatePassword(user.ID, "new-hash"); err != nil {
+		t.Fatalf("修改密码失败: %v", err)
+	}
+	if s, err := st.GetSession("s2"); err != nil || s.Active(now) {
+		t.Fatalf("修改密码后会话 %+v, %v 不应有效", s, err)
+	}
+	if n, err := st.RevokeUserSessions(user.ID); err != nil || n != 0 {
+		t.Fatalf("再次吊销全部会话返回 %d, %v，期望 0", n, err)
+	}
+}
@@ -0,0 +1,390 @@
+package tokens
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// 支持的签名算法。HS256 使用共享密钥，EdDSA 和 RS256 使用非对称密钥，公钥可以分享给其他服务用于校验令牌。
+const (
+	AlgHS256 = "HS256"
+	AlgEdDSA = "EdDSA"
+	AlgRS256 = "RS256"
+)
+
+// 密钥的状态。
+const (
+	// StatusCurrent 表示当前用于签发令牌的密钥，密钥环中只有一个
+	StatusCurrent = "current"
+	// StatusNext 表示已生成但尚未启用的密钥，只用于校验，便于提前分发公钥
+	StatusNext = "next"
+	// StatusRetired 表示已被替换的密钥，在 ExpiresAt 之前仍可校验它签发的令牌
+	StatusRetired = "retired"
+)
+
+// LegacyKeyID 是由配置中 jwt_secret_key 导入的密钥 ID。
+// 引入密钥环之前签发的令牌没有 kid 头，按该密钥校验。
+const LegacyKeyID = "legacy"
+
+// ErrUnknownKey 表示令牌的 kid 不在密钥环中，或对应的密钥已过期。
+var ErrUnknownKey = errors.New("未知或已过期的签名密钥")
+
+// Key 是密钥环中的一把密钥，以 JSON 形式保存在密钥环文件中。
+type Key struct {
+	ID        string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Status    string `json:"status"`
+	// Secret 是 HS256 的共享密钥（Base64 编码）
+	Secret string `json:"secret,omitempty"`
+	// PrivateKey 和 PublicKey 是非对称密钥的 PEM 编码（PKCS#8 和 PKIX）
+	PrivateKey string    `json:"private_key,omitempty"`
+	PublicKey  string    `json:"public_key,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	// RetiredAt 和 ExpiresAt 只对已退役的密钥有意义，ExpiresAt 之后密钥被移除
+	RetiredAt time.Time `json:"retired_at,omitempty"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// method 返回密钥对应的 JWT 签名方法。
+func (k *Key) method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+// Keyring 保存签发和校验访问令牌所用的全部密钥。
+// 轮换密钥时新密钥成为当前密钥，旧密钥转为只校验，直到它签发的令牌全部自然过期，
+// 因此轮换不会让任何设备掉线。
+type Keyring struct {
+	path string
+	// retireFor 是旧密钥退役后继续用于校验的时长，不短于访问令牌的有效期
+	retireFor time.Duration
+
+	mu   sync.RWMutex
+	keys []*Key
+}
+
+// LoadKeyring 从 path 加载密钥环。
+// 文件不存在时用 legacySecret 创建只含一把 HS256 密钥的密钥环，使已签发的令牌继续有效；
+// algorithm 不是 HS256 时随即轮换到该算法的新密钥。
+// 参数 retireFor 是旧密钥退役后继续用于校验的时长。
+func LoadKeyring(path, legacySecret, algorithm string, retireFor time.Duration) (*Keyring, error) {
+	ring := &Keyring{path: path, retireFor: retireFor}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		if legacySecret == "" {
+			return nil, errors.New("密钥环文件不存在且未配置 jwt_secret_key，可以设置 LC_JWT_SECRET_KEY 或以 --generate-jwt-key 启动")
+		}
+		ring.keys = []*Key{{
+			ID:        LegacyKeyID,
+			Algorithm: AlgHS256,
+			Status:    StatusCurrent,
+			Secret:    base64.StdEncoding.EncodeToString([]byte(legacySecret)),
+			CreatedAt: time.Now(),
+		}}
+		if err := ring.keys[0].decode(); err != nil {
+			return nil, err
+		}
+		if algorithm != "" && algorithm != AlgHS256 {
+			if _, err := ring.Rotate(algorithm); err != nil {
+				return nil, err
+			}
+			return ring, nil
+		}
+		// 导入的密钥总能由 jwt_secret_key 重新得到，只读文件系统上保存失败时继续在内存中使用
+		if err := ring.save(); err != nil {
+			log.Printf("密钥环未保存，将使用由 jwt_secret_key 导入的密钥: %v", err)
+		}
+		return ring, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取密钥环失败: %v", err)
+	}
+
+	var keys []*Key
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("解析密钥环失败: %v", err)
+	}
+	current := 0
+	for _, k := range keys {
+		if err := k.decode(); err != nil {
+			return nil, fmt.Errorf("密钥 %s 无效: %v", k.ID, err)
+		}
+		if k.Status == StatusCurrent {
+			current++
+		}
+	}
+	if current != 1 {
+		return nil, fmt.Errorf("密钥环中应有且只有一把当前密钥，实际为 %d 把", current)
+	}
+	ring.keys = keys
+	ring.prune(time.Now())
+	return ring, nil
+}
+
+// Current 返回当前用于签发令牌的密钥。
+func (r *Keyring) Current() *Key {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, k := range r.keys {
+		if k.Status == StatusCurrent {
+			return k
+		}
+	}
+	return nil
+}
+
+// Lookup 返回可用于校验的密钥，已过期的退役密钥视为不存在。
+func (r *Keyring) Lookup(kid string) (*Key, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, k := range r.keys {
+		if k.ID == kid {
+			if k.Status == StatusRetired && time.Now().After(k.ExpiresAt) {
+				return nil, false
+			}
+			return k, true
+		}
+	}
+	return nil, false
+}
+
+// Keys 返回密钥环中全部密钥的副本，按创建时间排序，不包含解析后的密钥材料。
+func (r *Keyring) Keys() []Key {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	result := make([]Key, 0, len(r.keys))
+	for _, k := range r.keys {
+		c := *k
+		c.signKey, c.verifyKey = nil, nil
+		result = append(result, c)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].CreatedAt.Before(result[j].CreatedAt) })
+	return result
+}
+
+// Generate 生成一把指定算法的新密钥并以 next 状态加入密钥环，返回其 ID。
+// 新密钥可以先通过 JWKS 分发公钥，之后再用 Rotate 启用。
+func (r *Keyring) Generate(algorithm string) (string, error) {
+	key, err := newKey(algorithm)
+	if err != nil {
+		return "", err
+	}
+	key.Status = StatusNext
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys = append(r.keys, key)
+	return key.ID, r.save()
+}
+
+// Rotate 启用新的签名密钥，原当前密钥退役并在 retireFor 时长内继续用于校验。
+// 参数 kidOrAlgorithm 为已有 next 密钥的 ID 时启用该密钥，否则视为算法名并生成一把新密钥。
+// 返回新的当前密钥 ID。
+func (r *Keyring) Rotate(kidOrAlgorithm string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var next *Key
+	for _, k := range r.keys {
+		if k.ID == kidOrAlgorithm {
+			if k.Status != StatusNext {
+				return "", fmt.Errorf("密钥 %s 不是待启用的密钥", k.ID)
+			}
+			next = k
+		}
+	}
+	if next == nil {
+		key, err := newKey(kidOrAlgorithm)
+		if err != nil {
+			return "", err
+		}
+		r.keys = append(r.keys, key)
+		next = key
+	}
+
+	if next.signKey == nil {
+		return "", fmt.Errorf("密钥 %s 缺少私钥，无法用于签发令牌", next.ID)
+	}
+
+	now := time.Now()
+	for _, k := range r.keys {
+		if k.Status == StatusCurrent {
+			k.Status = StatusRetired
+			k.RetiredAt = now
+			k.ExpiresAt = now.Add(r.retireFor)
+		}
+	}
+	next.Status = StatusCurrent
+	r.prune(now)
+	return next.ID, r.save()
+}
+
+// prune 移除已过期的退役密钥。调用方必须持有写锁，或在密钥环尚未共享时调用。
+func (r *Keyring) prune(now time.Time) {
+	kept := r.keys[:0]
+	for _, k := range r.keys {
+		if k.Status == StatusRetired && now.After(k.ExpiresAt) {
+			continue
+		}
+		kept = append(kept, k)
+	}
+	r.keys = kept
+}
+
+// save 将密钥环写回文件，文件中含有私钥，只允许所有者读写。调用方必须持有写锁。
+func (r *Keyring) save() error {
+	if r.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(r.keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o755); err != nil {
+		return fmt.Errorf("创建密钥环目录失败: %v", err)
+	}
+	// 先写入临时文件再重命名，避免写到一半时进程退出导致密钥环损坏
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("保存密钥环失败: %v", err)
+	}
+	if err := os.Rename(tmp, r.path); err != nil {
+		return fmt.Errorf("保存密钥环失败: %v", err)
+	}
+	return nil
+}
+
+// JWKS 返回非对称密钥的公钥集合（RFC 7517），用于其他服务校验令牌。HS256 共享密钥永远不会出现在其中。
+func (r *Keyring) JWKS() map[string]interface{} {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	keys := make([]map[string]string, 0)
+	now := time.Now()
+	for _, k := range r.keys {
+		if k.Status == StatusRetired && now.After(k.ExpiresAt) {
+			continue
+		}
+		switch pub := k.verifyKey.(type) {
+		case ed25519.PublicKey:
+			keys = append(keys, map[string]string{
+				"kty": "OKP", "crv": "Ed25519", "use": "sig", "alg": k.Algorithm, "kid": k.ID,
+				"x": base64.RawURLEncoding.EncodeToString(pub),
+			})
+		case *rsa.PublicKey:
+			keys = append(keys, map[string]string{
+				"kty": "RSA", "use": "sig", "alg": k.Algorithm, "kid": k.ID,
+				"n": base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		}
+	}
+	return map[string]interface{}{"keys": keys}
+}
+
+// newKey 生成一把指定算法的新密钥，ID 取自公开部分的指纹。
+func newKey(algorithm string) (*Key, error) {
+	key := &Key{Algorithm: algorithm, CreatedAt: time.Now()}
+	var public []byte
+	switch algorithm {
+	case AlgHS256:
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		key.Secret = base64.StdEncoding.EncodeToString(secret)
+		// HS256 没有公开部分，指纹取自随机数，避免从 ID 推测密钥
+		public = make([]byte, 32)
+		if _, err := rand.Read(public); err != nil {
+			return nil, err
+		}
+	case AlgEdDSA, AlgRS256:
+		var priv crypto.Signer
+		var err error
+		if algorithm == AlgEdDSA {
+			_, priv, err = ed25519.GenerateKey(rand.Reader)
+		} else {
+			priv, err = rsa.GenerateKey(rand.Reader, 2048)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("生成密钥失败: %v", err)
+		}
+		privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+		if err != nil {
+			return nil, err
+		}
+		public, err = x509.MarshalPKIXPublicKey(priv.Public())
+		if err != nil {
+			return nil, err
+		}
+		key.PrivateKey = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}))
+		key.PublicKey = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public}))
+	default:
+		return nil, fmt.Errorf("不支持的签名算法: %s（可选 %s、%s、%s）", algorithm, AlgHS256, AlgEdDSA, AlgRS256)
+	}
+	sum := sha256.Sum256(public)
+	key.ID = hex.EncodeToString(sum[:8])
+	return key, key.decode()
+}
+
+// decode 解析密钥材料，填充签名和校验所用的密钥对象。
+func (k *Key) decode() error {
+	switch k.Algorithm {
+	case AlgHS256:
+		secret, err := base64.StdEncoding.DecodeString(k.Secret)
+		if err != nil || len(secret) == 0 {
+			return errors.New("HS256 密钥必须是非空的 Base64 字符串")
+		}
+		k.signKey, k.verifyKey = secret, secret
+	case AlgEdDSA:
+		pub, err := jwt.ParseEdPublicKeyFromPEM([]byte(k.PublicKey))
+		if err != nil {
+			return fmt.Errorf("解析公钥失败: %v", err)
+		}
+		k.verifyKey = pub
+		// 只有公钥的密钥只能用于校验，例如从其他服务导入的密钥
+		if k.PrivateKey != "" {
+			if k.signKey, err = jwt.ParseEdPrivateKeyFromPEM([]byte(k.PrivateKey)); err != nil {
+				return fmt.Errorf("解析私钥失败: %v", err)
+			}
+		}
+	case AlgRS256:
+		pub, err := jwt.ParseRSAPublicKeyFromPEM([]byte(k.PublicKey))
+		if err != nil {
+			return fmt.Errorf("解析公钥失败: %v", err)
+		}
+		k.verifyKey = pub
+		if k.PrivateKey != "" {
+			if k.signKey, err = jwt.ParseRSAPrivateKeyFromPEM([]byte(k.PrivateKey)); err != nil {
+				return fmt.Errorf("解析私钥失败: %v", err)
+			}
+		}
+	default:
+		return fmt.Errorf("不支持的签名算法: %s", k.Algorithm)
+	}
+	if k.Status == StatusCurrent && k.signKey == nil {
+		return errors.New("当前密钥缺少私钥，无法签发令牌")
+	}
+	return nil
+}
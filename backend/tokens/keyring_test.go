@@ -0,0 +1,265 @@
+package tokens
+
+import (
+	"backend/models"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const testSecret = "test-jwt-secret"
+
+// newTestRing 在临时目录中由 testSecret 创建密钥环，返回密钥环及其文件路径。
+func newTestRing(t *testing.T, algorithm string) (*Keyring, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	ring, err := LoadKeyring(path, testSecret, algorithm, time.Hour)
+	if err != nil {
+		t.Fatalf("加载密钥环失败: %v", err)
+	}
+	return ring, path
+}
+
+// testClaims 返回一组能通过 Manager 校验的声明。
+func testClaims(m *Manager) Claims {
+	now := m.now()
+	return Claims{
+		UserID:    1,
+		SessionID: "s1",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    m.issuer,
+			Audience:  jwt.ClaimStrings{m.audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(m.ttl)),
+		},
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	if _, err := LoadKeyring(path, "", AlgHS256, time.Hour); err == nil {
+		t.Fatal("文件不存在且没有 jwt_secret_key 时应当失败")
+	}
+
+	ring, err := LoadKeyring(path, testSecret, AlgHS256, time.Hour)
+	if err != nil {
+		t.Fatalf("导入 jwt_secret_key 失败: %v", err)
+	}
+	if k := ring.Current(); k == nil || k.ID != LegacyKeyID || k.Algorithm != AlgHS256 {
+		t.Fatalf("当前密钥应为导入的 HS256 密钥，实际为 %+v", k)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("密钥环文件没有保存: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("密钥环文件权限为 %o，期望 600", perm)
+	}
+
+	// 再次加载时使用文件中的密钥，不受 jwt_secret_key 影响
+	reloaded, err := LoadKeyring(path, "another-secret", AlgHS256, time.Hour)
+	if err != nil {
+		t.Fatalf("重新加载密钥环失败: %v", err)
+	}
+	if got, want := reloaded.Current().Secret, ring.Current().Secret; got != want {
+		t.Errorf("重新加载后的密钥为 %q，期望 %q", got, want)
+	}
+}
+
+func TestLoadKeyringWithAlgorithmRotates(t *testing.T) {
+	ring, _ := newTestRing(t, AlgEdDSA)
+	if k := ring.Current(); k.Algorithm != AlgEdDSA {
+		t.Fatalf("当前密钥算法为 %s，期望 %s", k.Algorithm, AlgEdDSA)
+	}
+	legacy, ok := ring.Lookup(LegacyKeyID)
+	if !ok || legacy.Status != StatusRetired {
+		t.Fatalf("导入的密钥应当退役并继续用于校验，实际为 %+v", legacy)
+	}
+}
+
+func TestLoadKeyringRequiresOneCurrentKey(t *testing.T) {
+	ring, path := newTestRing(t, AlgHS256)
+	if _, err := ring.Generate(AlgEdDSA); err != nil {
+		t.Fatalf("生成密钥失败: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		statuses []string
+	}{
+		{"没有当前密钥", []string{StatusRetired, StatusNext}},
+		{"两把当前密钥", []string{StatusCurrent, StatusCurrent}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys := ring.Keys()
+			for i := range keys {
+				keys[i].Status = tt.statuses[i]
+				keys[i].ExpiresAt = time.Now().Add(time.Hour)
+			}
+			data, err := json.Marshal(keys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, data, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadKeyring(path, testSecret, AlgHS256, time.Hour); err == nil {
+				t.Error("密钥环文件中当前密钥不是一把时应当加载失败")
+			}
+		})
+	}
+}
+
+func TestRotateKeepsRetiredTokensValid(t *testing.T) {
+	ring, path := newTestRing(t, AlgHS256)
+	m := NewManager(&models.Config{}, ring)
+	token, err := m.Issue(1, "s1")
+	if err != nil {
+		t.Fatalf("签发令牌失败: %v", err)
+	}
+
+	kid, err := ring.Rotate(AlgEdDSA)
+	if err != nil {
+		t.Fatalf("轮换密钥失败: %v", err)
+	}
+	if ring.Current().ID != kid {
+		t.Fatalf("轮换后当前密钥为 %s，期望 %s", ring.Current().ID, kid)
+	}
+	if _, err := m.Verify(token); err != nil {
+		t.Fatalf("轮换后旧密钥签发的令牌应当继续有效: %v", err)
+	}
+	fresh, err := m.Issue(1, "s1")
+	if err != nil {
+		t.Fatalf("签发令牌失败: %v", err)
+	}
+	if _, err := m.Verify(fresh); err != nil {
+		t.Fatalf("新密钥签发的令牌校验失败: %v", err)
+	}
+
+	// 轮换结果已写入文件，重启后旧令牌同样有效
+	reloaded, err := LoadKeyring(path, testSecret, AlgHS256, time.Hour)
+	if err != nil {
+		t.Fatalf("重新加载密钥环失败: %v", err)
+	}
+	if _, err := NewManager(&models.Config{}, reloaded).Verify(token); err != nil {
+		t.Fatalf("重新加载后旧令牌应当继续有效: %v", err)
+	}
+
+	// 退役密钥过期后不再接受它签发的令牌
+	legacy, _ := ring.Lookup(LegacyKeyID)
+	legacy.ExpiresAt = time.Now().Add(-time.Second)
+	if _, err := m.Verify(token); !errors.Is(err, ErrSignature) {
+		t.Fatalf("退役密钥过期后校验结果为 %v，期望 ErrSignature", err)
+	}
+}
+
+func TestRotateToNextKey(t *testing.T) {
+	ring, _ := newTestRing(t, AlgHS256)
+	kid, err := ring.Generate(AlgRS256)
+	if err != nil {
+		t.Fatalf("生成密钥失败: %v", err)
+	}
+	if ring.Current().ID != LegacyKeyID {
+		t.Fatal("生成的密钥在启用前不应成为当前密钥")
+	}
+	if got, err := ring.Rotate(kid); err != nil || got != kid {
+		t.Fatalf("启用密钥 %s 返回 %s, %v", kid, got, err)
+	}
+	if _, err := ring.Rotate(LegacyKeyID); err == nil {
+		t.Error("启用已退役的密钥应当失败")
+	}
+	if _, err := ring.Rotate("ES256"); err == nil {
+		t.Error("不支持的算法应当失败")
+	}
+}
+
+func TestPrune(t *testing.T) {
+	ring, path := newTestRing(t, AlgHS256)
+	if _, err := ring.Rotate(AlgHS256); err != nil {
+		t.Fatalf("轮换密钥失败: %v", err)
+	}
+	legacy, _ := ring.Lookup(LegacyKeyID)
+
+	ring.prune(legacy.ExpiresAt)
+	if len(ring.Keys()) != 2 {
+		t.Fatal("退役密钥在 ExpiresAt 之前不应被移除")
+	}
+
+	// 加载时移除文件中已过期的退役密钥
+	keys := ring.Keys()
+	for i := range keys {
+		if keys[i].ID == LegacyKeyID {
+			keys[i].ExpiresAt = time.Now().Add(-time.Minute)
+		}
+	}
+	data, _ := json.Marshal(keys)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := LoadKeyring(path, testSecret, AlgHS256, time.Hour)
+	if err != nil {
+		t.Fatalf("重新加载密钥环失败: %v", err)
+	}
+	if _, ok := reloaded.Lookup(LegacyKeyID); ok || len(reloaded.Keys()) != 1 {
+		t.Errorf("已过期的退役密钥应当被移除，实际剩余 %d 把", len(reloaded.Keys()))
+	}
+
+	ring.prune(legacy.ExpiresAt.Add(time.Second))
+	if _, ok := ring.Lookup(LegacyKeyID); ok || len(ring.Keys()) != 1 {
+		t.Errorf("已过期的退役密钥应当被移除，实际剩余 %d 把", len(ring.Keys()))
+	}
+}
+
+func TestVerifyLegacyTokenWithoutKid(t *testing.T) {
+	ring, _ := newTestRing(t, AlgEdDSA)
+	m := NewManager(&models.Config{}, ring)
+
+	// 引入密钥环之前签发的令牌没有 kid 头，直接用 jwt_secret_key 签名
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims(m)).SignedString([]byte(testSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := m.Verify(token)
+	if err != nil {
+		t.Fatalf("没有 kid 的旧令牌应当按导入的密钥校验: %v", err)
+	}
+	if claims.UserID != 1 || claims.SessionID != "s1" {
+		t.Errorf("声明为 %+v", claims)
+	}
+
+	forged, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims(m)).SignedString([]byte("wrong-secret"))
+	if _, err := m.Verify(forged); !errors.Is(err, ErrSignature) {
+		t.Errorf("用其他密钥签名的旧令牌校验结果为 %v，期望 ErrSignature", err)
+	}
+}
+
+func TestVerifyRejectsAlgorithmConfusion(t *testing.T) {
+	for _, alg := range []string{AlgEdDSA, AlgRS256} {
+		t.Run(alg, func(t *testing.T) {
+			ring, _ := newTestRing(t, AlgHS256)
+			kid, err := ring.Generate(alg)
+			if err != nil {
+				t.Fatalf("生成密钥失败: %v", err)
+			}
+			key, _ := ring.Lookup(kid)
+			m := NewManager(&models.Config{}, ring)
+
+			// 以公开的公钥作为 HMAC 密钥伪造令牌，并在 kid 中指向该非对称密钥
+			token := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims(m))
+			token.Header["kid"] = kid
+			forged, err := token.SignedString([]byte(key.PublicKey))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := m.Verify(forged); !errors.Is(err, ErrSignature) {
+				t.Errorf("算法与密钥不一致的令牌校验结果为 %v，期望 ErrSignature", err)
+			}
+		})
+	}
+}
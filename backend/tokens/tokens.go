@@ -0,0 +1,146 @@
+// Package tokens 负责访问令牌的签发和校验。
+// HTTP 中间件、WebSocket 连接和诊断接口都通过同一个 Manager 校验令牌，
+// 认证规则的修改只需要在这里完成一次。
+package tokens
+
+import (
+	"backend/models"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var (
+	// ErrMalformed 表示令牌不是格式正确的 JWT。
+	ErrMalformed = errors.New("令牌格式无效")
+	// ErrSignature 表示令牌的签名算法不被接受或签名校验失败。
+	ErrSignature = errors.New("令牌签名无效")
+	// ErrExpired 表示令牌已过期或尚未生效。
+	ErrExpired = errors.New("令牌已过期")
+	// ErrClaims 表示令牌的签发者、受众或必填声明不符合要求。
+	ErrClaims = errors.New("令牌声明无效")
+)
+
+// Claims 是访问令牌携带的声明。
+type Claims struct {
+	// UserID 是令牌所属的用户
+	UserID int `json:"user_id"`
+	// SessionID 是令牌所属的会话，会话被吊销后令牌随之失效
+	SessionID string `json:"sid"`
+	jwt.RegisteredClaims
+}
+
+// Manager 签发和校验访问令牌。
+// 令牌用密钥环中的当前密钥签名，并在 kid 头中注明密钥 ID；校验时按 kid 选择密钥。
+type Manager struct {
+	ring     *Keyring
+	issuer   string
+	audience string
+	ttl      time.Duration
+	// now 返回当前时间，便于在测试中替换
+	now func() time.Time
+}
+
+// NewManager 根据配置和密钥环创建令牌管理器。
+func NewManager(config *models.Config, ring *Keyring) *Manager {
+	return &Manager{
+		ring:     ring,
+		issuer:   config.TokenIssuer(),
+		audience: config.TokenAudience(),
+		ttl:      config.AccessTokenTTL(),
+		now:      time.Now,
+	}
+}
+
+// TTL 返回访问令牌的有效期。
+func (m *Manager) TTL() time.Duration {
+	return m.ttl
+}
+
+// Issue 为指定用户的会话签发访问令牌。
+func (m *Manager) Issue(userID int, sessionID string) (string, error) {
+	now := m.now()
+	claims := Claims{
+		UserID:    userID,
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    m.issuer,
+			Audience:  jwt.ClaimStrings{m.audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(m.ttl)),
+		},
+	}
+	key := m.ring.Current()
+	if key == nil {
+		return "", errors.New("密钥环中没有当前密钥")
+	}
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.signKey)
+}
+
+// Keyring 返回签发令牌所用的密钥环。
+func (m *Manager) Keyring() *Keyring {
+	return m.ring
+}
+
+// Verify 校验令牌的签名、签发者、受众和有效期，返回其中的声明。
+// 返回的错误可以用 errors.Is 与本包导出的错误比较，错误信息不包含令牌内容。
+func (m *Manager) Verify(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	parser := jwt.NewParser(
+		jwt.WithValidMethods([]string{AlgHS256, AlgEdDSA, AlgRS256}),
+		// 时间相关的声明在下面统一检查，这样可以使用可替换的当前时间
+		jwt.WithoutClaimsValidation(),
+	)
+	_, err := parser.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		// 引入密钥环之前签发的令牌没有 kid 头
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			kid = LegacyKeyID
+		}
+		key, ok := m.ring.Lookup(kid)
+		if !ok {
+			return nil, ErrUnknownKey
+		}
+		// 令牌头声明的算法必须与密钥一致，防止用公钥冒充 HMAC 密钥等算法混淆攻击
+		if token.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("令牌算法 %s 与密钥 %s 的算法 %s 不一致", token.Method.Alg(), kid, key.Algorithm)
+		}
+		return key.verifyKey, nil
+	})
+	if err != nil {
+		var ve *jwt.ValidationError
+		if errors.As(err, &ve) && ve.Errors&jwt.ValidationErrorMalformed != 0 {
+			return nil, ErrMalformed
+		}
+		if errors.Is(err, ErrUnknownKey) {
+			return nil, fmt.Errorf("%w: %v", ErrSignature, ErrUnknownKey)
+		}
+		return nil, fmt.Errorf("%w: %v", ErrSignature, err)
+	}
+
+	now := m.now()
+	if !claims.VerifyExpiresAt(now, true) {
+		return nil, ErrExpired
+	}
+	if !claims.VerifyNotBefore(now, false) {
+		return nil, ErrExpired
+	}
+	if !claims.VerifyIssuer(m.issuer, true) {
+		return nil, fmt.Errorf("%w: 签发者 %q 不匹配", ErrClaims, claims.Issuer)
+	}
+	if !claims.VerifyAudience(m.audience, true) {
+		return nil, fmt.Errorf("%w: 受众 %q 不匹配", ErrClaims, []string(claims.Audience))
+	}
+	if claims.UserID <= 0 {
+		return nil, fmt.Errorf("%w: 缺少用户ID", ErrClaims)
+	}
+	// 引入会话之前签发的令牌没有 sid，无法吊销，一律要求重新登录
+	if claims.SessionID == "" {
+		return nil, fmt.Errorf("%w: 缺少会话ID", ErrClaims)
+	}
+	return claims, nil
+}